@@ -0,0 +1,152 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 番剧接口的域名, 测试时可以替换成本地服务
+var gBilibiliApiHost = "https://api.bilibili.com"
+
+const _pgcSeasonByEpUrlTemp = "%s/pgc/view/web/season?ep_id=%d"
+const _pgcSeasonBySsUrlTemp = "%s/pgc/view/web/season?season_id=%d"
+const _pgcMediaUrlTemp = "%s/pgc/review/user?media_id=%d"
+const _pgcPlayUrlTemp = "%s/pgc/player/web/playurl?avid=%d&cid=%d&ep_id=%d&qn=%d&fnver=0&fnval=%d&fourk=1"
+
+type pgcEpisode struct {
+	Aid       int64  `json:"aid"`
+	Bvid      string `json:"bvid"`
+	Cid       int64  `json:"cid"`
+	Id        int64  `json:"id"`
+	Title     string `json:"title"`
+	LongTitle string `json:"long_title"`
+}
+
+// getVideoInfoList_Bangumi idType: ep 单集, ss 整季, md 剧集媒体id(会先转换为ss), 整季时只解析 selector 选中的剧集
+func (this *BilibiliDownloader) getVideoInfoList_Bangumi(idType string, id int64, selector pageSelector) (info VideoInfo, err error) {
+	if idType == "md" {
+		seasonId, err := this.getBangumiSeasonIdByMediaId(id)
+		if err != nil {
+			return info, err
+		}
+		idType, id = "ss", seasonId
+	}
+	urlApi := fmt.Sprintf(_pgcSeasonBySsUrlTemp, gBilibiliApiHost, id)
+	if idType == "ep" {
+		urlApi = fmt.Sprintf(_pgcSeasonByEpUrlTemp, gBilibiliApiHost, id)
+	}
+	seasonContents, err := this.defaultFetcher(urlApi)
+	if err != nil {
+		return info, wrapError("getVideoInfoList_Bangumi", err)
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Result  struct {
+			SeasonId int64        `json:"season_id"`
+			Title    string       `json:"title"`
+			Cover    string       `json:"cover"`
+			Episodes []pgcEpisode `json:"episodes"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(seasonContents, &tmp)
+	if err != nil {
+		return info, wrapError("getVideoInfoList_Bangumi_2", err)
+	}
+	if tmp.Code != 0 {
+		return info, apiCodeError("获取番剧信息失败", tmp.Code, tmp.Message)
+	}
+	title := TitleEdit(tmp.Result.Title)
+	this.fnMessage("番剧名: " + title)
+
+	var episodes []pgcEpisode
+	var indexList []int // 剧集在整季中的序号
+	for idx, ep := range tmp.Result.Episodes {
+		if idType == "ep" && ep.Id != id {
+			continue
+		}
+		if idType != "ep" && selector.match(idx+1, len(tmp.Result.Episodes)) == false {
+			continue
+		}
+		episodes = append(episodes, ep)
+		indexList = append(indexList, idx+1)
+	}
+
+	if len(episodes) == 0 && idType != "ep" && len(tmp.Result.Episodes) > 0 {
+		return info, newError("获取番剧信息失败", nil, errors.New("没有选中任何剧集"))
+	}
+	info.Title = tmp.Result.Title
+	info.CoverUrl = tmp.Result.Cover
+	var epIdList []int64
+	for idx, ep := range episodes {
+		contents, err := this.defaultFetcher(fmt.Sprintf(_pgcPlayUrlTemp, gBilibiliApiHost, ep.Aid, ep.Cid, ep.Id, this.getQn(), _fnvalDash))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_Bangumi_3", err)
+		}
+		var play struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Result  cidL1  `json:"result"`
+		}
+		err = json.Unmarshal(contents, &play)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_Bangumi_4", err)
+		}
+		if play.Code != 0 {
+			return info, apiCodeError("获取剧集播放地址失败", play.Code, play.Message)
+		}
+		group := TitleEdit(ep.Title)
+		if ep.LongTitle != "" {
+			group += "_" + TitleEdit(ep.LongTitle)
+		}
+		referer := "https://www.bilibili.com/bangumi/play/ep" + strconv.FormatInt(ep.Id, 10)
+		partList, err := this.buildPlayPartList(group, referer, play.Result)
+		if err != nil {
+			return info, err
+		}
+		info.PartList = append(info.PartList, partList...)
+		info.PageList = append(info.PageList, newPageInfo(indexList[idx], group, strings.TrimSpace(ep.Title+" "+ep.LongTitle), play.Result))
+		info.SidecarList = append(info.SidecarList, this.getSubtitleSidecarList(ep.Aid, ep.Cid, group)...)
+		info.SidecarList = append(info.SidecarList, this.getDanmakuSidecarList(ep.Cid, group)...)
+		epIdList = append(epIdList, ep.Id)
+	}
+	if len(info.PartList) == 0 {
+		return info, newError("获取番剧信息失败", ErrVideoNotFound, nil)
+	}
+	info.SidecarList = append(info.SidecarList, this.getPgcMetadataSidecarList(seasonContents, info.PageList, epIdList)...)
+	info.Name = fmt.Sprintf("ss%d_%s", tmp.Result.SeasonId, title)
+	if idType == "ep" {
+		info.Name += "_" + info.PartList[0].Group
+	}
+	return info, nil
+}
+
+func (this *BilibiliDownloader) getBangumiSeasonIdByMediaId(mediaId int64) (seasonId int64, err error) {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_pgcMediaUrlTemp, gBilibiliApiHost, mediaId))
+	if err != nil {
+		return 0, wrapError("getBangumiSeasonIdByMediaId", err)
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Result  struct {
+			Media struct {
+				SeasonId int64 `json:"season_id"`
+			} `json:"media"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err != nil {
+		return 0, wrapError("getBangumiSeasonIdByMediaId_2", err)
+	}
+	if tmp.Code != 0 {
+		return 0, apiCodeError("获取番剧信息失败", tmp.Code, tmp.Message)
+	}
+	if tmp.Result.Media.SeasonId == 0 {
+		return 0, newError("获取番剧信息失败", ErrVideoNotFound, nil)
+	}
+	return tmp.Result.Media.SeasonId, nil
+}
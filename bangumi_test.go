@@ -0,0 +1,175 @@
+package bilibili
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestPgcServer 一季4集的番剧, season_id=100, media_id=2000, ep_id=1001~1004
+func newTestPgcServer(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/pgc/review/user":
+			if query.Get("media_id") != "2000" {
+				w.Write([]byte(`{"code":-404,"message":"啥都木有"}`))
+				return
+			}
+			w.Write([]byte(`{"code":0,"result":{"media":{"season_id":100}}}`))
+		case "/pgc/view/web/season":
+			epId, _ := strconv.Atoi(query.Get("ep_id"))
+			if query.Get("season_id") != "100" && (epId < 1001 || epId > 1004) {
+				w.Write([]byte(`{"code":-404,"message":"啥都木有"}`))
+				return
+			}
+			var epList []string
+			for i := 1; i <= 4; i++ {
+				epList = append(epList, fmt.Sprintf(`{"aid":%d,"bvid":"","cid":%d,"id":%d,"title":"%d","long_title":"第%d话","pub_time":%d}`, 500+i, 600+i, 1000+i, i, i, 1700049600+i*7*86400))
+			}
+			fmt.Fprintf(w, `{"code":0,"result":{"season_id":100,"season_title":"第一季","title":"测试番剧","cover":"http://example.com/cover.jpg","evaluate":"简介","publish":{"pub_time":"2023-11-15 10:00:00"},"episodes":[%s]}}`, strings.Join(epList, ","))
+		case "/pgc/player/web/playurl":
+			epId := query.Get("ep_id")
+			if query.Get("avid") == "" || query.Get("cid") == "" {
+				w.Write([]byte(`{"code":-400,"message":"请求错误"}`))
+				return
+			}
+			fmt.Fprintf(w, `{"code":0,"result":{"format":"flv","quality":80,"timelength":1500,"accept_quality":[80,64],"accept_description":["1080P 高清","720P 高清"],"durl":[{"order":1,"size":100,"url":"https://example.com/ep%s.flv"}]}}`, epId)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestBangumi(t *testing.T) {
+	newTestPgcServer(t)
+	tests := []struct {
+		url       string
+		pages     string // BeginDownload_Req.Pages
+		name      string
+		indexList []int
+		groupList []string
+	}{
+		{"https://www.bilibili.com/bangumi/play/ep1002", "", "ss100_测试番剧_2_第2话", []int{2}, []string{"2_第2话"}},
+		{"https://www.bilibili.com/bangumi/play/ep1002", "1-3", "ss100_测试番剧_2_第2话", []int{2}, []string{"2_第2话"}}, // 单集时忽略分P参数
+		{"https://www.bilibili.com/bangumi/play/ss100", "", "ss100_测试番剧", []int{1, 2, 3, 4}, []string{"1_第1话", "2_第2话", "3_第3话", "4_第4话"}},
+		{"https://www.bilibili.com/bangumi/play/ss100?p=2-3", "", "ss100_测试番剧", []int{2, 3}, []string{"2_第2话", "3_第3话"}},
+		{"https://www.bilibili.com/bangumi/play/ss100", "1,3-", "ss100_测试番剧", []int{1, 3, 4}, []string{"1_第1话", "3_第3话", "4_第4话"}},
+		{"https://www.bilibili.com/bangumi/media/md2000", "latest", "ss100_测试番剧", []int{4}, []string{"4_第4话"}},
+		{"md2000", "", "ss100_测试番剧", []int{1, 2, 3, 4}, []string{"1_第1话", "2_第2话", "3_第3话", "4_第4话"}},
+	}
+	for _, tt := range tests {
+		d := newBilibiliDownloader(BeginDownload_Req{Url: tt.url, Pages: tt.pages})
+		d.sink = gDiscardEventSink
+		info, err := d.getVideoInfo(tt.url)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.url, tt.pages, err)
+			continue
+		}
+		var indexList []int
+		var groupList []string
+		for _, page := range info.PageList {
+			indexList = append(indexList, page.Index)
+			groupList = append(groupList, page.Group)
+		}
+		if info.Name != tt.name || reflect.DeepEqual(indexList, tt.indexList) == false || reflect.DeepEqual(groupList, tt.groupList) == false {
+			t.Errorf("%s %s: got %s %v %v", tt.url, tt.pages, info.Name, indexList, groupList)
+			continue
+		}
+		if info.Title != "测试番剧" || info.CoverUrl != "http://example.com/cover.jpg" || len(info.PartList) != len(tt.indexList) {
+			t.Errorf("%s %s: %+v", tt.url, tt.pages, info)
+			continue
+		}
+		for idx, part := range info.PartList {
+			epId := 1000 + tt.indexList[idx]
+			if part.DownloadUrl != fmt.Sprintf("https://example.com/ep%d.flv", epId) || part.Group != tt.groupList[idx] {
+				t.Errorf("%s %s: part %+v", tt.url, tt.pages, part)
+			}
+			if referer := part.Header.Get("Referer"); referer != fmt.Sprintf("https://www.bilibili.com/bangumi/play/ep%d", epId) {
+				t.Errorf("%s %s: referer %s", tt.url, tt.pages, referer)
+			}
+		}
+	}
+}
+
+func TestBangumiError(t *testing.T) {
+	newTestPgcServer(t)
+	tests := []struct {
+		url   string
+		pages string
+		want  string
+	}{
+		{"https://www.bilibili.com/bangumi/play/ss100", "9", "没有选中任何剧集"},
+		{"https://www.bilibili.com/bangumi/play/ss101", "", "啥都木有"},
+		{"https://www.bilibili.com/bangumi/media/md2001", "", "啥都木有"},
+	}
+	for _, tt := range tests {
+		d := newBilibiliDownloader(BeginDownload_Req{Url: tt.url, Pages: tt.pages})
+		d.sink = gDiscardEventSink
+		_, err := d.getVideoInfo(tt.url)
+		if err == nil || strings.Contains(err.Error(), tt.want) == false {
+			t.Errorf("%s %s: got %v, want %s", tt.url, tt.pages, err, tt.want)
+		}
+	}
+}
+
+// TestBangumiMetadata 整季是 tvshow 加每集的 episodedetails, 单集是 movie
+func TestBangumiMetadata(t *testing.T) {
+	newTestPgcServer(t)
+	tests := []struct {
+		url  string
+		want map[string]string // Group+FileName+Suffix -> 需要包含的内容
+	}{
+		{"https://www.bilibili.com/bangumi/play/ss100?p=2-3", map[string]string{
+			".info.json": `"season_title": "第一季"`,
+			"poster.jpg": "",
+			"tvshow.nfo": "<tvshow>\n  <title>测试番剧</title>\n  <plot>简介</plot>\n  <premiered>2023-11-15</premiered>\n  <year>2023</year>\n  <studio>bilibili</studio>\n  <thumb>https://example.com/cover.jpg</thumb>\n  <uniqueid type=\"bilibili\" default=\"true\">ss100</uniqueid>\n</tvshow>",
+			"2_第2话.nfo":  "<episodedetails>\n  <title>2 第2话</title>\n  <showtitle>测试番剧</showtitle>\n  <season>1</season>\n  <episode>2</episode>\n  <aired>2023-11-29</aired>\n  <runtime>1</runtime>\n  <uniqueid type=\"bilibili\" default=\"true\">ep1002</uniqueid>\n</episodedetails>",
+			"3_第3话.nfo":  "<episode>3</episode>",
+		}},
+		{"https://www.bilibili.com/bangumi/play/ep1004", map[string]string{
+			".info.json": `"evaluate": "简介"`,
+			"poster.jpg": "",
+			".nfo":       "<movie>\n  <title>测试番剧 4 第4话</title>\n  <plot>简介</plot>\n  <premiered>2023-11-15</premiered>\n  <year>2023</year>\n  <runtime>1</runtime>\n  <studio>bilibili</studio>\n  <thumb>https://example.com/cover.jpg</thumb>\n  <uniqueid type=\"bilibili\" default=\"true\">ep1004</uniqueid>\n</movie>",
+		}},
+	}
+	for _, tt := range tests {
+		d := newBilibiliDownloader(BeginDownload_Req{Url: tt.url, Metadata: true})
+		d.sink = gDiscardEventSink
+		info, err := d.getVideoInfo(tt.url)
+		if err != nil {
+			t.Fatal(tt.url, err)
+		}
+		got := map[string]string{}
+		for _, one := range info.SidecarList {
+			key := one.Group + one.FileName
+			if one.FileName == "" {
+				key += one.Suffix
+			}
+			if one.Url != "" && one.Url != "https://example.com/cover.jpg@.jpg" {
+				t.Errorf("%s: cover %s", tt.url, one.Url)
+			}
+			got[key] = string(one.Data)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d sidecar", tt.url, len(got))
+		}
+		for key, want := range tt.want {
+			data, ok := got[key]
+			if ok == false || strings.Contains(data, want) == false {
+				t.Errorf("%s %s:\n%s", tt.url, key, data)
+			}
+		}
+	}
+
+	// 不需要元数据时不生成
+	d := newBilibiliDownloader(BeginDownload_Req{})
+	d.sink = gDiscardEventSink
+	info, err := d.getVideoInfo("ss100")
+	if err != nil || len(info.SidecarList) != 0 {
+		t.Fatal(err, info.SidecarList)
+	}
+}
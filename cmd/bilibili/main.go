@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/orestonce/bilibili"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usage = `用法:
+  bilibili [download] [选项] URL...   下载视频
+  bilibili info [选项] URL...         只解析视频信息, 列出可选的清晰度, 不下载
+  bilibili batch [选项] FILE          从文件中读取URL(每行一个)依次下载
+  bilibili whoami [选项]              检查cookie是否有效, 以及大会员等级
+  bilibili login [-png FILE]          扫码登录, cookie保存在 ~/.bilibili_session.txt, 之后的下载自动使用
+  bilibili watch [选项] 房间号...        监控直播间, 开播时自动录制, 下播时停止
+
+选项:
+  -o 下载目录
+  -q 清晰度qn, 例如 80(1080P) 64(720P), 默认最高
+  -codec 视频编码 avc/hevc/av1, 默认avc
+  -aq 音质id 30216(64K) 30232(132K) 30280(192K) 30250(杜比) 30251(无损), 默认最高
+  -audio 只下载音频, 保存为m4a, 无损音频保存为flac
+  -p 要下载的分P, 例如 5 1-10,15 all latest, 默认使用网址里的p参数, 没有时下载全部
+  -mp4 flv格式的视频合并后转换为mp4, m3u8下载的ts也转换为mp4
+  -j batch 同时下载的任务数量, 默认1
+  -after UP主投稿等列表只下载这个日期之后发布的视频, 格式 2006-01-02
+  -before UP主投稿等列表只下载这个日期之前发布的视频, 格式 2006-01-02
+  -max UP主投稿等列表最多下载多少个视频
+  -keyword UP主投稿等列表只下载标题包含关键字的视频
+  -json info 以json格式输出
+  -cookie 浏览器里复制的 Cookie 请求头, 例如 "SESSDATA=xxx"
+  -cookies Netscape 格式的 cookies.txt
+  -sub 下载CC字幕并转换为 srt/vtt/ass
+  -sub-lang 字幕语言, 以逗号分隔, 例如 zh-CN,en-US, 默认所有语言
+  -meta 保存 .info.json/封面/nfo, 可以直接给 Kodi/Jellyfin 使用
+  -split-time 直播录制按时长分割, 例如 30m 1h
+  -split-size 直播录制按大小分割, 单位MB
+  -dm 保存弹幕xml并转换为ass字幕, 录制直播时同时保存实时弹幕(jsonl/ass)
+  -dm-size 弹幕字号, 按1080p计算, 默认50
+  -dm-opacity 弹幕不透明度 0~1, 默认0.8
+  -dm-area 滚动弹幕占屏幕高度的比例 0~1, 默认1
+  -dm-max 同屏最多显示的弹幕数量, 默认不限制
+  -interval watch 检查直播间的间隔, 默认1m
+  -hls-height m3u8有多个清晰度时选择的最大高度, 例如 720, 默认最高
+  -hls-threads m3u8同时下载的分片数量, 默认4
+  -state watch 保存直播间状态的文件, 重启后继续监控, 不指定房间号时使用文件里的房间
+`
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	cmd := "download"
+	if len(args) > 0 {
+		switch args[0] {
+		case "download", "info", "batch", "whoami", "login", "watch":
+			cmd = args[0]
+			args = args[1:]
+		case "-h", "-help", "--help", "help":
+			fmt.Fprint(os.Stderr, usage)
+			return 0
+		}
+	}
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	wd, _ := os.Getwd()
+	req := bilibili.BeginDownload_Req{}
+	fs.StringVar(&req.SaveDir, "o", wd, "下载目录")
+	fs.IntVar(&req.Quality, "q", 0, "清晰度qn")
+	fs.StringVar(&req.Codec, "codec", "", "视频编码")
+	fs.IntVar(&req.AudioQuality, "aq", 0, "音质id")
+	fs.BoolVar(&req.AudioOnly, "audio", false, "只下载音频")
+	fs.StringVar(&req.Pages, "p", "", "要下载的分P")
+	fs.StringVar(&req.Cookie, "cookie", "", "Cookie 请求头")
+	fs.StringVar(&req.CookieFile, "cookies", "", "cookies.txt")
+	pngFile := fs.String("png", "", "login 同时把二维码保存为png图片")
+	fs.StringVar(&req.SubtitleFormat, "sub", "", "字幕格式")
+	subLang := fs.String("sub-lang", "", "字幕语言")
+	fs.BoolVar(&req.Metadata, "meta", false, "保存元数据")
+	fs.DurationVar(&req.Live.SplitDuration, "split-time", 0, "直播录制按时长分割")
+	splitSize := fs.Int64("split-size", 0, "直播录制按大小分割(MB)")
+	fs.BoolVar(&req.Danmaku.Enable, "dm", false, "下载弹幕")
+	fs.IntVar(&req.Danmaku.FontSize, "dm-size", 0, "弹幕字号")
+	fs.Float64Var(&req.Danmaku.Opacity, "dm-opacity", 0, "弹幕不透明度")
+	fs.Float64Var(&req.Danmaku.DisplayArea, "dm-area", 0, "滚动弹幕显示区域")
+	fs.IntVar(&req.Danmaku.MaxOnScreen, "dm-max", 0, "同屏最多弹幕数量")
+	fs.BoolVar(&req.FlvToMp4, "mp4", false, "flv转换为mp4")
+	fs.IntVar(&req.Hls.MaxHeight, "hls-height", 0, "m3u8最大高度")
+	fs.IntVar(&req.Hls.Threads, "hls-threads", 0, "m3u8同时下载的分片数量")
+	jobs := fs.Int("j", 1, "同时下载的任务数量")
+	jsonOutput := fs.Bool("json", false, "以json格式输出")
+	after := fs.String("after", "", "只下载这个日期之后发布的视频")
+	before := fs.String("before", "", "只下载这个日期之前发布的视频")
+	fs.IntVar(&req.Filter.MaxCount, "max", 0, "最多下载多少个视频")
+	fs.StringVar(&req.Filter.Keyword, "keyword", "", "标题包含关键字")
+	interval := fs.Duration("interval", time.Minute, "检查直播间的间隔")
+	stateFile := fs.String("state", "", "直播间状态文件")
+	if fs.Parse(args) != nil {
+		return 2
+	}
+	req.Live.SplitSize = *splitSize * 1024 * 1024
+	if *subLang != "" {
+		req.SubtitleLanList = strings.Split(*subLang, ",")
+	}
+	var err error
+	if req.Filter.After, err = parseDate(*after, 0); err != nil {
+		fmt.Fprintln(os.Stderr, "-after: "+err.Error())
+		return 2
+	}
+	if req.Filter.Before, err = parseDate(*before, 24*time.Hour); err != nil {
+		fmt.Fprintln(os.Stderr, "-before: "+err.Error())
+		return 2
+	}
+	switch cmd {
+	case "info":
+		return runInfo(fs.Args(), req, *jsonOutput)
+	case "whoami":
+		return runWhoami(req)
+	case "login":
+		return runLogin(*pngFile)
+	case "watch":
+		return runWatch(fs.Args(), req, *interval, *stateFile)
+	case "batch":
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		urlList, err := readUrlFile(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *jobs > 1 {
+			return runBatchParallel(urlList, req, *jobs)
+		}
+		return runDownload(urlList, req)
+	default:
+		return runDownload(fs.Args(), req)
+	}
+}
+
+// parseDate 解析 2006-01-02 格式的本地日期, 加上 offset 后返回, 空字符串返回零值
+func parseDate(value string, offset time.Duration) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return t, err
+	}
+	return t.Add(offset), nil
+}
+
+func readUrlFile(fileName string) (urlList []string, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urlList = append(urlList, line)
+	}
+	return urlList, scanner.Err()
+}
+
+func runLogin(pngFile string) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := bilibili.LoginByQrcode(ctx, "", func(qr bilibili.LoginQrcode) {
+		ascii, err := bilibili.RenderQrcodeAscii(qr.Url)
+		if err == nil {
+			fmt.Print(ascii)
+		}
+		if pngFile != "" {
+			if err = bilibili.WriteQrcodePng(qr.Url, pngFile); err == nil {
+				fmt.Println("二维码已保存到: " + pngFile)
+			}
+		}
+		fmt.Println("请使用哔哩哔哩手机客户端扫描二维码")
+	}, func(state int) {
+		switch state {
+		case bilibili.LoginStateWaitConfirm:
+			fmt.Println("已扫码, 请在手机上确认登录")
+		case bilibili.LoginStateSuccess:
+			fmt.Println("登录成功, cookie已保存到: " + bilibili.DefaultSessionFile())
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func runWhoami(req bilibili.BeginDownload_Req) int {
+	info, err := bilibili.CheckLogin(context.Background(), req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if info.IsLogin == false {
+		fmt.Println("未登录")
+		return 1
+	}
+	vip := "非大会员"
+	if info.VipStatus == 1 {
+		vip = info.VipLabel
+		if vip == "" {
+			vip = "大会员"
+		}
+	}
+	fmt.Printf("%s (mid %d) Lv%d %s\n", info.Name, info.Mid, info.Level, vip)
+	return 0
+}
+
+func runInfo(urlList []string, req bilibili.BeginDownload_Req, jsonOutput bool) int {
+	if len(urlList) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	exitCode := 0
+	resultList := []bilibili.ProbeResult{}
+	for _, urlStr := range urlList {
+		req.Url = urlStr
+		result, err := bilibili.ProbeWithReq(context.Background(), req)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, urlStr+": "+err.Error())
+			exitCode = 1
+			continue
+		}
+		if jsonOutput {
+			resultList = append(resultList, result)
+			continue
+		}
+		fmt.Println(result.Name)
+		if result.Live != nil {
+			fmt.Printf("  直播中 房间号: %d\n  flv: %s\n", result.Live.RoomId, result.Live.FlvUrl)
+		}
+		for _, page := range result.PageList {
+			fmt.Printf("  P%d %s %s\n", page.Index, page.Title, formatDuration(page.Duration))
+			for _, format := range page.FormatList {
+				fmt.Println("    " + formatFormat(format))
+			}
+		}
+	}
+	if jsonOutput {
+		data, _ := json.MarshalIndent(resultList, "", "  ")
+		fmt.Println(string(data))
+	}
+	return exitCode
+}
+
+func formatFormat(format bilibili.FormatInfo) string {
+	line := fmt.Sprintf("%-6d %s", format.Quality, format.Description)
+	if format.StreamType != "" {
+		line = fmt.Sprintf("%-6s %s", format.StreamType, line)
+	}
+	if format.Codec != "" {
+		line += " " + format.Codec
+	}
+	if format.Width > 0 {
+		line += fmt.Sprintf(" %dx%d", format.Width, format.Height)
+	}
+	if format.Available == false {
+		return line + " (需要登录或者大会员)"
+	}
+	return line + " ~" + formatSize(format.Size)
+}
+
+func formatDuration(sec float64) string {
+	d := time.Duration(sec) * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+func runDownload(urlList []string, req bilibili.BeginDownload_Req) int {
+	if len(urlList) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	p := &progressPrinter{}
+	doneCh := make(chan struct{}, 1)
+	var hasError bool
+	var locker sync.Mutex
+
+	bilibili.InitPrintFnS(bilibili.PrintFnS{
+		FnError: func(errMsg string) {
+			locker.Lock()
+			hasError = true
+			locker.Unlock()
+			p.println(os.Stderr, "错误: "+errMsg)
+		},
+		FnMessage: func(msg string) {
+			p.setMessage(msg)
+		},
+		FnUpdateProgress: func(d float64) {
+			p.setProgress(d)
+		},
+		FnUpdateRunning: func(running bool) {
+			if running == false {
+				doneCh <- struct{}{}
+			}
+		},
+		FnDownloadFinish: func(outMp4File string) {
+			p.println(os.Stdout, "下载成功: "+outMp4File)
+		},
+	})
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+
+	for _, urlStr := range urlList {
+		p.reset()
+		req.Url = urlStr
+		bilibili.BeginDownloadAsync(req)
+		select {
+		case <-doneCh:
+		case <-sigCh:
+			bilibili.StopDownload()
+			<-doneCh
+			p.println(os.Stderr, "下载已取消")
+			return 130
+		}
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if hasError {
+		return 1
+	}
+	return 0
+}
+
+// runBatchParallel 多个任务同时下载, 不显示进度条, 每条消息前面带上任务id
+func runBatchParallel(urlList []string, req bilibili.BeginDownload_Req, jobs int) int {
+	var locker sync.Mutex
+	var hasError bool
+	printLine := func(w *os.File, taskId int64, msg string) {
+		locker.Lock()
+		defer locker.Unlock()
+		fmt.Fprintf(w, "[%d] %s\n", taskId, msg)
+	}
+	m := bilibili.NewManager(jobs, bilibili.EventSinkFunc(func(ev bilibili.Event) {
+		switch ev.Type {
+		case bilibili.EventMessage:
+			if ev.Message != "" {
+				printLine(os.Stdout, ev.TaskId, ev.Message)
+			}
+		case bilibili.EventError:
+			locker.Lock()
+			hasError = true
+			locker.Unlock()
+			printLine(os.Stderr, ev.TaskId, "错误: "+ev.Message)
+		case bilibili.EventFinished:
+			printLine(os.Stdout, ev.TaskId, "下载成功: "+ev.OutName)
+		}
+	}))
+	for _, urlStr := range urlList {
+		req.Url = urlStr
+		taskId := m.AddTask(req)
+		printLine(os.Stdout, taskId, urlStr)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	doneCh := make(chan struct{})
+	go func() {
+		m.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+	case <-sigCh:
+		m.StopAll()
+		<-doneCh
+		fmt.Fprintln(os.Stderr, "下载已取消")
+		return 130
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if hasError {
+		return 1
+	}
+	return 0
+}
+
+var gLiveRoomRegexp = regexp.MustCompile(`^(?:https?://)?live\.bilibili\.com/(?:h5/|blanc/)?(\d+)`)
+
+// parseRoomId 支持房间号和直播间网址
+func parseRoomId(value string) (int64, error) {
+	if params := gLiveRoomRegexp.FindStringSubmatch(value); params != nil {
+		value = params[1]
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// runWatch Ctrl+C 时停止监控和所有录制, 正常退出
+func runWatch(args []string, req bilibili.BeginDownload_Req, interval time.Duration, stateFile string) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case <-sigCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return watchRooms(ctx, args, req, interval, stateFile)
+}
+
+// watchRooms 录制任务同时运行, 不排队. ctx 取消时停止监控和所有录制
+func watchRooms(ctx context.Context, args []string, req bilibili.BeginDownload_Req, interval time.Duration, stateFile string) int {
+	var roomIdList []int64
+	for _, one := range args {
+		roomId, err := parseRoomId(one)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "无效的房间号: "+one)
+			return 2
+		}
+		roomIdList = append(roomIdList, roomId)
+	}
+	var locker sync.Mutex
+	printLine := func(w *os.File, prefix string, msg string) {
+		locker.Lock()
+		defer locker.Unlock()
+		fmt.Fprintf(w, "%s %s %s\n", time.Now().Format("2006-01-02 15:04:05"), prefix, msg)
+	}
+	sink := bilibili.EventSinkFunc(func(ev bilibili.Event) {
+		prefix := fmt.Sprintf("[%d]", ev.TaskId)
+		if ev.RoomId != 0 {
+			prefix = fmt.Sprintf("[直播间%d]", ev.RoomId)
+		}
+		switch ev.Type {
+		case bilibili.EventMessage, bilibili.EventLiveOnline, bilibili.EventLiveOffline:
+			if ev.Message != "" {
+				printLine(os.Stdout, prefix, ev.Message)
+			}
+		case bilibili.EventError:
+			printLine(os.Stderr, prefix, "错误: "+ev.Message)
+		case bilibili.EventFinished:
+			printLine(os.Stdout, prefix, "录制结束: "+ev.OutName)
+		}
+	})
+	m := bilibili.NewManager(0, sink)
+	watcher, err := bilibili.NewLiveWatcher(m, bilibili.LiveWatchOption{
+		RoomIdList: roomIdList,
+		Interval:   interval,
+		StateFile:  stateFile,
+		Req:        req,
+	}, sink)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	watcher.Run(ctx)
+	m.StopAll()
+	m.Wait()
+	fmt.Fprintln(os.Stderr, "监控已停止")
+	return 0
+}
+
+type progressPrinter struct {
+	locker     sync.Mutex
+	progress   float64
+	message    string
+	lastDraw   time.Time
+	hasBarLine bool
+}
+
+func (this *progressPrinter) reset() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.progress = 0
+	this.message = ""
+}
+
+func (this *progressPrinter) setProgress(d float64) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.progress = d
+	if time.Since(this.lastDraw) < 100*time.Millisecond && d < 1 {
+		return
+	}
+	this.drawLocked()
+}
+
+func (this *progressPrinter) setMessage(msg string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if strings.HasPrefix(msg, "下载速度") { // 速度显示在进度条后面
+		this.message = msg
+		this.drawLocked()
+		return
+	}
+	if msg == "" {
+		return
+	}
+	this.clearLineLocked()
+	fmt.Fprintln(os.Stdout, msg)
+}
+
+func (this *progressPrinter) println(w *os.File, msg string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.clearLineLocked()
+	fmt.Fprintln(w, msg)
+}
+
+func (this *progressPrinter) clearLineLocked() {
+	if this.hasBarLine {
+		fmt.Fprint(os.Stdout, "\n")
+		this.hasBarLine = false
+	}
+}
+
+func (this *progressPrinter) drawLocked() {
+	const width = 40
+	done := int(this.progress * width)
+	if done > width {
+		done = width
+	} else if done < 0 {
+		done = 0
+	}
+	bar := strings.Repeat("#", done) + strings.Repeat("-", width-done)
+	fmt.Fprintf(os.Stdout, "\r[%s] %5.1f%% %s", bar, this.progress*100, this.message)
+	this.hasBarLine = true
+	this.lastDraw = time.Now()
+}
+
+func formatSize(size int64) string {
+	v := float64(size)
+	if v < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	v = v / 1024
+	if v < 1024 {
+		return fmt.Sprintf("%.0f KB", v)
+	}
+	return fmt.Sprintf("%.2f MB", v/1024)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"github.com/orestonce/bilibili"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRoomId(t *testing.T) {
+	for _, cas := range []struct {
+		value  string
+		roomId int64
+		ok     bool
+	}{
+		{"21452505", 21452505, true},
+		{"https://live.bilibili.com/21452505?spm_id_from=333", 21452505, true},
+		{"live.bilibili.com/h5/123", 123, true},
+		{"http://live.bilibili.com/blanc/456", 456, true},
+		{"https://www.bilibili.com/video/BV1xx411c7mD", 0, false},
+		{"abc", 0, false},
+	} {
+		roomId, err := parseRoomId(cas.value)
+		if (err == nil) != cas.ok || roomId != cas.roomId {
+			t.Fatal(cas.value, roomId, err)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	v, err := parseDate("", time.Hour)
+	if err != nil || v.IsZero() == false {
+		t.Fatal(v, err)
+	}
+	v, err = parseDate("2023-05-06", 24*time.Hour)
+	if err != nil || v.Equal(time.Date(2023, 5, 7, 0, 0, 0, 0, time.Local)) == false {
+		t.Fatal(v, err)
+	}
+	_, err = parseDate("2023/05/06", 0)
+	if err == nil {
+		t.Fatal()
+	}
+}
+
+func TestReadUrlFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "url.txt")
+	err := os.WriteFile(fileName, []byte("# 注释\nBV1xx411c7mD\n\n  https://b23.tv/abc  \r\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlList, err := readUrlFile(fileName)
+	if err != nil || reflect.DeepEqual(urlList, []string{"BV1xx411c7mD", "https://b23.tv/abc"}) == false {
+		t.Fatal(urlList, err)
+	}
+	_, err = readUrlFile(fileName + ".not_exist")
+	if os.IsNotExist(err) == false {
+		t.Fatal(err)
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	dir := t.TempDir()
+	for _, cas := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"help"}, 0},
+		{[]string{"info", "-not_exist_flag"}, 2},
+		{[]string{"info"}, 2},
+		{[]string{"-after", "2023/01/01"}, 2},
+		{[]string{"batch"}, 2},
+		{[]string{"batch", filepath.Join(dir, "not_exist.txt")}, 1},
+		{[]string{"watch", "abc"}, 2},
+		// 没有房间号, 状态文件也不存在
+		{[]string{"watch", "-state", filepath.Join(dir, "state.json")}, 1},
+	} {
+		code := run(cas.args)
+		if code != cas.code {
+			t.Fatal(cas.args, code)
+		}
+	}
+}
+
+func TestWatchRoomsStop(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	err := os.WriteFile(stateFile, []byte("[{"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 状态文件损坏时不能启动
+	code := watchRooms(context.Background(), []string{"123"}, bilibili.BeginDownload_Req{}, time.Minute, stateFile)
+	if code != 1 {
+		t.Fatal(code)
+	}
+	os.Remove(stateFile)
+
+	// 停止监控是正常退出
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	code = watchRooms(ctx, []string{"https://live.bilibili.com/123"}, bilibili.BeginDownload_Req{}, time.Minute, stateFile)
+	if code != 0 {
+		t.Fatal(code)
+	}
+}
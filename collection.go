@@ -0,0 +1,288 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const _favListUrlTemp = "%s/x/v3/fav/resource/list?media_id=%d&pn=%d&ps=20&platform=web"
+const _watchLaterUrlTemp = "%s/x/v2/history/toview"
+const _seasonArchivesUrlTemp = "%s/x/polymer/web-space/seasons_archives_list?mid=%d&season_id=%d&page_num=%d&page_size=30"
+const _seriesInfoUrlTemp = "%s/x/series/series?series_id=%d"
+const _seriesArchivesUrlTemp = "%s/x/series/archives?mid=%d&series_id=%d&pn=%d&ps=30&sort=asc"
+
+var (
+	gFavRegexp        = regexp.MustCompile(`(?:favlist\?(?:.*&)?fid=|medialist/detail/ml|^ml)(\d+)`)
+	gWatchLaterRegexp = regexp.MustCompile(`bilibili\.com/(?:list/)?watchlater`)
+	gSeasonRegexp     = regexp.MustCompile(`space\.bilibili\.com/(\d+)/(?:channel/collectiondetail\?sid=(\d+)|lists/(\d+)\?type=season)`)
+	gSeriesRegexp     = regexp.MustCompile(`space\.bilibili\.com/(\d+)/(?:channel/seriesdetail\?sid=(\d+)|lists/(\d+)\?type=series)`)
+)
+
+// collectionExtractor 收藏夹, 稍后再看, 合集和系列. 解析为按列表顺序排列的视频, 文件名前面加上序号
+type collectionExtractor struct{}
+
+func (collectionExtractor) Match(url string) bool {
+	return gFavRegexp.MatchString(url) || isWatchLaterList(url) || gSeasonRegexp.MatchString(url) || gSeriesRegexp.MatchString(url)
+}
+
+// isWatchLaterList 稍后再看里的单个视频 (/list/watchlater?bvid=BV...) 交给 bilibiliExtractor 处理
+func isWatchLaterList(url string) bool {
+	return gWatchLaterRegexp.MatchString(url) && gBvRegexp.MatchString(url) == false
+}
+
+func (collectionExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	this := getDownloaderFromCtx(ctx)
+	if params := gFavRegexp.FindStringSubmatch(url); params != nil {
+		fid, _ := strconv.ParseInt(params[1], 10, 64)
+		return this.getVideoInfoList_ByFavId(fid)
+	} else if isWatchLaterList(url) {
+		return this.getVideoInfoList_WatchLater()
+	} else if params = gSeasonRegexp.FindStringSubmatch(url); params != nil {
+		mid, _ := strconv.ParseInt(params[1], 10, 64)
+		sid, _ := strconv.ParseInt(params[2]+params[3], 10, 64)
+		return this.getVideoInfoList_BySeasonId(mid, sid)
+	} else if params = gSeriesRegexp.FindStringSubmatch(url); params != nil {
+		mid, _ := strconv.ParseInt(params[1], 10, 64)
+		sid, _ := strconv.ParseInt(params[2]+params[3], 10, 64)
+		return this.getVideoInfoList_BySeriesId(mid, sid)
+	}
+	return VideoInfo{}, ErrUnsupportedURL
+}
+
+type archiveItem struct {
+	Aid     int64  `json:"aid"`
+	Bvid    string `json:"bvid"`
+	Title   string `json:"title"`
+	Pubdate int64  `json:"pubdate"`
+}
+
+// addArchiveEntry 按过滤条件把列表中的第index个视频加入 info.EntryList, 返回是否已经达到数量上限
+func (this *BilibiliDownloader) addArchiveEntry(info *VideoInfo, index int, item archiveItem) (isFull bool) {
+	entry := VideoEntry{
+		Id:      item.Bvid,
+		Url:     "https://www.bilibili.com/video/av" + strconv.FormatInt(item.Aid, 10),
+		Title:   item.Title,
+		Index:   index,
+		PubDate: time.Unix(item.Pubdate, 0),
+	}
+	if entry.Id == "" {
+		entry.Id = "av" + strconv.FormatInt(item.Aid, 10)
+	}
+	if this.req.Filter.match(entry) {
+		info.EntryList = append(info.EntryList, entry)
+	}
+	return this.req.Filter.isFull(len(info.EntryList))
+}
+
+func (this *BilibiliDownloader) getVideoInfoList_ByFavId(fid int64) (info VideoInfo, err error) {
+	var index int   // 视频的序号, 不包括跳过的
+	var fetched int // 已经获取的数量, 用于显示进度
+	for pn := 1; ; pn++ {
+		contents, err := this.defaultFetcher(fmt.Sprintf(_favListUrlTemp, gBilibiliApiHost, fid, pn))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByFavId", err)
+		}
+		var tmp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    struct {
+				Info struct {
+					Title      string `json:"title"`
+					MediaCount int    `json:"media_count"`
+				} `json:"info"`
+				Medias []struct {
+					Id      int64  `json:"id"`
+					Type    int    `json:"type"`
+					Bvid    string `json:"bvid"`
+					Title   string `json:"title"`
+					Pubtime int64  `json:"pubtime"`
+				} `json:"medias"`
+				HasMore bool `json:"has_more"`
+			} `json:"data"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByFavId_2", err)
+		}
+		if tmp.Code != 0 {
+			return info, apiCodeError("获取收藏夹失败", tmp.Code, tmp.Message)
+		}
+		info.Title = tmp.Data.Info.Title
+		var isFull bool
+		for _, one := range tmp.Data.Medias {
+			fetched++
+			if one.Type != 2 { // 只下载视频, 跳过音频等
+				continue
+			}
+			index++
+			isFull = this.addArchiveEntry(&info, index, archiveItem{Aid: one.Id, Bvid: one.Bvid, Title: one.Title, Pubdate: one.Pubtime})
+			if isFull {
+				break
+			}
+		}
+		this.fnMessage(fmt.Sprintf("获取收藏夹: %d/%d", fetched, tmp.Data.Info.MediaCount))
+		if isFull || tmp.Data.HasMore == false || len(tmp.Data.Medias) == 0 {
+			break
+		}
+		this.sleepDur(time.Millisecond * 500)
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取收藏夹失败", ErrVideoNotFound, nil)
+	}
+	info.Name = fmt.Sprintf("fav%d_%s", fid, TitleEdit(info.Title))
+	return info, nil
+}
+
+// getVideoInfoList_WatchLater 稍后再看需要登录
+func (this *BilibiliDownloader) getVideoInfoList_WatchLater() (info VideoInfo, err error) {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_watchLaterUrlTemp, gBilibiliApiHost))
+	if err != nil {
+		return info, wrapError("getVideoInfoList_WatchLater", err)
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			List []archiveItem `json:"list"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err != nil {
+		return info, wrapError("getVideoInfoList_WatchLater_2", err)
+	}
+	if tmp.Code != 0 {
+		return info, apiCodeError("获取稍后再看失败", tmp.Code, tmp.Message)
+	}
+	for idx, one := range tmp.Data.List {
+		if this.addArchiveEntry(&info, idx+1, one) {
+			break
+		}
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取稍后再看失败", ErrVideoNotFound, nil)
+	}
+	info.Title = "稍后再看"
+	info.Name = "watchlater"
+	return info, nil
+}
+
+// getVideoInfoList_BySeasonId 合集
+func (this *BilibiliDownloader) getVideoInfoList_BySeasonId(mid int64, sid int64) (info VideoInfo, err error) {
+	var index int
+	for pn := 1; ; pn++ {
+		contents, err := this.defaultFetcher(fmt.Sprintf(_seasonArchivesUrlTemp, gBilibiliApiHost, mid, sid, pn))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_BySeasonId", err)
+		}
+		var tmp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    struct {
+				Archives []archiveItem `json:"archives"`
+				Meta     struct {
+					Name string `json:"name"`
+				} `json:"meta"`
+				Page struct {
+					PageSize int `json:"page_size"`
+					Total    int `json:"total"`
+				} `json:"page"`
+			} `json:"data"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_BySeasonId_2", err)
+		}
+		if tmp.Code != 0 {
+			return info, apiCodeError("获取合集失败", tmp.Code, tmp.Message)
+		}
+		info.Title = tmp.Data.Meta.Name
+		var isFull bool
+		for _, one := range tmp.Data.Archives {
+			index++
+			if isFull = this.addArchiveEntry(&info, index, one); isFull {
+				break
+			}
+		}
+		this.fnMessage(fmt.Sprintf("获取合集: %d/%d", index, tmp.Data.Page.Total))
+		if isFull || len(tmp.Data.Archives) == 0 || index >= tmp.Data.Page.Total {
+			break
+		}
+		this.sleepDur(time.Millisecond * 500)
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取合集失败", ErrVideoNotFound, nil)
+	}
+	info.Name = fmt.Sprintf("season%d_%s", sid, TitleEdit(info.Title))
+	return info, nil
+}
+
+// getVideoInfoList_BySeriesId 系列, 列表接口里没有名字, 需要单独获取
+func (this *BilibiliDownloader) getVideoInfoList_BySeriesId(mid int64, sid int64) (info VideoInfo, err error) {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_seriesInfoUrlTemp, gBilibiliApiHost, sid))
+	if err != nil {
+		return info, wrapError("getVideoInfoList_BySeriesId", err)
+	}
+	var meta struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Meta struct {
+				Name string `json:"name"`
+			} `json:"meta"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &meta)
+	if err != nil {
+		return info, wrapError("getVideoInfoList_BySeriesId_2", err)
+	}
+	if meta.Code != 0 {
+		return info, apiCodeError("获取系列失败", meta.Code, meta.Message)
+	}
+	info.Title = meta.Data.Meta.Name
+
+	var index int
+	for pn := 1; ; pn++ {
+		contents, err = this.defaultFetcher(fmt.Sprintf(_seriesArchivesUrlTemp, gBilibiliApiHost, mid, sid, pn))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_BySeriesId_3", err)
+		}
+		var tmp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    struct {
+				Archives []archiveItem `json:"archives"`
+				Page     struct {
+					Total int `json:"total"`
+				} `json:"page"`
+			} `json:"data"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_BySeriesId_4", err)
+		}
+		if tmp.Code != 0 {
+			return info, apiCodeError("获取系列失败", tmp.Code, tmp.Message)
+		}
+		var isFull bool
+		for _, one := range tmp.Data.Archives {
+			index++
+			if isFull = this.addArchiveEntry(&info, index, one); isFull {
+				break
+			}
+		}
+		this.fnMessage(fmt.Sprintf("获取系列: %d/%d", index, tmp.Data.Page.Total))
+		if isFull || len(tmp.Data.Archives) == 0 || index >= tmp.Data.Page.Total {
+			break
+		}
+		this.sleepDur(time.Millisecond * 500)
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取系列失败", ErrVideoNotFound, nil)
+	}
+	info.Name = fmt.Sprintf("series%d_%s", sid, TitleEdit(info.Title))
+	return info, nil
+}
@@ -0,0 +1,144 @@
+package bilibili
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getTestEntryList(info VideoInfo) (list []string) {
+	for _, one := range info.EntryList {
+		list = append(list, fmt.Sprintf("%d:%s", one.Index, one.Id))
+	}
+	return list
+}
+
+func newTestCollectionDownloader(url string, maxCount int) *BilibiliDownloader {
+	req := BeginDownload_Req{Url: url}
+	req.Filter.MaxCount = maxCount
+	d := newBilibiliDownloader(req)
+	d.sink = gDiscardEventSink
+	return d
+}
+
+// TestCollectionFav 跳过的音频不占用序号
+func TestCollectionFav(t *testing.T) {
+	media := func(id int64, typ int) string {
+		return fmt.Sprintf(`{"id":%d,"type":%d,"bvid":"BV%d","title":"标题%d","pubtime":1700000000}`, id, typ, id, id)
+	}
+	var pnList []string
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/x/v3/fav/resource/list" || r.URL.Query().Get("media_id") != "77" {
+			http.NotFound(w, r)
+			return
+		}
+		pn := r.URL.Query().Get("pn")
+		pnList = append(pnList, pn)
+		switch pn {
+		case "1":
+			fmt.Fprintf(w, `{"code":0,"data":{"info":{"title":"我的收藏","media_count":5},"has_more":true,"medias":[%s]}}`, strings.Join([]string{
+				media(1, 2), media(2, 12), media(3, 2),
+			}, ","))
+		case "2":
+			fmt.Fprintf(w, `{"code":0,"data":{"info":{"title":"我的收藏","media_count":5},"has_more":false,"medias":[%s]}}`, strings.Join([]string{
+				media(4, 12), media(5, 2),
+			}, ","))
+		default:
+			t.Error("unexpected pn", pn)
+			w.Write([]byte(`{"code":0,"data":{"medias":[]}}`))
+		}
+	})
+	url := "https://space.bilibili.com/2/favlist?fid=77&ftype=create"
+	info, err := newTestCollectionDownloader(url, 0).getVideoInfo(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := getTestEntryList(info); reflect.DeepEqual(list, []string{"1:BV1", "2:BV3", "3:BV5"}) == false {
+		t.Fatal(list)
+	}
+	if info.Name != "fav77_我的收藏" || info.EntryList[1].Url != "https://www.bilibili.com/video/av3" || reflect.DeepEqual(pnList, []string{"1", "2"}) == false {
+		t.Fatal(info.Name, info.EntryList[1], pnList)
+	}
+
+	// 达到数量上限后不再获取下一页
+	pnList = nil
+	info, err = newTestCollectionDownloader(url, 2).getVideoInfo(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := getTestEntryList(info); reflect.DeepEqual(list, []string{"1:BV1", "2:BV3"}) == false || reflect.DeepEqual(pnList, []string{"1"}) == false {
+		t.Fatal(list, pnList)
+	}
+}
+
+func TestCollectionWatchLater(t *testing.T) {
+	var itemList string
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/x/v2/history/toview" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"code":0,"data":{"list":[%s]}}`, itemList)
+	})
+	itemList = `{"aid":1,"bvid":"BV1","title":"a","pubdate":1700000000},{"aid":2,"title":"b","pubdate":1700000000}`
+	url := "https://www.bilibili.com/watchlater/#/list"
+	info, err := newTestCollectionDownloader(url, 0).getVideoInfo(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list := getTestEntryList(info); reflect.DeepEqual(list, []string{"1:BV1", "2:av2"}) == false || info.Name != "watchlater" {
+		t.Fatal(list, info.Name)
+	}
+
+	itemList = ""
+	_, err = newTestCollectionDownloader(url, 0).getVideoInfo(url)
+	checkErrKind(t, "watchlater", err, ErrVideoNotFound)
+}
+
+// TestCollectionSeason 合集和系列按 total 翻页
+func TestCollectionSeason(t *testing.T) {
+	archive := func(aid int64) string {
+		return fmt.Sprintf(`{"aid":%d,"bvid":"BV%d","title":"标题%d","pubdate":1700000000}`, aid, aid, aid)
+	}
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/x/polymer/web-space/seasons_archives_list":
+			if q.Get("mid") != "2" || q.Get("season_id") != "123" {
+				t.Error(r.URL)
+			}
+			if q.Get("page_num") == "1" {
+				fmt.Fprintf(w, `{"code":0,"data":{"archives":[%s,%s],"meta":{"name":"合集"},"page":{"page_size":2,"total":3}}}`, archive(1), archive(2))
+			} else {
+				fmt.Fprintf(w, `{"code":0,"data":{"archives":[%s],"meta":{"name":"合集"},"page":{"page_size":2,"total":3}}}`, archive(3))
+			}
+		case "/x/series/series":
+			w.Write([]byte(`{"code":0,"data":{"meta":{"name":"系列"}}}`))
+		case "/x/series/archives":
+			if q.Get("mid") != "2" || q.Get("series_id") != "456" || q.Get("pn") != "1" {
+				t.Error(r.URL)
+			}
+			fmt.Fprintf(w, `{"code":0,"data":{"archives":[%s,%s],"page":{"total":2}}}`, archive(4), archive(5))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	for _, cas := range []struct {
+		url  string
+		name string
+		want []string
+	}{
+		{"https://space.bilibili.com/2/lists/123?type=season", "season123_合集", []string{"1:BV1", "2:BV2", "3:BV3"}},
+		{"https://space.bilibili.com/2/channel/seriesdetail?sid=456", "series456_系列", []string{"1:BV4", "2:BV5"}},
+	} {
+		info, err := newTestCollectionDownloader(cas.url, 0).getVideoInfo(cas.url)
+		if err != nil {
+			t.Fatal(cas.url, err)
+		}
+		if list := getTestEntryList(info); reflect.DeepEqual(list, cas.want) == false || info.Name != cas.name {
+			t.Fatal(cas.url, list, info.Name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package bilibili
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 只有cookie字符串时, 设置到这个域名下
+const _defaultCookieDomain = ".bilibili.com"
+
+// LoadCookieJar cookie 为浏览器里复制的 Cookie 请求头, 例如 "SESSDATA=xxx; bili_jct=xxx",
+// cookieFile 为 Netscape 格式的 cookies.txt, 两个都为空时返回一个空的 CookieJar
+func LoadCookieJar(cookie string, cookieFile string) (http.CookieJar, error) {
+	jar, _ := cookiejar.New(nil)
+	if cookieFile != "" {
+		file, err := os.Open(cookieFile)
+		if err != nil {
+			return nil, wrapError("LoadCookieJar", err)
+		}
+		defer file.Close()
+
+		err = readNetscapeCookies(jar, file)
+		if err != nil {
+			return nil, wrapError("LoadCookieJar", err)
+		}
+	}
+	if cookie != "" {
+		setCookieHeader(jar, _defaultCookieDomain, cookie)
+	}
+	return jar, nil
+}
+
+func setCookieHeader(jar http.CookieJar, domain string, cookie string) {
+	var list []*http.Cookie
+	for _, one := range strings.Split(cookie, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(one), "=")
+		if ok == false || name == "" {
+			continue
+		}
+		list = append(list, &http.Cookie{Name: name, Value: value, Domain: domain, Path: "/"})
+	}
+	jar.SetCookies(&url.URL{Scheme: "https", Host: strings.TrimPrefix(domain, "."), Path: "/"}, list)
+}
+
+// readNetscapeCookies 每行: domain includeSubdomains path secure expiry name value, 以tab分隔
+func readNetscapeCookies(jar http.CookieJar, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return fmt.Errorf("cookies.txt 第%d行格式错误", lineNo)
+		}
+		domain, path, name, value := fields[0], fields[2], fields[5], fields[6]
+		c := &http.Cookie{Name: name, Value: value, Path: path, Secure: strings.EqualFold(fields[3], "TRUE")}
+		if strings.EqualFold(fields[1], "TRUE") {
+			c.Domain = domain
+		}
+		if expiry, _ := strconv.ParseInt(fields[4], 10, 64); expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+			if c.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+		jar.SetCookies(&url.URL{Scheme: "https", Host: strings.TrimPrefix(domain, "."), Path: path}, []*http.Cookie{c})
+	}
+	return scanner.Err()
+}
+
+// LoginInfo 是 CheckLogin 的结果
+type LoginInfo struct {
+	IsLogin   bool   `json:"is_login"`
+	Mid       int64  `json:"mid"`
+	Name      string `json:"name"`
+	Level     int    `json:"level"`
+	VipType   int    `json:"vip_type"`   // 0 无, 1 月度大会员, 2 年度及以上大会员
+	VipStatus int    `json:"vip_status"` // 1 有效
+	VipLabel  string `json:"vip_label"`
+}
+
+// CheckLogin 使用 req 里的cookie检查是否已经登录, 以及大会员等级
+func CheckLogin(ctx context.Context, req BeginDownload_Req) (info LoginInfo, err error) {
+	tmp := newBilibiliDownloaderWithCtx(ctx, req)
+	tmp.sink = gDiscardEventSink
+	defer tmp.closeFn()
+
+	if tmp.jarErr != nil {
+		return info, tmp.jarErr
+	}
+	contents, err := tmp.defaultFetcher(fmt.Sprintf(_navUrlTemp, gBilibiliApiHost))
+	if err != nil {
+		return info, wrapError("CheckLogin", err)
+	}
+	var nav struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			IsLogin   bool   `json:"isLogin"`
+			Mid       int64  `json:"mid"`
+			Uname     string `json:"uname"`
+			VipType   int    `json:"vipType"`
+			VipStatus int    `json:"vipStatus"`
+			VipLabel  struct {
+				Text string `json:"text"`
+			} `json:"vip_label"`
+			LevelInfo struct {
+				CurrentLevel int `json:"current_level"`
+			} `json:"level_info"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &nav)
+	if err != nil {
+		return info, wrapError("CheckLogin_2", err)
+	}
+	if nav.Code == -101 { // 未登录
+		return info, nil
+	}
+	if nav.Code != 0 {
+		return info, apiCodeError("CheckLogin", nav.Code, nav.Message)
+	}
+	if nav.Data.IsLogin == false {
+		return info, nil
+	}
+	return LoginInfo{
+		IsLogin:   true,
+		Mid:       nav.Data.Mid,
+		Name:      nav.Data.Uname,
+		Level:     nav.Data.LevelInfo.CurrentLevel,
+		VipType:   nav.Data.VipType,
+		VipStatus: nav.Data.VipStatus,
+		VipLabel:  nav.Data.VipLabel.Text,
+	}, nil
+}
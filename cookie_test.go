@@ -0,0 +1,153 @@
+package bilibili
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getTestCookies(jar http.CookieJar, urlStr string) string {
+	u, _ := url.Parse(urlStr)
+	var list []string
+	for _, one := range jar.Cookies(u) {
+		list = append(list, one.Name+"="+one.Value)
+	}
+	sort.Strings(list)
+	return strings.Join(list, "; ")
+}
+
+func TestReadNetscapeCookies(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	data := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		fmt.Sprintf(".bilibili.com\tTRUE\t/\tFALSE\t%d\tSESSDATA\tsess", future),
+		fmt.Sprintf("#HttpOnly_.bilibili.com\tTRUE\t/\tTRUE\t%d\tbili_jct\tjct", future),
+		".bilibili.com\tTRUE\t/\tFALSE\t0\tsid\tsession", // 0: 会话cookie, 不过期
+		".bilibili.com\tTRUE\t/\tFALSE\t1000\told\texpired",
+		"live.bilibili.com\tFALSE\t/\tFALSE\t0\tLIVE_BUVID\tlive", // 只对这个域名有效
+		"www.bilibili.com\tFALSE\t/video\tFALSE\t0\tpath\tvideo",
+		"douyin.com\tTRUE\t/\tFALSE\t0\tttwid\ttt", // 没有前面的点也对子域名有效
+	}, "\n")
+	jar, _ := cookiejar.New(nil)
+	err := readNetscapeCookies(jar, strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://api.bilibili.com/x/web-interface/nav", "SESSDATA=sess; bili_jct=jct; sid=session"},
+		{"http://api.bilibili.com/", "SESSDATA=sess; sid=session"}, // bili_jct 是secure
+		{"https://live.bilibili.com/", "LIVE_BUVID=live; SESSDATA=sess; bili_jct=jct; sid=session"},
+		{"https://sub.live.bilibili.com/", "SESSDATA=sess; bili_jct=jct; sid=session"},
+		{"https://www.bilibili.com/video/BV1xx411c7mD", "SESSDATA=sess; bili_jct=jct; path=video; sid=session"},
+		{"https://www.douyin.com/", "ttwid=tt"},
+	}
+	for _, tt := range tests {
+		if got := getTestCookies(jar, tt.url); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.url, got, tt.want)
+		}
+	}
+
+	for _, one := range []struct {
+		data string
+		want string
+	}{
+		{"# comment\n.bilibili.com\tTRUE\t/\tFALSE\t0\tSESSDATA", "第2行"},
+		{".bilibili.com TRUE / FALSE 0 SESSDATA sess", "第1行"}, // 空格分隔
+	} {
+		jar, _ = cookiejar.New(nil)
+		err = readNetscapeCookies(jar, strings.NewReader(one.data))
+		if err == nil || strings.Contains(err.Error(), one.want) == false {
+			t.Errorf("%q: %v", one.data, err)
+		}
+	}
+}
+
+func TestLoadCookieJar(t *testing.T) {
+	dir := t.TempDir()
+	cookieFile := filepath.Join(dir, "cookies.txt")
+	err := os.WriteFile(cookieFile, []byte(".bilibili.com\tTRUE\t/\tFALSE\t0\tSESSDATA\tfile\n.bilibili.com\tTRUE\t/\tFALSE\t0\tbuvid3\tb3\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cookie 请求头覆盖文件里的同名cookie, 没有=的部分忽略
+	jar, err := LoadCookieJar(" SESSDATA=header; bili_jct=jct;; bad ;=x", cookieFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getTestCookies(jar, "https://api.bilibili.com/"); got != "SESSDATA=header; bili_jct=jct; buvid3=b3" {
+		t.Fatal(got)
+	}
+	if got := getTestCookies(jar, "https://www.douyin.com/"); got != "" {
+		t.Fatal(got)
+	}
+
+	_, err = LoadCookieJar("", filepath.Join(dir, "missing.txt"))
+	if errors.Is(err, os.ErrNotExist) == false {
+		t.Fatal(err)
+	}
+	// 读取失败的错误在开始解析时返回
+	d := newBilibiliDownloader(BeginDownload_Req{CookieFile: filepath.Join(dir, "missing.txt")})
+	_, err = d.getVideoInfo("BV1xx411c7mD")
+	if errors.Is(err, os.ErrNotExist) == false {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckLogin(t *testing.T) {
+	var navResp string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("SESSDATA"); err != nil || c.Value != "sess" {
+			w.Write([]byte(`{"code":-101,"message":"账号未登录","data":{"isLogin":false}}`))
+			return
+		}
+		w.Write([]byte(navResp))
+	}))
+	oldHost := gBilibiliApiHost
+	gBilibiliApiHost = srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		gBilibiliApiHost = oldHost
+	})
+	// 测试服务的域名是 127.0.0.1, 只能通过 cookies.txt 设置
+	host := strings.TrimPrefix(srv.URL, "http://")
+	host = host[:strings.Index(host, ":")]
+	cookieFile := filepath.Join(t.TempDir(), "cookies.txt")
+	err := os.WriteFile(cookieFile, []byte(host+"\tFALSE\t/\tFALSE\t0\tSESSDATA\tsess\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := CheckLogin(context.Background(), BeginDownload_Req{})
+	if err != nil || info.IsLogin {
+		t.Fatal(info, err)
+	}
+	navResp = `{"code":0,"data":{"isLogin":true,"mid":2,"uname":"碧诗","vipType":2,"vipStatus":1,"vip_label":{"text":"年度大会员"},"level_info":{"current_level":6}}}`
+	info, err = CheckLogin(context.Background(), BeginDownload_Req{CookieFile: cookieFile})
+	want := LoginInfo{IsLogin: true, Mid: 2, Name: "碧诗", Level: 6, VipType: 2, VipStatus: 1, VipLabel: "年度大会员"}
+	if err != nil || info != want {
+		t.Fatal(info, err)
+	}
+	navResp = `{"code":-412,"message":"请求被拦截"}`
+	_, err = CheckLogin(context.Background(), BeginDownload_Req{CookieFile: cookieFile})
+	if err == nil || strings.Contains(err.Error(), "请求被拦截") == false {
+		t.Fatal(err)
+	}
+	_, err = CheckLogin(context.Background(), BeginDownload_Req{CookieFile: cookieFile + ".missing"})
+	if errors.Is(err, os.ErrNotExist) == false {
+		t.Fatal(err)
+	}
+}
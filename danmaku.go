@@ -0,0 +1,290 @@
+package bilibili
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 弹幕xml, 返回的数据是deflate压缩的
+const _danmakuXmlUrlTemp = "%s/x/v1/dm/list.so?oid=%d"
+
+// DanmakuOption 弹幕下载和转换为ass字幕的参数, 尺寸按 1920x1080 计算, 零值使用默认值
+type DanmakuOption struct {
+	Enable         bool    // 保存原始弹幕xml, 并转换为ass字幕
+	FontName       string  // 默认 Microsoft YaHei
+	FontSize       int     // 普通大小弹幕的字号, 默认 50
+	Opacity        float64 // 不透明度 0~1, 默认 0.8
+	ScrollDuration float64 // 滚动弹幕在屏幕上停留的秒数, 默认 10
+	FixedDuration  float64 // 顶部/底部弹幕停留的秒数, 默认 5
+	DisplayArea    float64 // 滚动弹幕占屏幕高度的比例 0~1, 默认 1
+	MaxOnScreen    int     // 同屏最多显示的弹幕数量, 0 表示不限制, 超出和没有空闲行的弹幕会被丢弃
+}
+
+const (
+	_danmakuWidth  = 1920
+	_danmakuHeight = 1080
+)
+
+func (this DanmakuOption) withDefault() DanmakuOption {
+	if this.FontName == "" {
+		this.FontName = "Microsoft YaHei"
+	}
+	if this.FontSize <= 0 {
+		this.FontSize = 50
+	}
+	if this.Opacity <= 0 || this.Opacity > 1 {
+		this.Opacity = 0.8
+	}
+	if this.ScrollDuration <= 0 {
+		this.ScrollDuration = 10
+	}
+	if this.FixedDuration <= 0 {
+		this.FixedDuration = 5
+	}
+	if this.DisplayArea <= 0 || this.DisplayArea > 1 {
+		this.DisplayArea = 1
+	}
+	return this
+}
+
+// getDanmakuSidecarList 每个分P保存一个原始xml和一个ass, 两者使用同一个网址, 下载时只请求一次
+func (this *BilibiliDownloader) getDanmakuSidecarList(cid int64, group string) []SidecarFile {
+	if this.req.Danmaku.Enable == false {
+		return nil
+	}
+	danmakuUrl := fmt.Sprintf(_danmakuXmlUrlTemp, gBilibiliApiHost, cid)
+	header := http.Header{"User-Agent": {userAgent}}
+	return []SidecarFile{
+		{Group: group, Suffix: ".danmaku.xml", Kind: SidecarRaw, Url: danmakuUrl, Header: header},
+		{Group: group, Suffix: ".danmaku.ass", Kind: SidecarDanmakuAss, Url: danmakuUrl, Header: header},
+	}
+}
+
+const (
+	danmakuModeScroll  = 1
+	danmakuModeBottom  = 4
+	danmakuModeTop     = 5
+	danmakuModeReverse = 6
+)
+
+type danmakuItem struct {
+	time     float64
+	mode     int
+	fontSize int
+	color    int
+	text     string
+}
+
+// parseDanmakuXml <d p="时间,模式,字号,颜色,发送时间,弹幕池,用户hash,id">内容</d>, 高级弹幕和代码弹幕会被忽略
+func parseDanmakuXml(data []byte) (list []danmakuItem, err error) {
+	var tmp struct {
+		D []struct {
+			P    string `xml:"p,attr"`
+			Text string `xml:",chardata"`
+		} `xml:"d"`
+	}
+	err = xml.Unmarshal(data, &tmp)
+	if err != nil {
+		return nil, err
+	}
+	for _, one := range tmp.D {
+		fields := strings.Split(one.P, ",")
+		if len(fields) < 4 {
+			continue
+		}
+		var item danmakuItem
+		item.time, _ = strconv.ParseFloat(fields[0], 64)
+		item.mode, _ = strconv.Atoi(fields[1])
+		item.fontSize, _ = strconv.Atoi(fields[2])
+		item.color, _ = strconv.Atoi(fields[3])
+		item.text = one.Text
+		var ok bool
+		if item.mode, ok = normalizeDanmakuMode(item.mode); ok == false {
+			continue
+		}
+		if item.fontSize <= 0 {
+			item.fontSize = 25
+		}
+		list = append(list, item)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].time < list[j].time
+	})
+	return list, nil
+}
+
+// normalizeDanmakuMode 1~3 都是滚动弹幕, 高级弹幕(7)和代码弹幕(8)等无法转换为ass, 返回 ok=false
+func normalizeDanmakuMode(mode int) (int, bool) {
+	switch mode {
+	case 1, 2, 3:
+		return danmakuModeScroll, true
+	case danmakuModeBottom, danmakuModeTop, danmakuModeReverse:
+		return mode, true
+	}
+	return mode, false
+}
+
+// danmakuLane 一行弹幕, 记录最后一条弹幕的出现时间/宽度/速度
+type danmakuLane struct {
+	begin float64
+	width float64
+	speed float64
+	used  bool
+}
+
+type danmakuRender struct {
+	opt         DanmakuOption
+	laneHeight  int
+	scrollLanes []danmakuLane
+	topLanes    []float64 // 每行的结束时间
+	bottomLanes []float64
+	endTimeList []float64 // 正在显示的弹幕的结束时间, 用于限制同屏数量
+}
+
+func convertDanmakuToAss(data []byte, opt DanmakuOption) ([]byte, error) {
+	list, err := parseDanmakuXml(data)
+	if err != nil {
+		return nil, err
+	}
+	return renderDanmakuAss(list, opt), nil
+}
+
+// renderDanmakuAss list 需要按时间排序. 滚动弹幕从上往下找第一个不会追尾的行, 顶部/底部弹幕找第一个空闲行, 找不到时丢弃
+func renderDanmakuAss(list []danmakuItem, opt DanmakuOption) []byte {
+	opt = opt.withDefault()
+	r := &danmakuRender{opt: opt, laneHeight: opt.FontSize + 4}
+	laneCount := _danmakuHeight / r.laneHeight
+	r.scrollLanes = make([]danmakuLane, int(float64(laneCount)*opt.DisplayArea))
+	r.topLanes = make([]float64, laneCount)
+	r.bottomLanes = make([]float64, laneCount)
+
+	var buf bytes.Buffer
+	alpha := 255 - int(opt.Opacity*255+0.5)
+	fmt.Fprintf(&buf, `[Script Info]
+ScriptType: v4.00+
+PlayResX: %d
+PlayResY: %d
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Danmaku,%s,%d,&H%02XFFFFFF,&H%02XFFFFFF,&H%02X000000,&H%02X000000,1,0,0,0,100,100,0,0,1,1,0,7,0,0,0,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`, _danmakuWidth, _danmakuHeight, opt.FontName, opt.FontSize, alpha, alpha, alpha, alpha)
+	for _, item := range list {
+		if line := r.render(item); line != "" {
+			buf.WriteString(line)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (this *danmakuRender) render(item danmakuItem) string {
+	if this.isFull(item.time) {
+		return ""
+	}
+	fontSize := this.opt.FontSize * item.fontSize / 25
+	width := estimateTextWidth(item.text, fontSize)
+	var tags string
+	var end float64
+	switch item.mode {
+	case danmakuModeScroll, danmakuModeReverse:
+		lane := this.findScrollLane(item.time, width)
+		if lane < 0 {
+			return ""
+		}
+		end = item.time + this.opt.ScrollDuration
+		y := lane * this.laneHeight
+		fromX, toX := float64(_danmakuWidth), -width
+		if item.mode == danmakuModeReverse {
+			fromX, toX = -width, float64(_danmakuWidth)
+		}
+		tags = fmt.Sprintf(`\move(%d,%d,%d,%d)`, int(fromX), y, int(toX), y)
+	case danmakuModeTop:
+		lane := findFixedLane(this.topLanes, item.time, this.opt.FixedDuration)
+		if lane < 0 {
+			return ""
+		}
+		end = item.time + this.opt.FixedDuration
+		tags = fmt.Sprintf(`\an8\pos(%d,%d)`, _danmakuWidth/2, lane*this.laneHeight)
+	case danmakuModeBottom:
+		lane := findFixedLane(this.bottomLanes, item.time, this.opt.FixedDuration)
+		if lane < 0 {
+			return ""
+		}
+		end = item.time + this.opt.FixedDuration
+		tags = fmt.Sprintf(`\an2\pos(%d,%d)`, _danmakuWidth/2, _danmakuHeight-lane*this.laneHeight)
+	}
+	this.endTimeList = append(this.endTimeList, end)
+	if fontSize != this.opt.FontSize {
+		tags += `\fs` + strconv.Itoa(fontSize)
+	}
+	if color := item.color & 0xffffff; color != 0xffffff {
+		tags += fmt.Sprintf(`\c&H%02X%02X%02X&`, color&0xff, color>>8&0xff, color>>16)
+	}
+	return fmt.Sprintf("Dialogue: 0,%s,%s,Danmaku,,0,0,0,,{%s}%s\n", formatAssTime(item.time), formatAssTime(end), tags, escapeAssText(item.text))
+}
+
+func (this *danmakuRender) isFull(now float64) bool {
+	if this.opt.MaxOnScreen <= 0 {
+		return false
+	}
+	var list []float64
+	for _, end := range this.endTimeList {
+		if end > now {
+			list = append(list, end)
+		}
+	}
+	this.endTimeList = list
+	return len(list) >= this.opt.MaxOnScreen
+}
+
+// findScrollLane 新弹幕出现时前一条的尾部已经进入屏幕, 并且在前一条离开屏幕之前追不上它
+func (this *danmakuRender) findScrollLane(now float64, width float64) int {
+	duration := this.opt.ScrollDuration
+	speed := (_danmakuWidth + width) / duration
+	for idx := range this.scrollLanes {
+		lane := &this.scrollLanes[idx]
+		if lane.used {
+			if now < lane.begin+lane.width/lane.speed {
+				continue
+			}
+			if now+_danmakuWidth/speed < lane.begin+duration {
+				continue
+			}
+		}
+		*lane = danmakuLane{begin: now, width: width, speed: speed, used: true}
+		return idx
+	}
+	return -1
+}
+
+func findFixedLane(endList []float64, now float64, duration float64) int {
+	for idx, end := range endList {
+		if end <= now {
+			endList[idx] = now + duration
+			return idx
+		}
+	}
+	return -1
+}
+
+// estimateTextWidth 没有字体信息, 半角字符按半个字宽计算
+func estimateTextWidth(text string, fontSize int) float64 {
+	var width float64
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			width += float64(fontSize) / 2
+		} else {
+			width += float64(fontSize)
+		}
+	}
+	return width
+}
@@ -0,0 +1,110 @@
+package bilibili
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getTestDialogueList(data []byte) (list []string) {
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Dialogue: ") {
+			list = append(list, strings.TrimPrefix(line, "Dialogue: 0,"))
+		}
+	}
+	return list
+}
+
+func TestParseDanmakuXml(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?><i><chatserver>chat.bilibili.com</chatserver>
+<d p="3.5,1,25,16777215,1700000000,0,abc,1">第二条</d>
+<d p="1.25,4,18,16711680,1700000000,0,abc,2">底部</d>
+<d p="2,7,25,16777215,1700000000,0,abc,3">[0,0,"1-1",4.5,"高级弹幕"]</d>
+<d p="3.5,3,0,255,1700000000,0,abc,4">同一时间</d>
+<d p="0.5,2">字段不够</d>
+</i>`)
+	list, err := parseDanmakuXml(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按时间稳定排序, 模式2/3当作滚动弹幕, 字号为0时使用25
+	want := []danmakuItem{
+		{time: 1.25, mode: danmakuModeBottom, fontSize: 18, color: 0xff0000, text: "底部"},
+		{time: 3.5, mode: danmakuModeScroll, fontSize: 25, color: 0xffffff, text: "第二条"},
+		{time: 3.5, mode: danmakuModeScroll, fontSize: 25, color: 0xff, text: "同一时间"},
+	}
+	if reflect.DeepEqual(list, want) == false {
+		t.Fatal(list)
+	}
+	if _, err = parseDanmakuXml([]byte("<i><d>")); err == nil {
+		t.Fatal("invalid xml")
+	}
+}
+
+func TestFindScrollLane(t *testing.T) {
+	r := &danmakuRender{opt: DanmakuOption{}.withDefault(), scrollLanes: make([]danmakuLane, 3)}
+	// 滚动10秒, 宽100的弹幕速度是 2020/10 像素每秒, 尾部 100/202 秒后进入屏幕
+	tests := []struct {
+		now   float64
+		width float64
+		want  int
+	}{
+		{0, 100, 0},
+		// 前面几行的尾部还没有进入屏幕
+		{0.1, 100, 1},
+		{0.2, 100, 2},
+		{0.3, 100, -1},
+		// 第0行的尾部已经进入, 同样的速度追不上
+		{1, 100, 0},
+		// 更长的弹幕更快, 在每一行都会在前一条离开屏幕之前追上它
+		{1.6, 1000, -1},
+		{12, 1000, 0},
+	}
+	for _, tt := range tests {
+		if got := r.findScrollLane(tt.now, tt.width); got != tt.want {
+			t.Errorf("%v %v: got %d, want %d", tt.now, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestRenderDanmakuAss(t *testing.T) {
+	list := []danmakuItem{
+		{time: 0, mode: danmakuModeScroll, fontSize: 25, color: 0xffffff, text: "a"},
+		{time: 0, mode: danmakuModeTop, fontSize: 25, color: 0xff0000, text: "{顶部}"},
+		{time: 0.1, mode: danmakuModeTop, fontSize: 18, color: 0xffffff, text: "顶部2"},
+		// 同屏已经有3条, 丢弃
+		{time: 1, mode: danmakuModeBottom, fontSize: 25, color: 0xffffff, text: "丢弃"},
+		// 顶部弹幕5秒后结束, 第0行空闲
+		{time: 5, mode: danmakuModeTop, fontSize: 25, color: 0xffffff, text: "顶部3"},
+		{time: 5.1, mode: danmakuModeBottom, fontSize: 25, color: 0x00ff00, text: "底部"},
+		// 同屏又有3条
+		{time: 5.2, mode: danmakuModeReverse, fontSize: 25, color: 0xffffff, text: "丢弃"},
+		// 10秒时结束的弹幕不再计算在同屏数量里
+		{time: 10, mode: danmakuModeReverse, fontSize: 25, color: 0xffffff, text: "逆向"},
+	}
+	data := renderDanmakuAss(list, DanmakuOption{MaxOnScreen: 3, FontName: "黑体", Opacity: 0.5})
+	if strings.Contains(string(data), "Style: Danmaku,黑体,50,&H7FFFFFFF,") == false {
+		t.Fatal(string(data))
+	}
+	want := []string{
+		`0:00:00.00,0:00:10.00,Danmaku,,0,0,0,,{\move(1920,0,-25,0)}a`,
+		`0:00:00.00,0:00:05.00,Danmaku,,0,0,0,,{\an8\pos(960,0)\c&H0000FF&}｛顶部｝`,
+		`0:00:00.10,0:00:05.10,Danmaku,,0,0,0,,{\an8\pos(960,54)\fs36}顶部2`,
+		`0:00:05.00,0:00:10.00,Danmaku,,0,0,0,,{\an8\pos(960,0)}顶部3`,
+		`0:00:05.10,0:00:10.10,Danmaku,,0,0,0,,{\an2\pos(960,1080)\c&H00FF00&}底部`,
+		`0:00:10.00,0:00:20.00,Danmaku,,0,0,0,,{\move(-100,0,1920,0)}逆向`,
+	}
+	if got := getTestDialogueList(data); reflect.DeepEqual(got, want) == false {
+		t.Fatal(strings.Join(got, "\n"))
+	}
+
+	// 只用上面一半的屏幕时, 滚动弹幕只有 1080/54/2 行, 不限制同屏数量
+	list = nil
+	for i := 0; i < 12; i++ {
+		list = append(list, danmakuItem{time: float64(i) / 100, mode: danmakuModeScroll, fontSize: 25, color: 0xffffff, text: "滚动弹幕"})
+	}
+	got := getTestDialogueList(renderDanmakuAss(list, DanmakuOption{DisplayArea: 0.5}))
+	if len(got) != 10 || strings.HasSuffix(got[9], `{\move(1920,486,-200,486)}滚动弹幕`) == false {
+		t.Fatal(strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,226 @@
+package bilibili
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const _playUrlTemp = "%s/x/player/playurl?avid=%d&cid=%d&qn=%d&fnval=%d&fnver=0&fourk=1"
+const _fnvalDash = 4048 // dash + hdr + 4k + 杜比音频 + 杜比视界 + 8k + av1
+const _qnMax = 127
+
+const (
+	CodecAvc  = "avc"
+	CodecHevc = "hevc"
+	CodecAv1  = "av1"
+)
+
+var gCodecIdMap = map[string]int{
+	CodecAvc:  7,
+	CodecHevc: 12,
+	CodecAv1:  13,
+}
+
+type dashStream struct {
+	Id        int      `json:"id"`
+	BaseUrl   string   `json:"base_url"`
+	BackupUrl []string `json:"backup_url"`
+	Bandwidth int64    `json:"bandwidth"`
+	MimeType  string   `json:"mime_type"`
+	Codecs    string   `json:"codecs"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	FrameRate string   `json:"frame_rate"`
+	Codecid   int      `json:"codecid"`
+}
+
+type dashInfo struct {
+	Duration int64        `json:"duration"`
+	Video    []dashStream `json:"video"`
+	Audio    []dashStream `json:"audio"`
+	Dolby    *struct {
+		Audio []dashStream `json:"audio"`
+	} `json:"dolby"` // 杜比全景声, 一般需要大会员
+	Flac *struct {
+		Audio *dashStream `json:"audio"`
+	} `json:"flac"` // Hi-Res无损, 一般需要大会员
+}
+
+const (
+	_audioIdDolby = 30250
+	_audioIdFlac  = 30251
+)
+
+// getAllAudio 普通音频加上杜比和无损音频
+func (this *dashInfo) getAllAudio() (list []dashStream) {
+	list = append(list, this.Audio...)
+	if this.Dolby != nil {
+		list = append(list, this.Dolby.Audio...)
+	}
+	if this.Flac != nil && this.Flac.Audio != nil {
+		list = append(list, *this.Flac.Audio)
+	}
+	return list
+}
+
+func (this *BilibiliDownloader) getQn() int {
+	if this.req.Quality > 0 {
+		return this.req.Quality
+	}
+	return _qnMax
+}
+
+// buildPlayPartList 把playurl接口的返回转换为VideoPart, dash格式是一个视频流加一个音频流, 否则是durl里的flv/mp4分段
+func (this *BilibiliDownloader) buildPlayPartList(group string, referer string, data cidL1) (list []VideoPart, err error) {
+	if data.Dash == nil && this.req.AudioOnly {
+		return nil, newError("buildPlayPartList", ErrVideoNotFound, errors.New("不是dash格式, 没有单独的音频流"))
+	}
+	if data.Dash == nil {
+		ext := GetFormatForExt(data.Format)
+		for _, two := range data.Durl {
+			list = append(list, VideoPart{
+				Name:           fmt.Sprintf("%s_%d.%s", group, two.Order, ext),
+				Group:          group,
+				FileExtWithDot: "." + ext,
+				DownloadUrl:    two.URL,
+				BackupUrlList:  two.BackupUrl,
+				Header:         newBilibiliHeader(referer),
+				HasSize:        true,
+				SizeValue:      two.Size,
+			})
+		}
+		return list, nil
+	}
+	if this.req.AudioOnly {
+		audio, ok := pickDashAudioStream(data.Dash, this.req.AudioQuality, true)
+		if ok == false {
+			return nil, newError("buildPlayPartList", ErrVideoNotFound, errors.New("没有可用的音频流"))
+		}
+		list = append(list, VideoPart{
+			Name:           group + "_audio.m4s",
+			Group:          group,
+			StreamType:     StreamTypeAudio,
+			Codecs:         audio.Codecs,
+			FileExtWithDot: ".m4s",
+			DownloadUrl:    audio.BaseUrl,
+			BackupUrlList:  audio.BackupUrl,
+			Header:         newBilibiliHeader(referer),
+		})
+		return list, nil
+	}
+	video, ok := selectDashVideo(data.Dash.Video, this.req.Quality, this.req.Codec)
+	if ok == false {
+		return nil, newError("buildPlayPartList", ErrVideoNotFound, errors.New("没有可用的视频流"))
+	}
+	list = append(list, VideoPart{
+		Name:           group + "_video.m4s",
+		Group:          group,
+		StreamType:     StreamTypeVideo,
+		Codecs:         video.Codecs,
+		FileExtWithDot: ".m4s",
+		DownloadUrl:    video.BaseUrl,
+		BackupUrlList:  video.BackupUrl,
+		Header:         newBilibiliHeader(referer),
+	})
+	if audio, ok := pickDashAudioStream(data.Dash, this.req.AudioQuality, false); ok {
+		list = append(list, VideoPart{
+			Name:           group + "_audio.m4s",
+			Group:          group,
+			StreamType:     StreamTypeAudio,
+			Codecs:         audio.Codecs,
+			FileExtWithDot: ".m4s",
+			DownloadUrl:    audio.BaseUrl,
+			BackupUrlList:  audio.BackupUrl,
+			Header:         newBilibiliHeader(referer),
+		})
+	}
+	return list, nil
+}
+
+// selectDashVideo 选择不超过qn的最高清晰度, 同清晰度下按codec偏好选择
+func selectDashVideo(list []dashStream, qn int, codec string) (stream dashStream, ok bool) {
+	id, ok := selectDashId(list, qn)
+	if ok == false {
+		return stream, false
+	}
+	codecOrder := []int{gCodecIdMap[CodecAvc], gCodecIdMap[CodecHevc], gCodecIdMap[CodecAv1]}
+	if v, ok := gCodecIdMap[codec]; ok {
+		codecOrder = append([]int{v}, codecOrder...)
+	}
+	for _, codecId := range codecOrder {
+		for _, one := range list {
+			if one.Id == id && one.Codecid == codecId {
+				return one, true
+			}
+		}
+	}
+	for _, one := range list {
+		if one.Id == id {
+			return one, true
+		}
+	}
+	return stream, false
+}
+
+// pickDashAudioStream audioId 为杜比/无损的id时优先选择对应的音频; 为0时 preferLossless 决定是否优先无损和杜比.
+// 杜比和无损的id比192K小, 不能直接按id大小比较
+func pickDashAudioStream(dash *dashInfo, audioId int, preferLossless bool) (stream dashStream, ok bool) {
+	var orderList []int
+	switch {
+	case audioId == _audioIdFlac:
+		orderList = []int{_audioIdFlac, _audioIdDolby}
+	case audioId == _audioIdDolby:
+		orderList = []int{_audioIdDolby, _audioIdFlac}
+	case audioId <= 0 && preferLossless:
+		orderList = []int{_audioIdFlac, _audioIdDolby}
+	}
+	allAudio := dash.getAllAudio()
+	for _, id := range orderList {
+		for _, one := range allAudio {
+			if one.Id == id && one.BaseUrl != "" {
+				return one, true
+			}
+		}
+	}
+	if audioId == _audioIdFlac || audioId == _audioIdDolby {
+		audioId = 0
+	}
+	return selectDashAudio(dash.Audio, audioId)
+}
+
+// selectDashAudio 选择不超过audioId的最高音质
+func selectDashAudio(list []dashStream, audioId int) (stream dashStream, ok bool) {
+	id, ok := selectDashId(list, audioId)
+	if ok == false {
+		return stream, false
+	}
+	for _, one := range list {
+		if one.Id == id {
+			return one, true
+		}
+	}
+	return stream, false
+}
+
+// selectDashId 返回不超过want的最大id, 都超过时返回最小的id, want<=0时返回最大的id
+func selectDashId(list []dashStream, want int) (id int, ok bool) {
+	if len(list) == 0 {
+		return 0, false
+	}
+	var idList []int
+	for _, one := range list {
+		idList = append(idList, one.Id)
+	}
+	sort.Ints(idList)
+	if want <= 0 {
+		return idList[len(idList)-1], true
+	}
+	id = idList[0]
+	for _, one := range idList {
+		if one <= want {
+			id = one
+		}
+	}
+	return id, true
+}
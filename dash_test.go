@@ -0,0 +1,165 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSelectDashVideo(t *testing.T) {
+	stream := func(id int, codec string) dashStream {
+		return dashStream{Id: id, Codecid: gCodecIdMap[codec], BaseUrl: codec + "_" + string(rune('0'+id%10))}
+	}
+	list := []dashStream{
+		stream(80, CodecHevc), stream(80, CodecAvc), stream(80, CodecAv1),
+		stream(64, CodecAv1), stream(64, CodecHevc),
+		stream(32, CodecAvc),
+	}
+	tests := []struct {
+		qn    int
+		codec string
+		want  dashStream
+	}{
+		{0, "", stream(80, CodecAvc)},
+		{0, CodecHevc, stream(80, CodecHevc)},
+		{127, CodecAv1, stream(80, CodecAv1)},
+		// 同清晰度下没有avc时按 hevc, av1 的顺序
+		{64, "", stream(64, CodecHevc)},
+		{64, CodecAvc, stream(64, CodecHevc)},
+		{64, CodecAv1, stream(64, CodecAv1)},
+		{74, CodecAv1, stream(64, CodecAv1)},
+		// 比所有清晰度都低时选择最低的
+		{16, CodecHevc, stream(32, CodecAvc)},
+	}
+	for _, tt := range tests {
+		got, ok := selectDashVideo(list, tt.qn, tt.codec)
+		if ok == false || reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("qn %d %q: got %+v", tt.qn, tt.codec, got)
+		}
+	}
+	// 未知的 codecid 也能选中
+	got, ok := selectDashVideo([]dashStream{{Id: 80, Codecid: 99}}, 0, "")
+	if ok == false || got.Codecid != 99 {
+		t.Fatal(got, ok)
+	}
+	if _, ok = selectDashVideo(nil, 0, ""); ok {
+		t.Fatal("empty list")
+	}
+}
+
+func TestPickDashAudioStream(t *testing.T) {
+	dash := &dashInfo{
+		Audio: []dashStream{{Id: 30216, BaseUrl: "64k"}, {Id: 30280, BaseUrl: "192k"}, {Id: 30232, BaseUrl: "132k"}},
+	}
+	dash.Dolby = &struct {
+		Audio []dashStream `json:"audio"`
+	}{Audio: []dashStream{{Id: _audioIdDolby, BaseUrl: "dolby"}}}
+	dash.Flac = &struct {
+		Audio *dashStream `json:"audio"`
+	}{Audio: &dashStream{Id: _audioIdFlac, BaseUrl: "flac"}}
+
+	tests := []struct {
+		audioId        int
+		preferLossless bool
+		want           string
+	}{
+		{0, false, "192k"},
+		{0, true, "flac"},
+		{30232, true, "132k"},
+		{30240, false, "132k"},
+		{30300, false, "192k"},
+		{30100, false, "64k"},
+		{_audioIdDolby, false, "dolby"},
+		{_audioIdFlac, false, "flac"},
+	}
+	for _, tt := range tests {
+		got, ok := pickDashAudioStream(dash, tt.audioId, tt.preferLossless)
+		if ok == false || got.BaseUrl != tt.want {
+			t.Errorf("%d %v: got %+v", tt.audioId, tt.preferLossless, got)
+		}
+	}
+
+	// 没有无损时用杜比代替, 都没有时选择最高音质
+	dash.Flac.Audio = nil
+	if got, _ := pickDashAudioStream(dash, _audioIdFlac, false); got.BaseUrl != "dolby" {
+		t.Fatal(got)
+	}
+	dash.Dolby = nil
+	if got, _ := pickDashAudioStream(dash, _audioIdDolby, false); got.BaseUrl != "192k" {
+		t.Fatal(got)
+	}
+	if _, ok := pickDashAudioStream(&dashInfo{}, 0, true); ok {
+		t.Fatal("empty")
+	}
+}
+
+func TestBeginDownloadReqCheck(t *testing.T) {
+	for _, req := range []BeginDownload_Req{
+		{},
+		{Codec: CodecAvc, SubtitleFormat: SubtitleFormatSrt},
+		{Codec: CodecHevc},
+		{Codec: CodecAv1},
+	} {
+		if err := req.check(); err != nil {
+			t.Error(req.Codec, req.SubtitleFormat, err)
+		}
+	}
+	for _, req := range []BeginDownload_Req{
+		{Codec: "h264"},
+		{Codec: "HEVC"},
+		{SubtitleFormat: "txt"},
+	} {
+		if err := req.check(); err == nil {
+			t.Error(req.Codec, req.SubtitleFormat)
+		}
+	}
+}
+
+// TestDownloadBackupUrl 主地址不能访问时使用备用地址
+func TestDownloadBackupUrl(t *testing.T) {
+	var pathList []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pathList = append(pathList, r.URL.Path)
+		if strings.HasPrefix(r.URL.Path, "/bad") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("0123456789"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	d := newBilibiliDownloader(BeginDownload_Req{SaveDir: dir})
+	d.sink = gDiscardEventSink
+	defer d.closeFn()
+	for _, cas := range []struct {
+		part VideoPart
+		want []string
+	}{
+		// 不知道大小时在获取大小时切换地址, 之后直接从能访问的地址下载
+		{VideoPart{Name: "a", FileExtWithDot: ".mp4", Header: newBilibiliHeader(""), DownloadUrl: srv.URL + "/bad1", BackupUrlList: []string{srv.URL + "/bad2", srv.URL + "/good"}}, []string{"/bad1", "/bad2", "/good", "/good"}},
+		// 已经知道大小时在下载时切换地址
+		{VideoPart{Name: "b", FileExtWithDot: ".mp4", Header: newBilibiliHeader(""), DownloadUrl: srv.URL + "/bad1", BackupUrlList: []string{srv.URL + "/good"}, HasSize: true, SizeValue: 10}, []string{"/bad1", "/good"}},
+	} {
+		pathList = nil
+		outName, err := d.downloadVideo(VideoInfo{Name: cas.part.Name, PartList: []VideoPart{cas.part}})
+		if err != nil {
+			t.Fatal(cas.part.Name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, outName+".mp4"))
+		if err != nil || string(data) != "0123456789" || reflect.DeepEqual(pathList, cas.want) == false {
+			t.Fatal(cas.part.Name, string(data), err, pathList)
+		}
+	}
+
+	pathList = nil
+	part := VideoPart{Name: "c", FileExtWithDot: ".mp4", Header: newBilibiliHeader(""), DownloadUrl: srv.URL + "/bad1", BackupUrlList: []string{srv.URL + "/bad2"}, HasSize: true, SizeValue: 10}
+	_, err := d.downloadVideo(VideoInfo{Name: part.Name, PartList: []VideoPart{part}})
+	if err == nil || reflect.DeepEqual(pathList, []string{"/bad1", "/bad2"}) == false {
+		t.Fatal(err, pathList)
+	}
+}
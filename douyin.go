@@ -0,0 +1,202 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// 抖音接口的域名, 测试时可以替换成本地服务
+var gDouyinApiHost = "https://www.iesdouyin.com"
+
+const _douyinItemInfoUrlTemp = "%s/web/api/v2/aweme/iteminfo/?item_ids=%s"
+const _douyinUserPostUrlTemp = "%s/web/api/v2/aweme/post/?sec_uid=%s&count=%d&max_cursor=%d"
+const _douyinMixItemUrlTemp = "%s/web/api/mix/item/list/?mix_id=%s&count=%d&cursor=%d"
+const _douyinPageSize = 20
+
+// 文件名里的描述最多保留的字数, 抖音的描述经常带有很长的话题标签
+const _douyinMaxDescLen = 50
+
+var (
+	gDouyinUserRegexp = regexp.MustCompile(`(?:douyin\.com/user/|iesdouyin\.com/share/user/\d+\?(?:.*&)?sec_uid=)([\w-]+)`)
+	gDouyinMixRegexp  = regexp.MustCompile(`(?:douyin\.com/collection/|iesdouyin\.com/share/mix/detail/)(\d+)`)
+)
+
+// douyinListExtractor 抖音用户的所有作品和合集, 每个作品下载时再用 douyinExtractor 解析
+type douyinListExtractor struct{}
+
+func (douyinListExtractor) Match(url string) bool {
+	// 用户主页上打开的作品网址带有 modal_id, 只下载这个作品
+	if gDouyinRegexp.MatchString(url) {
+		return false
+	}
+	return gDouyinUserRegexp.MatchString(url) || gDouyinMixRegexp.MatchString(url)
+}
+
+func (douyinListExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	this := getDownloaderFromCtx(ctx)
+	if params := gDouyinMixRegexp.FindStringSubmatch(url); params != nil {
+		return this.getVideoInfoList_ByDouyinMix(params[1])
+	} else if params = gDouyinUserRegexp.FindStringSubmatch(url); params != nil {
+		return this.getVideoInfoList_ByDouyinUser(params[1])
+	}
+	return VideoInfo{}, ErrUnsupportedURL
+}
+
+type douyinAweme struct {
+	AwemeId    string `json:"aweme_id"`
+	Desc       string `json:"desc"`
+	CreateTime int64  `json:"create_time"`
+	IsTop      int    `json:"is_top"` // 置顶的作品, 最多3个, 排在最前面
+	Author     struct {
+		Uid      string `json:"uid"`
+		Nickname string `json:"nickname"`
+	} `json:"author"`
+	MixInfo struct {
+		MixName string `json:"mix_name"`
+	} `json:"mix_info"`
+	Video struct {
+		PlayAddr struct {
+			URI     string   `json:"uri"`
+			URLList []string `json:"url_list"`
+		} `json:"play_addr"`
+		Vid string `json:"vid"`
+	} `json:"video"`
+}
+
+// getDouyinName 作品id加上描述, 描述修改后已下载的文件名也不会变的太多
+func getDouyinName(awemeId string, desc string) string {
+	title := TitleEdit(desc)
+	if utf8.RuneCountInString(title) > _douyinMaxDescLen {
+		title = string([]rune(title)[:_douyinMaxDescLen])
+	}
+	if title == "" {
+		return awemeId
+	}
+	return awemeId + "_" + title
+}
+
+func newDouyinEntry(item douyinAweme, index int) VideoEntry {
+	return VideoEntry{
+		Id:      item.AwemeId,
+		Url:     "https://www.douyin.com/video/" + item.AwemeId,
+		Title:   item.Desc,
+		Index:   index,
+		PubDate: time.Unix(item.CreateTime, 0),
+	}
+}
+
+// getVideoInfoList_ByDouyinUser 按 max_cursor 分页获取用户的所有作品, 按发布时间从新到旧排列
+func (this *BilibiliDownloader) getVideoInfoList_ByDouyinUser(secUid string) (info VideoInfo, err error) {
+	filter := this.req.Filter
+	var uid, nickname string
+	var cursor int64
+	var fetched int
+	for {
+		contents, err := this.defaultFetcher(fmt.Sprintf(_douyinUserPostUrlTemp, gDouyinApiHost, secUid, _douyinPageSize, cursor))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByDouyinUser", err)
+		}
+		var tmp struct {
+			StatusCode int           `json:"status_code"`
+			AwemeList  []douyinAweme `json:"aweme_list"`
+			MaxCursor  int64         `json:"max_cursor"`
+			HasMore    bool          `json:"has_more"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByDouyinUser_2", err)
+		}
+		if tmp.StatusCode != 0 {
+			return info, apiCodeError("获取抖音用户作品失败", tmp.StatusCode, "")
+		}
+		var isEnd bool
+		for _, one := range tmp.AwemeList {
+			uid, nickname = one.Author.Uid, one.Author.Nickname
+			entry := newDouyinEntry(one, 0)
+			// 置顶的作品可能比后面的早, 不能用来提前结束
+			if filter.After.IsZero() == false && entry.PubDate.Before(filter.After) && one.IsTop == 0 {
+				isEnd = true
+				break
+			}
+			fetched++
+			if filter.match(entry) {
+				info.EntryList = append(info.EntryList, entry)
+			}
+			if filter.isFull(len(info.EntryList)) {
+				isEnd = true
+				break
+			}
+		}
+		this.fnMessage(fmt.Sprintf("获取抖音用户作品: %d", fetched))
+		if isEnd || tmp.HasMore == false || len(tmp.AwemeList) == 0 || tmp.MaxCursor == cursor {
+			break
+		}
+		cursor = tmp.MaxCursor
+		this.sleepDur(time.Millisecond * 500) // 太快会触发风控
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取抖音用户作品失败", ErrVideoNotFound, nil)
+	}
+	if uid == "" {
+		uid = secUid
+	}
+	info.Title = nickname
+	info.Name = fmt.Sprintf("douyin%s_%s", uid, TitleEdit(nickname))
+	return info, nil
+}
+
+// getVideoInfoList_ByDouyinMix 合集按集数排列, 文件名前面加上序号
+func (this *BilibiliDownloader) getVideoInfoList_ByDouyinMix(mixId string) (info VideoInfo, err error) {
+	var mixName string
+	var cursor int64
+	var index int
+	for {
+		contents, err := this.defaultFetcher(fmt.Sprintf(_douyinMixItemUrlTemp, gDouyinApiHost, mixId, _douyinPageSize, cursor))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByDouyinMix", err)
+		}
+		var tmp struct {
+			StatusCode int           `json:"status_code"`
+			AwemeList  []douyinAweme `json:"aweme_list"`
+			Cursor     int64         `json:"cursor"`
+			HasMore    bool          `json:"has_more"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByDouyinMix_2", err)
+		}
+		if tmp.StatusCode != 0 {
+			return info, apiCodeError("获取抖音合集失败", tmp.StatusCode, "")
+		}
+		var isFull bool
+		for _, one := range tmp.AwemeList {
+			if one.MixInfo.MixName != "" {
+				mixName = one.MixInfo.MixName
+			}
+			index++
+			entry := newDouyinEntry(one, index)
+			if this.req.Filter.match(entry) {
+				info.EntryList = append(info.EntryList, entry)
+			}
+			if isFull = this.req.Filter.isFull(len(info.EntryList)); isFull {
+				break
+			}
+		}
+		this.fnMessage(fmt.Sprintf("获取抖音合集: %d", index))
+		if isFull || tmp.HasMore == false || len(tmp.AwemeList) == 0 || tmp.Cursor == cursor {
+			break
+		}
+		cursor = tmp.Cursor
+		this.sleepDur(time.Millisecond * 500)
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取抖音合集失败", ErrVideoNotFound, nil)
+	}
+	info.Title = mixName
+	info.Name = fmt.Sprintf("mix%s_%s", mixId, TitleEdit(mixName))
+	return info, nil
+}
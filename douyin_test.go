@@ -0,0 +1,57 @@
+package bilibili
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDouyinUserPinned(t *testing.T) {
+	item := func(id string, createTime int64, isTop int) string {
+		return fmt.Sprintf(`{"aweme_id":"%s","desc":"作品%s","create_time":%d,"is_top":%d,"author":{"uid":"999","nickname":"小明"}}`, id, id, createTime, isTop)
+	}
+	var cursorList []string
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/web/api/v2/aweme/post/" || r.URL.Query().Get("sec_uid") != "MS4wLjABAAAA" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		cursor := r.URL.Query().Get("max_cursor")
+		cursorList = append(cursorList, cursor)
+		switch cursor {
+		case "0": // 3个置顶的旧作品排在最前面
+			fmt.Fprintf(w, `{"status_code":0,"has_more":true,"max_cursor":100,"aweme_list":[%s]}`, strings.Join([]string{
+				item("1", 1500000000, 1), item("2", 1400000000, 1), item("3", 1300000000, 1),
+				item("11", 1700000300, 0), item("12", 1700000200, 0),
+			}, ","))
+		case "100":
+			fmt.Fprintf(w, `{"status_code":0,"has_more":true,"max_cursor":200,"aweme_list":[%s]}`, strings.Join([]string{
+				item("13", 1700000100, 0), item("14", 1600000000, 0),
+			}, ","))
+		default:
+			t.Error("should stop before cursor", cursor)
+			w.Write([]byte(`{"status_code":0,"has_more":false,"aweme_list":[]}`))
+		}
+	})
+	req := BeginDownload_Req{Url: "https://www.douyin.com/user/MS4wLjABAAAA"}
+	req.Filter.After = time.Unix(1700000000, 0)
+	d := newBilibiliDownloader(req)
+	d.sink = gDiscardEventSink
+	info, err := d.getVideoInfo(req.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idList []string
+	for _, one := range info.EntryList {
+		idList = append(idList, one.Id)
+	}
+	if reflect.DeepEqual(idList, []string{"11", "12", "13"}) == false || reflect.DeepEqual(cursorList, []string{"0", "100"}) == false {
+		t.Fatal(idList, cursorList)
+	}
+	if info.Name != "douyin999_小明" || info.EntryList[0].Url != "https://www.douyin.com/video/11" {
+		t.Fatal(info.Name, info.EntryList[0])
+	}
+}
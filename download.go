@@ -2,15 +2,14 @@ package bilibili
 
 import (
 	"context"
-	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math"
 	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,14 +23,45 @@ type BilibiliDownloader struct {
 	speedBytesLocker sync.Mutex
 	speedBeginTime   time.Time
 	speedBytesMap    map[time.Time]int64
+	sink             EventSink // 为nil时使用 InitPrintFnS 设置的全局回调
+	jar              http.CookieJar
+	jarErr           error // 读取cookie失败时, 在开始解析时返回
+	httpClient       *http.Client
 }
 
-var gDownloader *BilibiliDownloader
-var gDownloaderLocker sync.Mutex
-
 type BeginDownload_Req struct {
-	Url     string
-	SaveDir string
+	Url          string
+	SaveDir      string
+	Quality      int        // 清晰度qn, 例如 116(1080P60) 80(1080P) 64(720P), 0 表示最高
+	Codec        string     // 视频编码 CodecAvc/CodecHevc/CodecAv1, 空表示优先 CodecAvc
+	AudioQuality int        // 音质id 30216(64K) 30232(132K) 30280(192K) 30250(杜比) 30251(无损), 0 表示最高
+	AudioOnly    bool       // 只下载音频, aac和杜比音频保存为m4a, 无损音频保存为flac, AudioQuality 为0时优先无损和杜比
+	Filter       ListFilter // UP主投稿等列表下载时的过滤条件
+	Pages        string     // 要下载的分P, 例如 "5", "1-10,15", "all", "latest", 空表示使用网址里的p参数, 没有p参数时下载全部
+	FlvToMp4     bool       // flv分段合并后再转换为mp4, m3u8的ts分片拼接后也转换为mp4
+	Cookie       string     // 浏览器里复制的 Cookie 请求头, 例如 "SESSDATA=xxx", 用于下载1080P以上/大会员/付费视频
+	CookieFile   string     // Netscape 格式的 cookies.txt
+	// 字幕格式 SubtitleFormatSrt/SubtitleFormatVtt/SubtitleFormatAss, 空表示不下载CC字幕
+	SubtitleFormat  string
+	SubtitleLanList []string // 字幕语言, 例如 zh-CN en-US ai-zh, 空表示所有语言
+	Danmaku         DanmakuOption
+	Metadata        bool // 保存 .info.json/封面/nfo, 下载目录可以直接给 Kodi/Jellyfin 使用
+	Live            LiveOption
+	Hls             HlsOption
+}
+
+func (this BeginDownload_Req) check() error {
+	switch this.SubtitleFormat {
+	case "", SubtitleFormatSrt, SubtitleFormatVtt, SubtitleFormatAss:
+	default:
+		return newError("", nil, errors.New("不支持的字幕格式: "+this.SubtitleFormat))
+	}
+	switch this.Codec {
+	case "", CodecAvc, CodecHevc, CodecAv1:
+	default:
+		return newError("", nil, errors.New("不支持的视频编码: "+this.Codec))
+	}
+	return nil
 }
 
 type PrintFnS struct {
@@ -94,59 +124,196 @@ func FnDownloadFinish(outMp4File string) {
 	gPrintFnS.FnDownloadFinish(outMp4File)
 }
 
+func (this *BilibiliDownloader) emit(ev Event) {
+	if this.sink == nil {
+		gPrintFnSEventSink.OnEvent(ev)
+		return
+	}
+	this.sink.OnEvent(ev)
+}
+
+func (this *BilibiliDownloader) fnMessage(msg string) {
+	this.emit(Event{Type: EventMessage, Message: msg})
+}
+
 var gRunningThreadCount int64
 var gRunningThreadCountLocker sync.Mutex
 
-func BeginDownloadAsync(req BeginDownload_Req) {
+func updateRunningThreadCount(delta int64) {
 	gRunningThreadCountLocker.Lock()
-	gRunningThreadCount++
-	if gRunningThreadCount == 1 {
+	defer gRunningThreadCountLocker.Unlock()
+
+	gRunningThreadCount += delta
+	if delta > 0 && gRunningThreadCount == delta {
 		FnUpdateRunning(true)
+	} else if gRunningThreadCount == 0 {
+		FnUpdateRunning(false)
+	}
+}
+
+// gManager BeginDownloadAsync 添加的任务都在这里运行, 不限制数量, 新的任务不会中断正在下载的任务
+var gManager = NewManager(0, EventSinkFunc(func(ev Event) {
+	gPrintFnSEventSink.OnEvent(ev)
+	switch ev.Type {
+	case EventFinished, EventError, EventCanceled:
+		updateRunningThreadCount(-1)
 	}
-	gRunningThreadCountLocker.Unlock()
+}))
 
+func BeginDownloadAsync(req BeginDownload_Req) {
+	updateRunningThreadCount(1)
+	gManager.AddTask(req)
+}
+
+// NewBilibiliDownloader 创建一个独立的下载器, 事件发送到sink, sink为nil时使用 InitPrintFnS 设置的全局回调
+func NewBilibiliDownloader(req BeginDownload_Req, sink EventSink) *BilibiliDownloader {
+	tmp := newBilibiliDownloader(req)
+	tmp.sink = sink
+	return tmp
+}
+
+// Stop 中断正在进行的 RunDownload
+func (this *BilibiliDownloader) Stop() {
+	this.closeFn()
+}
+
+func newBilibiliDownloader(req BeginDownload_Req) *BilibiliDownloader {
+	return newBilibiliDownloaderWithCtx(context.Background(), req)
+}
+
+// newBilibiliDownloaderWithCtx 按 req 里的 Cookie/CookieFile 创建cookie, 都为空时使用扫码登录保存的文件
+func newBilibiliDownloaderWithCtx(parent context.Context, req BeginDownload_Req) *BilibiliDownloader {
+	cookieFile := req.CookieFile
+	if req.Cookie == "" && cookieFile == "" {
+		if name := gSessionFileFn(); name != "" {
+			if _, err := os.Stat(name); err == nil {
+				cookieFile = name
+			}
+		}
+	}
+	jar, jarErr := LoadCookieJar(req.Cookie, cookieFile)
+	tmp := newBilibiliDownloaderWithClient(parent, req, &http.Client{Jar: jar})
+	tmp.jarErr = jarErr
+	return tmp
+}
+
+// newBilibiliDownloaderWithClient 不读取cookie文件, 直接使用 client 和它的cookie.
+// 列表中的每个视频和上层的下载器共用一个 client
+func newBilibiliDownloaderWithClient(parent context.Context, req BeginDownload_Req, client *http.Client) *BilibiliDownloader {
 	tmp := &BilibiliDownloader{
 		req:           req,
 		speedBytesMap: map[time.Time]int64{},
+		jar:           client.Jar,
+		httpClient:    client,
 	}
-	tmp.ctx, tmp.closeFn = context.WithCancel(context.Background())
+	ctx, closeFn := context.WithCancel(parent)
+	tmp.ctx = context.WithValue(ctx, downloaderCtxKey{}, tmp)
+	tmp.closeFn = closeFn
+	return tmp
+}
 
-	gDownloaderLocker.Lock()
-	if gDownloader != nil {
-		gDownloader.closeFn()
-	}
-	gDownloader = tmp
-	gDownloaderLocker.Unlock()
+// StopDownload 中断 BeginDownloadAsync 添加的所有任务
+func StopDownload() {
+	gManager.StopAll()
+}
 
-	go tmp.RunDownload()
+// Result 是 Download 成功时的结果
+type Result struct {
+	OutName string // 输出文件名, 多个分P时为目录名
+	Info    VideoInfo
 }
 
-func StopDownload() {
-	gDownloaderLocker.Lock()
-	if gDownloader != nil {
-		gDownloader.closeFn()
-	}
-	gDownloaderLocker.Unlock()
+// Download 同步的解析并下载 req.Url, ctx 取消时中断下载并返回 ErrCanceled.
+// 不会调用 InitPrintFnS 设置的全局回调, 返回的错误可以用 errors.Is 判断分类
+func Download(ctx context.Context, req BeginDownload_Req) (Result, error) {
+	tmp := newBilibiliDownloaderWithCtx(ctx, req)
+	tmp.sink = gDiscardEventSink
+	defer tmp.closeFn()
+
+	return tmp.Run()
 }
 
 func (this *BilibiliDownloader) GetVideoInfoListV2(urlInput string) (resp GetVideoInfoList_Resp) {
-	//if params := regexp.MustCompile(`space.bilibili.com/(\d+)/?`).FindStringSubmatch(urlInput); len(params) > 0 {
-	//	upId, _ := strconv.ParseInt(params[1], 10, 64)
-	//	return getVideoInfoList_ByUpId(upId)
-	//} else
-	if params := regexp.MustCompile(`/?(BV\w+)[/?]?`).FindStringSubmatch(urlInput); params != nil {
-		aid := Bv2av(params[1])
-		return this.getVideoInfoList_ByAidV2(aid)
-	} else if params = regexp.MustCompile(`/?(av\d+)/?`).FindStringSubmatch(urlInput); len(params) > 0 {
-		aid, _ := strconv.ParseInt(strings.TrimPrefix(params[1], "av"), 10, 64)
-		return this.getVideoInfoList_ByAidV2(aid)
-	} else if params = regexp.MustCompile(`https://www.douyin.com/video/(\d+)`).FindStringSubmatch(urlInput); len(params) > 0 {
-		return this.getVideoListDouYin(params[1])
-	}
-	resp.ErrMsg = "您输入的网址无法解析"
+	result, err := this.download(urlInput)
+	if err != nil {
+		resp.ErrMsg = err.Error()
+		return resp
+	}
+	resp.OutName = result.OutName
+	return resp
+}
+
+func (this *BilibiliDownloader) download(urlInput string) (result Result, err error) {
+	result.Info, err = this.getVideoInfo(urlInput)
+	if err != nil {
+		return result, err
+	}
+	this.emit(Event{Type: EventResolved, Info: &result.Info})
+	if len(result.Info.EntryList) > 0 {
+		result.OutName, err = this.downloadList(result.Info)
+		return result, err
+	}
+	if result.Info.Live != nil {
+		result.OutName, err = this.recordLive(result.Info)
+		return result, err
+	}
+	result.OutName, err = this.downloadVideo(result.Info)
+	return result, err
+}
+
+// GetVideoInfo 只解析视频信息, 不下载
+func GetVideoInfo(req BeginDownload_Req) (resp GetVideoInfo_Resp) {
+	tmp := newBilibiliDownloader(req)
+	defer tmp.closeFn()
+
+	info, err := tmp.getVideoInfo(req.Url)
+	if err != nil {
+		resp.ErrMsg = err.Error()
+		return resp
+	}
+	resp.Info = info
 	return resp
 }
 
+// ResolveVideoInfo 同 GetVideoInfo, 失败时返回error
+func ResolveVideoInfo(ctx context.Context, req BeginDownload_Req) (VideoInfo, error) {
+	tmp := newBilibiliDownloaderWithCtx(ctx, req)
+	tmp.sink = gDiscardEventSink
+	defer tmp.closeFn()
+
+	return tmp.getVideoInfo(req.Url)
+}
+
+type GetVideoInfo_Resp struct {
+	ErrMsg string
+	Info   VideoInfo
+}
+
+func (this *BilibiliDownloader) getVideoInfo(urlInput string) (info VideoInfo, err error) {
+	if this.jarErr != nil {
+		return info, this.jarErr
+	}
+	if err = this.req.check(); err != nil {
+		return info, err
+	}
+	urlInput, err = this.resolveUrl(urlInput)
+	if err != nil {
+		if this.isCancel() {
+			return info, newError("", ErrCanceled, err)
+		}
+		return info, err
+	}
+	e := findExtractor(urlInput)
+	if e == nil {
+		return info, ErrUnsupportedURL
+	}
+	info, err = e.Extract(this.ctx, urlInput)
+	if err != nil && this.isCancel() && errors.Is(err, ErrCanceled) == false {
+		return info, newError("", ErrCanceled, err)
+	}
+	return info, err
+}
+
 // source code: https://blog.csdn.net/dotastar00/article/details/108805779
 func Bv2av(x string) int64 {
 	const table = "fZodR9XQDSUm21yCkr6zBqiveYah8bt4xsWpHnJE7jL5VG3guMTKNPAwcF"
@@ -165,23 +332,59 @@ func Bv2av(x string) int64 {
 	return int64((r - add) ^ xor)
 }
 
-const _getCidUrlTemp = "https://api.bilibili.com/x/web-interface/view?aid=%d"
-const _entropy = "rbMCKn@KuamXWlPMoJGsKcbiJKUfkPF_8dABscJntvqhRSETg"
-const _paramsTemp = "appkey=%s&cid=%s&otype=json&qn=%s&quality=%s&type="
-const _playApiTemp = "https://interface.bilibili.com/v2/playurl?%s&sign=%s"
-const _quality = "80"
+const _getCidUrlTemp = "%s/x/web-interface/view?aid=%d"
+
+type cidL1 struct {
+	From              string   `json:"from"`
+	Result            string   `json:"result"`
+	Quality           int      `json:"quality"`
+	Format            string   `json:"format"`
+	Timelength        int      `json:"timelength"`
+	AcceptFormat      string   `json:"accept_format"`
+	AcceptDescription []string `json:"accept_description"`
+	AcceptQuality     []int    `json:"accept_quality"`
+	VideoCodecid      int      `json:"video_codecid"`
+	VideoProject      bool     `json:"video_project"`
+	SeekParam         string   `json:"seek_param"`
+	SeekType          string   `json:"seek_type"`
+	Durl              []struct {
+		Order     int64    `json:"order"`
+		Length    int64    `json:"length"`
+		Size      int64    `json:"size"`
+		URL       string   `json:"url"`
+		BackupUrl []string `json:"backup_url"`
+	} `json:"durl"`
+	Dash *dashInfo `json:"dash"`
+}
 
-func (this *BilibiliDownloader) getVideoInfoList_ByAidV2(aid int64) (resp GetVideoInfoList_Resp) {
-	contents, err := this.defaultFetcher(fmt.Sprintf(_getCidUrlTemp, aid))
+func newBilibiliHeader(referer string) http.Header {
+	header := make(http.Header)
+	header.Set("User-Agent", userAgent)
+	header.Set("Accept", "*/*")
+	header.Set("Accept-Language", "en-US,en;q=0.5")
+	header.Set("Accept-Encoding", "gzip, deflate, br")
+	header.Set("Referer", referer)
+	header.Set("Origin", "https://www.bilibili.com")
+	header.Set("Connection", "keep-alive")
+	return header
+}
+
+// getVideoInfoList_ByAidV2 只解析 selector 选中的分P
+func (this *BilibiliDownloader) getVideoInfoList_ByAidV2(aid int64, selector pageSelector) (info VideoInfo, err error) {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_getCidUrlTemp, gBilibiliApiHost, aid))
 	if err != nil {
-		resp.ErrMsg = "getVideoInfoList_ByAidV2 " + err.Error()
-		return resp
+		return info, wrapError("getVideoInfoList_ByAidV2", err)
 	}
+	viewContents := contents
 	var tmp struct {
 		Code    int    `json:"code"`
 		Message string `json:"message"`
 		Data    struct {
 			Title string `json:"title"`
+			Pic   string `json:"pic"`
+			Owner struct {
+				Name string `json:"name"`
+			} `json:"owner"`
 			Pages []struct {
 				Cid  int64  `json:"cid"`
 				Page int64  `json:"page"`
@@ -192,164 +395,163 @@ func (this *BilibiliDownloader) getVideoInfoList_ByAidV2(aid int64) (resp GetVid
 	}
 	err = json.Unmarshal(contents, &tmp)
 	if err != nil {
-		resp.ErrMsg = "getVideoInfoList_ByAidV2_2 " + err.Error()
-		return resp
+		return info, wrapError("getVideoInfoList_ByAidV2_2", err)
+	}
+	if tmp.Code != 0 {
+		return info, apiCodeError("getVideoInfoList_ByAidV2_2", tmp.Code, tmp.Message)
 	}
 	title := TitleEdit(tmp.Data.Title)
-	FnMessage("视频名: " + title)
-	appKey, sec := GetAppKey(_entropy)
-
-	type cidL1 struct {
-		From              string   `json:"from"`
-		Result            string   `json:"result"`
-		Quality           int      `json:"quality"`
-		Format            string   `json:"format"`
-		Timelength        int      `json:"timelength"`
-		AcceptFormat      string   `json:"accept_format"`
-		AcceptDescription []string `json:"accept_description"`
-		AcceptQuality     []int    `json:"accept_quality"`
-		VideoCodecid      int      `json:"video_codecid"`
-		VideoProject      bool     `json:"video_project"`
-		SeekParam         string   `json:"seek_param"`
-		SeekType          string   `json:"seek_type"`
-		Durl              []struct {
-			Order  int64  `json:"order"`
-			Length int64  `json:"length"`
-			Size   int64  `json:"size"`
-			URL    string `json:"url"`
-		} `json:"durl"`
-	}
-
-	type videoCid struct {
-		Aid    int64
-		Cid    int64
-		Page   int64
-		Part   string
-		UrlApi string
-		L1Data cidL1
-	}
-	var list []videoCid
+	this.fnMessage("视频名: " + title)
 
+	info.Title = tmp.Data.Title
+	info.Author = tmp.Data.Owner.Name
+	info.CoverUrl = tmp.Data.Pic
 	for _, i := range tmp.Data.Pages {
-		cid := i.Cid
-		page := i.Page
-		part := TitleEdit(i.Part) //remove special characters
-
-		cidStr := strconv.FormatInt(cid, 10)
-
-		params := fmt.Sprintf(_paramsTemp, appKey, cidStr, _quality, _quality)
-		chksum := fmt.Sprintf("%x", md5.Sum([]byte(params+sec)))
-
-		urlApi := fmt.Sprintf(_playApiTemp, params, chksum)
-		tmp2 := videoCid{
-			Aid:    aid,
-			Cid:    cid,
-			Page:   page,
-			Part:   part,
-			UrlApi: urlApi,
+		if selector.match(int(i.Page), len(tmp.Data.Pages)) == false {
+			continue
 		}
-		contents, err = this.defaultFetcher(tmp2.UrlApi)
+		contents, err = this.defaultFetcher(fmt.Sprintf(_playUrlTemp, gBilibiliApiHost, aid, i.Cid, this.getQn(), _fnvalDash))
 		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+			return info, wrapError("getVideoInfoList_ByAidV2_3", err)
+		}
+		var play struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    cidL1  `json:"data"`
 		}
-		err = json.Unmarshal(contents, &tmp2.L1Data)
+		err = json.Unmarshal(contents, &play)
 		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+			return info, wrapError("getVideoInfoList_ByAidV2_4", err)
 		}
-		list = append(list, tmp2)
-	}
-	title = TitleEdit(title)
-
-	var info VideoInfo
-	var totalLength int64
-
-	for _, one := range list {
-		for _, two := range one.L1Data.Durl {
-			header := make(http.Header)
-			referer := fmt.Sprintf("https://api.bilibili.com/x/web-interface/view?aid=%d", aid)
-			for i := 1; i <= int(one.Page); i++ {
-				referer += fmt.Sprintf("&p=%d", i)
-			}
-			header.Set("User-Agent", userAgent)
-			header.Set("Accept", "*/*")
-			header.Set("Accept-Language", "en-US,en;q=0.5")
-			header.Set("Accept-Encoding", "gzip, deflate, br")
-			header.Set("Referer", referer)
-			header.Set("Origin", "https://www.bilibili.com")
-			header.Set("Connection", "keep-alive")
-
-			info.PartList = append(info.PartList, VideoPart{
-				Name:           fmt.Sprintf("%d_%d.%s", one.Page, two.Order, GetFormatForExt(one.L1Data.Format)),
-				FileExtWithDot: "." + GetFormatForExt(one.L1Data.Format),
-				DownloadUrl:    two.URL,
-				Header:         header,
-				HasSize:        true,
-				SizeValue:      two.Size,
-			})
-			totalLength += two.Size
+		if play.Code != 0 {
+			return info, apiCodeError("获取视频播放地址失败", play.Code, play.Message)
+		}
+		referer := fmt.Sprintf("https://api.bilibili.com/x/web-interface/view?aid=%d", aid)
+		for idx := 1; idx <= int(i.Page); idx++ {
+			referer += fmt.Sprintf("&p=%d", idx)
+		}
+		group := strconv.FormatInt(i.Page, 10)
+		partList, err := this.buildPlayPartList(group, referer, play.Data)
+		if err != nil {
+			return info, err
 		}
+		info.PartList = append(info.PartList, partList...)
+		info.PageList = append(info.PageList, newPageInfo(int(i.Page), group, i.Part, play.Data))
+		info.SidecarList = append(info.SidecarList, this.getSubtitleSidecarList(aid, i.Cid, group)...)
+		info.SidecarList = append(info.SidecarList, this.getDanmakuSidecarList(i.Cid, group)...)
+	}
+	if len(info.PageList) == 0 && len(tmp.Data.Pages) > 0 {
+		return info, newError("获取视频信息失败", nil, errors.New("没有选中任何分P"))
 	}
 	if len(info.PartList) == 0 {
-		resp.ErrMsg = "获取视频信息失败"
-		return resp
+		return info, newError("获取视频信息失败", ErrVideoNotFound, nil)
 	}
+	info.SidecarList = append(info.SidecarList, this.getMetadataSidecarList(viewContents, info.PageList)...)
 
 	info.Name = fmt.Sprintf("%d_%s", aid, title)
-
-	return this.DownloadVideo(info)
+	return info, nil
 }
 
 func (this *BilibiliDownloader) DownloadVideo(info VideoInfo) (resp GetVideoInfoList_Resp) {
+	outName, err := this.downloadVideo(info)
+	if err != nil {
+		resp.ErrMsg = err.Error()
+		return resp
+	}
+	resp.OutName = outName
+	return resp
+}
+
+func (this *BilibiliDownloader) downloadVideo(info VideoInfo) (outName string, err error) {
 	if len(info.PartList) > 1 {
-		err := os.MkdirAll(filepath.Join(this.req.SaveDir, info.Name), 0777)
+		err = os.MkdirAll(filepath.Join(this.req.SaveDir, info.Name), 0777)
 		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+			return "", wrapError("DownloadVideo", err)
 		}
 	}
 	for idx, one := range info.PartList {
-		if one.HasSize {
+		if one.HasSize || one.Hls != nil || this.isGroupMerged(info, one.Group) {
 			continue
 		}
-		httpReq, err := http.NewRequest(http.MethodGet, one.DownloadUrl, nil)
-		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
-		}
-		httpResp, err := http.DefaultClient.Do(httpReq)
-		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+		urlList := append([]string{one.DownloadUrl}, one.BackupUrlList...)
+		for urlIdx, urlStr := range urlList {
+			if urlIdx > 0 {
+				this.fnMessage(fmt.Sprintf("%v, 使用备用地址 %d/%d", err, urlIdx, len(urlList)-1))
+			}
+			one.SizeValue, err = this.getPartSize(urlStr, one.Header)
+			if err == nil {
+				// 之后直接从能访问的地址下载
+				one.DownloadUrl, one.BackupUrlList = urlStr, urlList[urlIdx+1:]
+				break
+			}
+			if this.isCancel() {
+				break
+			}
 		}
-		httpResp.Body.Close()
-		i, err := strconv.ParseInt(httpResp.Header.Get("Content-Length"), 10, 64)
 		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+			return "", err
 		}
 		one.HasSize = true
-		one.SizeValue = i
 		info.PartList[idx] = one
 	}
 	totalLength := info.GetTotalLength()
 
 	var curLength int64
 	for _, one := range info.PartList {
-		outName := filepath.Join(this.req.SaveDir, info.Name, one.Name)
-		if len(info.PartList) == 1 {
-			outName = filepath.Join(this.req.SaveDir, info.Name+one.FileExtWithDot)
+		if this.isGroupMerged(info, one.Group) {
+			curLength += one.SizeValue
+			continue
+		}
+		if one.Hls != nil {
+			err = this.downloadHlsPart(one, this.getPartOutName(info, one))
+		} else {
+			err = this.downloadVideoPartWithBackup(one, this.getPartOutName(info, one), curLength, totalLength)
 		}
-		err := this.DownloadVideoPart(one, outName, curLength, totalLength)
 		if err != nil {
-			resp.ErrMsg = err.Error()
-			return resp
+			return "", wrapError("下载失败", err)
 		}
 		curLength += one.SizeValue
 	}
-	resp.OutName = info.Name
-	return resp
+	outName = info.Name
+	if this.needMerge(info) {
+		outName, err = this.mergeGroups(info)
+		if err != nil {
+			return "", wrapError("合并文件失败", err)
+		}
+	}
+	this.downloadSidecars(info)
+	return outName, nil
+}
+
+func (this *BilibiliDownloader) getPartSize(urlStr string, header http.Header) (size int64, err error) {
+	httpReq, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return 0, wrapError("DownloadVideo", err)
+	}
+	httpReq = httpReq.WithContext(this.ctx)
+	for k, vList := range header {
+		httpReq.Header[k] = vList
+	}
+	httpResp, err := this.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, wrapError("获取文件大小失败", err)
+	}
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return 0, newError("获取文件大小失败", nil, fmt.Errorf("错误码： %d", httpResp.StatusCode))
+	}
+	size, err = strconv.ParseInt(httpResp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return 0, wrapError("获取文件大小失败", err)
+	}
+	return size, nil
+}
+
+func (this *BilibiliDownloader) getPartOutName(info VideoInfo, part VideoPart) string {
+	if len(info.PartList) == 1 {
+		return filepath.Join(this.req.SaveDir, info.Name+part.FileExtWithDot)
+	}
+	return filepath.Join(this.req.SaveDir, info.Name, part.Name)
 }
 
 func (this *BilibiliDownloader) defaultFetcher(url string) (content []byte, err error) {
@@ -359,35 +561,49 @@ func (this *BilibiliDownloader) defaultFetcher(url string) (content []byte, err
 	}
 	request.Header.Add("User-Agent", "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:60.0) Gecko/20100101 Firefox/60.0")
 	request = request.WithContext(this.ctx)
-	resp, err := http.DefaultClient.Do(request)
+	resp, err := this.httpClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, httpStatusError("defaultFetcher", resp.StatusCode)
+	}
 	return ioutil.ReadAll(resp.Body)
 }
 
-func (this *BilibiliDownloader) RunDownload() {
-	defer func() {
-		gRunningThreadCountLocker.Lock()
-		defer gRunningThreadCountLocker.Unlock()
+// RunDownload 同步的解析并下载, 过程中的事件发送到 sink
+func (this *BilibiliDownloader) RunDownload() (resp GetVideoInfoList_Resp) {
+	result, err := this.Run()
+	if err != nil {
+		resp.ErrMsg = err.Error()
+		return resp
+	}
+	resp.OutName = result.OutName
+	return resp
+}
 
-		gRunningThreadCount--
-		if gRunningThreadCount == 0 {
-			FnUpdateRunning(false)
+// Run 同 RunDownload, 失败时返回error, 被 Stop 中断时返回的error满足 errors.Is(err, ErrCanceled).
+// 直播录制被 Stop 时是正常结束, 返回最后一个录制的文件
+func (this *BilibiliDownloader) Run() (result Result, err error) {
+	this.emit(Event{Type: EventStarted, Url: this.req.Url})
+	this.fnMessage("开始解析视频信息")
+	result, err = this.download(this.req.Url)
+	liveStopped := err == nil && result.Info.isLive() && result.OutName != ""
+	if this.isCancel() && liveStopped == false {
+		this.emit(Event{Type: EventCanceled})
+		if errors.Is(err, ErrCanceled) == false {
+			err = newError("", ErrCanceled, this.ctx.Err())
 		}
-	}()
-	FnMessage("开始解析视频信息")
-	resp := this.GetVideoInfoListV2(this.req.Url)
-	if this.isCancel() {
-		return
+		return result, err
 	}
-	if resp.ErrMsg != "" {
-		FnError(resp.ErrMsg)
-		return
+	if err != nil {
+		this.emit(Event{Type: EventError, Message: err.Error()})
+		return result, err
 	}
-	FnDownloadFinish(resp.OutName)
-	FnMessage("")
+	this.emit(Event{Type: EventFinished, OutName: result.OutName})
+	this.fnMessage("")
+	return result, nil
 }
 
 type GetVideoInfoList_Resp struct {
@@ -411,45 +627,35 @@ func (this *BilibiliDownloader) sleepDur(duration time.Duration) {
 	}
 }
 
-func (this *BilibiliDownloader) getVideoListDouYin(vid string) (resp GetVideoInfoList_Resp) {
-	content, err := this.defaultFetcher(`https://www.iesdouyin.com/web/api/v2/aweme/iteminfo/?item_ids=` + vid)
+func (this *BilibiliDownloader) getVideoListDouYin(vid string) (info VideoInfo, err error) {
+	content, err := this.defaultFetcher(fmt.Sprintf(_douyinItemInfoUrlTemp, gDouyinApiHost, vid))
 	if err != nil {
-		resp.ErrMsg = err.Error()
-		return resp
+		return info, wrapError("getVideoListDouYin", err)
 	}
 	var tmp struct {
-		ItemList []struct {
-			Desc  string `json:"desc"`
-			Video struct {
-				PlayAddr struct {
-					URI     string   `json:"uri"`
-					URLList []string `json:"url_list"`
-				} `json:"play_addr"`
-				Vid string `json:"vid"`
-			} `json:"video"`
-		} `json:"item_list"`
-		StatusCode int `json:"status_code"`
+		ItemList   []douyinAweme `json:"item_list"`
+		StatusCode int           `json:"status_code"`
 	}
 	err = json.Unmarshal(content, &tmp)
 	if err != nil {
-		resp.ErrMsg = err.Error()
-		return resp
+		return info, wrapError("getVideoListDouYin_2", err)
 	}
 	var urlStr string
 	if len(tmp.ItemList) > 0 && len(tmp.ItemList[0].Video.PlayAddr.URLList) > 0 {
 		urlStr = tmp.ItemList[0].Video.PlayAddr.URLList[0]
 		urlStr = strings.Replace(urlStr, "playwm", "play", 1)
 	} else {
-		resp.ErrMsg = "无法解析视频"
-		return resp
+		return info, newError("无法解析视频", ErrVideoNotFound, nil)
 	}
-	title := TitleEdit(tmp.ItemList[0].Desc)
+	// 文件名以作品id开头, 同一个作品每次下载的文件名都相同
+	name := getDouyinName(vid, tmp.ItemList[0].Desc)
 
-	info := VideoInfo{
-		Name: title,
+	info = VideoInfo{
+		Name:  name,
+		Title: tmp.ItemList[0].Desc,
 		PartList: []VideoPart{
 			{
-				Name:           title,
+				Name:           name,
 				FileExtWithDot: ".mp4",
 				DownloadUrl:    urlStr,
 				Header: map[string][]string{
@@ -459,7 +665,7 @@ func (this *BilibiliDownloader) getVideoListDouYin(vid string) (resp GetVideoInf
 			},
 		},
 	}
-	return this.DownloadVideo(info)
+	return info, nil
 }
 
 const userAgent = "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:60.0) Gecko/20100101 Firefox/60.0"
@@ -0,0 +1,92 @@
+package bilibili
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+var (
+	ErrUnsupportedURL = errors.New("您输入的网址无法解析")
+	ErrVideoNotFound  = errors.New("视频不存在")
+	ErrNeedLogin      = errors.New("需要登录或者大会员")
+	ErrRegionBlocked  = errors.New("所在地区不可观看")
+	ErrCanceled       = errors.New("下载已取消")
+	ErrRateLimited    = errors.New("请求太频繁, 被风控拦截")
+)
+
+// DownloadError 同时携带错误分类(Kind)和原始错误(Err),
+// errors.Is(err, ErrVideoNotFound) 判断分类, errors.Unwrap 取得原始错误
+type DownloadError struct {
+	Op   string // 出错的位置, 例如 getVideoInfoList_ByAidV2
+	Kind error  // ErrUnsupportedURL 等, 可能为nil
+	Err  error  // 原始错误, 可能为nil
+}
+
+func (this *DownloadError) Error() string {
+	msg := this.Op
+	for _, one := range []error{this.Kind, this.Err} {
+		if one == nil {
+			continue
+		}
+		if msg != "" {
+			msg += ": "
+		}
+		msg += one.Error()
+	}
+	return msg
+}
+
+func (this *DownloadError) Unwrap() error {
+	return this.Err
+}
+
+func (this *DownloadError) Is(target error) bool {
+	return this.Kind != nil && this.Kind == target
+}
+
+func newError(op string, kind error, err error) error {
+	if errors.Is(err, context.Canceled) {
+		kind = ErrCanceled
+	}
+	return &DownloadError{Op: op, Kind: kind, Err: err}
+}
+
+// wrapError 为原始错误加上出错位置, err为nil时返回nil
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return newError(op, nil, err)
+}
+
+// apiCodeError 把b站接口返回的错误码转换为对应的分类
+func apiCodeError(op string, code int, message string) error {
+	var kind error
+	switch code {
+	case -404, 62002, 62004, 62012:
+		kind = ErrVideoNotFound
+	case -101, -403, 6001, 6002:
+		kind = ErrNeedLogin
+	case -10403, 6002003:
+		kind = ErrRegionBlocked
+	}
+	return newError(op, kind, errors.New(strconv.Itoa(code)+" "+message))
+}
+
+// httpStatusError 接口返回的http状态码不是2xx时, 按状态码分类
+func httpStatusError(op string, statusCode int) error {
+	var kind error
+	switch statusCode {
+	case http.StatusNotFound, http.StatusGone:
+		kind = ErrVideoNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		kind = ErrNeedLogin
+	case http.StatusPreconditionFailed, http.StatusTooManyRequests: // b站风控返回412
+		kind = ErrRateLimited
+	case http.StatusUnavailableForLegalReasons:
+		kind = ErrRegionBlocked
+	}
+	return newError(op, kind, errors.New("HTTP "+strconv.Itoa(statusCode)+" "+http.StatusText(statusCode)))
+}
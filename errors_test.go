@@ -0,0 +1,124 @@
+package bilibili
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var gTestErrKindList = []error{ErrUnsupportedURL, ErrVideoNotFound, ErrNeedLogin, ErrRegionBlocked, ErrCanceled, ErrRateLimited}
+
+// checkErrKind err 只属于 kind 这一个分类, kind 为nil时不属于任何分类
+func checkErrKind(t *testing.T, name string, err error, kind error) {
+	t.Helper()
+	for _, one := range gTestErrKindList {
+		if errors.Is(err, one) != (one == kind) {
+			t.Errorf("%s: errors.Is(%v, %v) = %v", name, err, one, one != kind)
+		}
+	}
+}
+
+func TestApiCodeError(t *testing.T) {
+	tests := []struct {
+		code int
+		kind error
+	}{
+		{-404, ErrVideoNotFound},
+		{62002, ErrVideoNotFound},
+		{62004, ErrVideoNotFound},
+		{62012, ErrVideoNotFound},
+		{-101, ErrNeedLogin},
+		{-403, ErrNeedLogin},
+		{6001, ErrNeedLogin},
+		{6002, ErrNeedLogin},
+		{-10403, ErrRegionBlocked},
+		{6002003, ErrRegionBlocked},
+		{-400, nil},
+		{-412, nil},
+	}
+	for _, tt := range tests {
+		err := apiCodeError("getVideoInfo", tt.code, "错误信息")
+		name := strconv.Itoa(tt.code)
+		checkErrKind(t, name, err, tt.kind)
+		if strings.HasPrefix(err.Error(), "getVideoInfo") == false || strings.HasSuffix(err.Error(), name+" 错误信息") == false {
+			t.Errorf("%s: %s", name, err)
+		}
+		if errors.Unwrap(err).Error() != name+" 错误信息" {
+			t.Errorf("%s: unwrap %v", name, errors.Unwrap(err))
+		}
+	}
+}
+
+func TestDownloadError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind error
+		msg  string
+	}{
+		{newError("op", ErrVideoNotFound, nil), ErrVideoNotFound, "op: 视频不存在"},
+		{newError("", ErrUnsupportedURL, nil), ErrUnsupportedURL, "您输入的网址无法解析"},
+		{newError("op", nil, errors.New("原始错误")), nil, "op: 原始错误"},
+		{newError("op", ErrNeedLogin, errors.New("-101 账号未登录")), ErrNeedLogin, "op: 需要登录或者大会员: -101 账号未登录"},
+		// context.Canceled 总是作为 ErrCanceled
+		{newError("op", nil, context.Canceled), ErrCanceled, "op: 下载已取消: context canceled"},
+		{wrapError("op", context.Canceled), ErrCanceled, "op: 下载已取消: context canceled"},
+		{wrapError("outer", newError("inner", ErrRegionBlocked, nil)), ErrRegionBlocked, "outer: inner: 所在地区不可观看"},
+	}
+	for _, tt := range tests {
+		checkErrKind(t, tt.msg, tt.err, tt.kind)
+		if tt.err.Error() != tt.msg {
+			t.Errorf("got %q, want %q", tt.err.Error(), tt.msg)
+		}
+	}
+	if wrapError("op", nil) != nil {
+		t.Error("wrapError nil")
+	}
+}
+
+func TestDefaultFetcherStatus(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			code = http.StatusNotFound
+		}
+		w.WriteHeader(code)
+		w.Write([]byte(`<html>error page</html>`))
+	})
+	tests := []struct {
+		status int
+		kind   error
+	}{
+		{http.StatusNotFound, ErrVideoNotFound},
+		{http.StatusGone, ErrVideoNotFound},
+		{http.StatusUnauthorized, ErrNeedLogin},
+		{http.StatusForbidden, ErrNeedLogin},
+		{http.StatusPreconditionFailed, ErrRateLimited},
+		{http.StatusTooManyRequests, ErrRateLimited},
+		{http.StatusUnavailableForLegalReasons, ErrRegionBlocked},
+		{http.StatusInternalServerError, nil},
+		{http.StatusBadGateway, nil},
+	}
+	d := newBilibiliDownloader(BeginDownload_Req{})
+	for _, tt := range tests {
+		contents, err := d.defaultFetcher(gBilibiliApiHost + "/" + strconv.Itoa(tt.status))
+		name := strconv.Itoa(tt.status)
+		if err == nil || contents != nil {
+			t.Errorf("%s: %q %v", name, contents, err)
+			continue
+		}
+		checkErrKind(t, name, err, tt.kind)
+		if strings.Contains(err.Error(), "HTTP "+name) == false {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+	contents, err := d.defaultFetcher(gBilibiliApiHost + "/204")
+	if err != nil || len(contents) != 0 {
+		t.Fatal(contents, err)
+	}
+	// 通过 getVideoInfo 返回时也保留分类
+	_, err = d.getVideoInfo("BV1xx411c7mD")
+	checkErrKind(t, "getVideoInfo", err, ErrVideoNotFound)
+}
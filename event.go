@@ -0,0 +1,69 @@
+package bilibili
+
+const (
+	EventQueued       = "queued"   // 任务在 Manager 中排队
+	EventStarted      = "started"  // 开始解析
+	EventResolved     = "resolved" // 解析完成, Info 有效
+	EventMessage      = "message"
+	EventPartProgress = "part_progress"
+	EventSpeed        = "speed"
+	EventError        = "error"
+	EventFinished     = "finished"
+	EventCanceled     = "canceled"
+	EventLiveOnline   = "live_online"  // LiveWatcher 检测到开播, TaskId 是录制任务
+	EventLiveOffline  = "live_offline" // LiveWatcher 检测到下播
+)
+
+type Event struct {
+	Type          string
+	TaskId        int64      // 只有 Manager 中的任务才有
+	RoomId        int64      // LiveWatcher 的事件
+	Url           string     // EventStarted
+	Info          *VideoInfo // EventResolved
+	Message       string     // EventMessage, EventError
+	PartName      string     // EventPartProgress
+	Progress      float64    // EventPartProgress, 所有分段的总进度 0~1
+	Speed         string     // EventSpeed
+	IsMultiThread bool       // EventSpeed
+	OutName       string     // EventFinished
+}
+
+type EventSink interface {
+	OnEvent(ev Event)
+}
+
+type EventSinkFunc func(ev Event)
+
+func (this EventSinkFunc) OnEvent(ev Event) {
+	this(ev)
+}
+
+// ChanEventSink 把事件写入channel, channel满时会阻塞下载
+type ChanEventSink chan Event
+
+func (this ChanEventSink) OnEvent(ev Event) {
+	this <- ev
+}
+
+// gDiscardEventSink 丢弃所有事件
+var gDiscardEventSink = EventSinkFunc(func(ev Event) {})
+
+// gPrintFnSEventSink 把事件转换为 InitPrintFnS 设置的全局回调
+var gPrintFnSEventSink = EventSinkFunc(func(ev Event) {
+	switch ev.Type {
+	case EventMessage:
+		FnMessage(ev.Message)
+	case EventPartProgress:
+		FnUpdateProgress(ev.Progress)
+	case EventSpeed:
+		vt := "(1)"
+		if ev.IsMultiThread {
+			vt = "(n)"
+		}
+		FnMessage("下载速度" + vt + ": " + ev.Speed)
+	case EventError:
+		FnError(ev.Message)
+	case EventFinished:
+		FnDownloadFinish(ev.OutName)
+	}
+})
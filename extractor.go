@@ -0,0 +1,107 @@
+package bilibili
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Extractor 负责把一个网址解析为 VideoInfo, 下载由 DownloadVideo 统一完成.
+// 第三方网站可以通过 RegisterExtractor 注册自己的 Extractor
+type Extractor interface {
+	Match(url string) bool
+	Extract(ctx context.Context, url string) (VideoInfo, error)
+}
+
+var gExtractorList []Extractor
+var gExtractorLocker sync.Mutex
+
+// RegisterExtractor 注册一个 Extractor, 解析时按注册顺序使用第一个 Match 的 Extractor
+func RegisterExtractor(e Extractor) {
+	gExtractorLocker.Lock()
+	defer gExtractorLocker.Unlock()
+
+	gExtractorList = append(gExtractorList, e)
+}
+
+func findExtractor(url string) Extractor {
+	gExtractorLocker.Lock()
+	defer gExtractorLocker.Unlock()
+
+	for _, e := range gExtractorList {
+		if e.Match(url) {
+			return e
+		}
+	}
+	return nil
+}
+
+func init() {
+	RegisterExtractor(hlsExtractor{})
+	RegisterExtractor(collectionExtractor{})
+	RegisterExtractor(spaceExtractor{})
+	RegisterExtractor(bilibiliExtractor{})
+	RegisterExtractor(douyinListExtractor{})
+	RegisterExtractor(douyinExtractor{})
+	RegisterExtractor(liveExtractor{})
+}
+
+type downloaderCtxKey struct{}
+
+// getDownloaderFromCtx 内置的 Extractor 需要使用 BilibiliDownloader 上的请求参数和状态,
+// ctx 不是由 BilibiliDownloader 创建的时候使用一个临时的 BilibiliDownloader
+func getDownloaderFromCtx(ctx context.Context) *BilibiliDownloader {
+	if v, ok := ctx.Value(downloaderCtxKey{}).(*BilibiliDownloader); ok {
+		return v
+	}
+	tmp := newBilibiliDownloader(BeginDownload_Req{})
+	tmp.ctx = context.WithValue(ctx, downloaderCtxKey{}, tmp)
+	return tmp
+}
+
+var (
+	gBangumiRegexp = regexp.MustCompile(`(?:bangumi/(?:play|media)/|^)(ep|ss|md)(\d+)`)
+	gBvRegexp      = regexp.MustCompile(`(?:^|[^0-9A-Za-z])(BV[0-9A-Za-z]{10})`)
+	gAvRegexp      = regexp.MustCompile(`(?:^|[^0-9A-Za-z])(av\d+)`)
+	gDouyinRegexp  = regexp.MustCompile(`(?:www\.douyin\.com/video/|www\.iesdouyin\.com/share/video/|douyin\.com/\S*[?&]modal_id=)(\d+)`)
+)
+
+type bilibiliExtractor struct{}
+
+func (bilibiliExtractor) Match(url string) bool {
+	return gBangumiRegexp.MatchString(url) || gBvRegexp.MatchString(url) || gAvRegexp.MatchString(url)
+}
+
+func (bilibiliExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	this := getDownloaderFromCtx(ctx)
+	selector, err := this.getPageSelector(url)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if params := gBangumiRegexp.FindStringSubmatch(url); params != nil {
+		id, _ := strconv.ParseInt(params[2], 10, 64)
+		return this.getVideoInfoList_Bangumi(params[1], id, selector)
+	} else if params = gBvRegexp.FindStringSubmatch(url); params != nil {
+		return this.getVideoInfoList_ByAidV2(Bv2av(params[1]), selector)
+	} else if params = gAvRegexp.FindStringSubmatch(url); params != nil {
+		aid, _ := strconv.ParseInt(strings.TrimPrefix(params[1], "av"), 10, 64)
+		return this.getVideoInfoList_ByAidV2(aid, selector)
+	}
+	return VideoInfo{}, ErrUnsupportedURL
+}
+
+type douyinExtractor struct{}
+
+func (douyinExtractor) Match(url string) bool {
+	return gDouyinRegexp.MatchString(url)
+}
+
+func (douyinExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	params := gDouyinRegexp.FindStringSubmatch(url)
+	if params == nil {
+		return VideoInfo{}, ErrUnsupportedURL
+	}
+	return getDownloaderFromCtx(ctx).getVideoListDouYin(params[1])
+}
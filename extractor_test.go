@@ -0,0 +1,101 @@
+package bilibili
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestFindExtractor(t *testing.T) {
+	tests := []struct {
+		url  string
+		want Extractor
+		re   *regexp.Regexp // 用来取出id的正则, 为空时不检查
+		id   string         // 所有非空分组用 , 连接
+	}{
+		{"https://www.bilibili.com/bangumi/play/ep123456", bilibiliExtractor{}, gBangumiRegexp, "ep,123456"},
+		{"https://www.bilibili.com/bangumi/play/ss2345?spm_id_from=333", bilibiliExtractor{}, gBangumiRegexp, "ss,2345"},
+		{"https://www.bilibili.com/bangumi/media/md28229", bilibiliExtractor{}, gBangumiRegexp, "md,28229"},
+		{"ep123456", bilibiliExtractor{}, gBangumiRegexp, "ep,123456"},
+		{"https://www.bilibili.com/video/BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://www.bilibili.com/video/BV1xx411c7mD/?p=2&spm_id_from=333", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://m.bilibili.com/video/BV1xx411c7mD?share_source=copy", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"【测试视频】 BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://www.bilibili.com/list/ml123?bvid=BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://www.bilibili.com/blackboard/html5player.html?aid=170001&bvid=BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://www.bilibili.com/video/av170001", bilibiliExtractor{}, gAvRegexp, "av170001"},
+		{"av170001", bilibiliExtractor{}, gAvRegexp, "av170001"},
+		{"【测试视频】av170001", bilibiliExtractor{}, gAvRegexp, "av170001"},
+		{"https://space.bilibili.com/2", spaceExtractor{}, gSpaceRegexp, "2"},
+		{"https://space.bilibili.com/2/video?tid=0", spaceExtractor{}, gSpaceRegexp, "2"},
+		{"https://space.bilibili.com/2/favlist?fid=1052622027&ftype=create", collectionExtractor{}, gFavRegexp, "1052622027"},
+		{"https://www.bilibili.com/medialist/detail/ml1052622027", collectionExtractor{}, gFavRegexp, "1052622027"},
+		{"https://www.bilibili.com/watchlater/#/list", collectionExtractor{}, gWatchLaterRegexp, ""},
+		{"https://www.bilibili.com/list/watchlater", collectionExtractor{}, gWatchLaterRegexp, ""},
+		{"https://www.bilibili.com/list/watchlater?oid=170001&bvid=BV1xx411c7mD", bilibiliExtractor{}, gBvRegexp, "BV1xx411c7mD"},
+		{"https://space.bilibili.com/2/channel/collectiondetail?sid=123", collectionExtractor{}, gSeasonRegexp, "2,123"},
+		{"https://space.bilibili.com/2/lists/123?type=season", collectionExtractor{}, gSeasonRegexp, "2,123"},
+		{"https://space.bilibili.com/2/channel/seriesdetail?sid=456", collectionExtractor{}, gSeriesRegexp, "2,456"},
+		{"https://space.bilibili.com/2/lists/456?type=series", collectionExtractor{}, gSeriesRegexp, "2,456"},
+		{"https://live.bilibili.com/21452505", liveExtractor{}, gLiveRegexp, "21452505"},
+		{"https://live.bilibili.com/h5/21452505?broadcast_type=0", liveExtractor{}, gLiveRegexp, "21452505"},
+		{"https://example.com/video/master.m3u8?token=1", hlsExtractor{}, nil, ""},
+		{"https://cn-gotcha.bilivideo.com/live-bvc/index.M3U8", hlsExtractor{}, nil, ""},
+		{"https://www.douyin.com/video/7312345678901234567", douyinExtractor{}, gDouyinRegexp, "7312345678901234567"},
+		{"https://www.iesdouyin.com/share/video/7312345678901234567/?region=CN", douyinExtractor{}, gDouyinRegexp, "7312345678901234567"},
+		{"https://www.douyin.com/user/MS4wLjABAAAA?modal_id=7312345678901234567", douyinExtractor{}, gDouyinRegexp, "7312345678901234567"},
+		{"https://www.douyin.com/user/MS4wLjABAAAA-x_yz", douyinListExtractor{}, gDouyinUserRegexp, "MS4wLjABAAAA-x_yz"},
+		{"https://www.douyin.com/user/MS4wLjABAAAABV1xx411c7mDav123", douyinListExtractor{}, gDouyinUserRegexp, "MS4wLjABAAAABV1xx411c7mDav123"},
+		{"https://www.iesdouyin.com/share/user/123?u_code=1&sec_uid=MS4wLjABAAAA&from=web", douyinListExtractor{}, gDouyinUserRegexp, "MS4wLjABAAAA"},
+		{"https://www.douyin.com/collection/7001234567890", douyinListExtractor{}, gDouyinMixRegexp, "7001234567890"},
+		{"https://www.iesdouyin.com/share/mix/detail/7001234567890/", douyinListExtractor{}, gDouyinMixRegexp, "7001234567890"},
+		{"https://b23.tv/abcdefg", nil, nil, ""},
+		{"https://www.bilibili.com/", nil, nil, ""},
+		{"https://www.bilibili.com/video/javascript123", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		got := findExtractor(tt.url)
+		if reflect.TypeOf(got) != reflect.TypeOf(tt.want) {
+			t.Errorf("%s: got %T, want %T", tt.url, got, tt.want)
+			continue
+		}
+		if tt.re == nil {
+			continue
+		}
+		params := tt.re.FindStringSubmatch(tt.url)
+		if params == nil {
+			t.Errorf("%s: %s not match", tt.url, tt.re)
+			continue
+		}
+		var idList []string
+		for _, one := range params[1:] {
+			if one != "" {
+				idList = append(idList, one)
+			}
+		}
+		if id := strings.Join(idList, ","); id != tt.id {
+			t.Errorf("%s: got id %q, want %q", tt.url, id, tt.id)
+		}
+	}
+}
+
+func TestIsShortLink(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://b23.tv/abcdefg", true},
+		{"http://B23.TV/abcdefg?share=1", true},
+		{"https://bili2233.cn/abcdefg", true},
+		{"https://v.douyin.com/iRNBho6u/", true},
+		{"https://www.bilibili.com/video/BV1xx411c7mD", false},
+		{"https://b23.tv.example.com/abc", false},
+	}
+	for _, tt := range tests {
+		if got := isShortLink(tt.url); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
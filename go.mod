@@ -2,6 +2,11 @@ module github.com/orestonce/bilibili
 
 go 1.19
 
-require github.com/gonutz/wui v1.0.0
+require (
+	github.com/andybalholm/brotli v1.0.6
+	github.com/gonutz/wui v1.0.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+)
 
 require github.com/gonutz/w32 v1.0.0 // indirect
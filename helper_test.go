@@ -0,0 +1,33 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestMain 测试中不使用扫码登录保存的真实cookie
+func TestMain(m *testing.M) {
+	gSessionFileFn = func() string { return "" }
+	os.Exit(m.Run())
+}
+
+// newTestServer 启动本地服务并把所有接口域名替换成它, 测试结束后还原.
+// wbi 签名需要的 nav 接口由这里返回固定的密钥
+func newTestServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/x/web-interface/nav" {
+			w.Write([]byte(`{"code":-101,"data":{"wbi_img":{"img_url":"https://i0.hdslb.com/bfs/wbi/7cd084941338484aae1ad9425b84077c.png","sub_url":"https://i0.hdslb.com/bfs/wbi/4932caff0ff746eab6f01bf08b70ac45.png"}}}`))
+			return
+		}
+		handler(w, r)
+	}))
+	oldBilibili, oldLive, oldPassport, oldDouyin := gBilibiliApiHost, gLiveApiHost, gPassportHost, gDouyinApiHost
+	gBilibiliApiHost, gLiveApiHost, gPassportHost, gDouyinApiHost = srv.URL, srv.URL, srv.URL, srv.URL
+	t.Cleanup(func() {
+		srv.Close()
+		gBilibiliApiHost, gLiveApiHost, gPassportHost, gDouyinApiHost = oldBilibili, oldLive, oldPassport, oldDouyin
+	})
+	return srv
+}
@@ -0,0 +1,659 @@
+package bilibili
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	_hlsDefaultThreads = 4
+	_hlsRetryCount     = 3
+	_hlsLiveIdleCount  = 10 // 直播连续这么多次刷新都没有新的分片时认为已经结束
+)
+
+// 直播m3u8刷新播放列表的最小间隔, 测试时可以缩短
+var gHlsLiveMinRefresh = time.Second
+
+// HlsOption m3u8下载的参数
+type HlsOption struct {
+	MaxHeight int // 有多个清晰度时选择不超过这个高度的最高码率, 0 表示最高
+	Threads   int // 同时下载的分片数量, 默认4
+}
+
+// HlsMedia 一个媒体播放列表, 所有分片按顺序拼接为一个文件
+type HlsMedia struct {
+	Url            string
+	InitUrl        string // EXT-X-MAP, fmp4分片的初始化段, 为空表示ts分片
+	SegmentList    []HlsSegment
+	IsLive         bool    // 没有 EXT-X-ENDLIST, 需要定时刷新播放列表
+	TargetDuration float64 // EXT-X-TARGETDURATION, 秒
+}
+
+type HlsSegment struct {
+	Url      string
+	Sequence int64 // 媒体序号, 直播刷新播放列表时用于去重
+	Duration float64
+	KeyUrl   string // AES-128 加密时的密钥地址, 为空表示不加密
+	Iv       []byte
+}
+
+type hlsVariant struct {
+	url       string
+	bandwidth int64
+	height    int
+}
+
+type hlsPlaylist struct {
+	variantList []hlsVariant // 不为空时是主播放列表
+	media       HlsMedia
+}
+
+// parseM3u8 解析主播放列表或媒体播放列表, 相对地址根据 baseUrl 转换为绝对地址
+func parseM3u8(data []byte, baseUrl string) (p hlsPlaylist, err error) {
+	base, err := url.Parse(baseUrl)
+	if err != nil {
+		return p, err
+	}
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	if scanner.Scan() == false || strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "#EXTM3U") == false {
+		return p, errors.New("不是m3u8文件")
+	}
+	p.media.Url = baseUrl
+	p.media.IsLive = true
+	var seq int64
+	var duration float64
+	var variant *hlsVariant
+	var keyUrl string
+	var keyIv []byte
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case line == "":
+		case tag == "#EXT-X-STREAM-INF":
+			attr := parseM3u8Attr(value)
+			variant = &hlsVariant{}
+			variant.bandwidth, _ = strconv.ParseInt(attr["BANDWIDTH"], 10, 64)
+			if _, h, ok := strings.Cut(attr["RESOLUTION"], "x"); ok {
+				variant.height, _ = strconv.Atoi(h)
+			}
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			seq, _ = strconv.ParseInt(value, 10, 64)
+		case tag == "#EXT-X-TARGETDURATION":
+			p.media.TargetDuration, _ = strconv.ParseFloat(value, 64)
+		case tag == "#EXTINF":
+			d, _, _ := strings.Cut(value, ",")
+			duration, _ = strconv.ParseFloat(d, 64)
+		case tag == "#EXT-X-KEY":
+			attr := parseM3u8Attr(value)
+			switch attr["METHOD"] {
+			case "NONE":
+				keyUrl, keyIv = "", nil
+			case "AES-128":
+				keyUrl = resolve(attr["URI"])
+				keyIv = nil
+				if iv := attr["IV"]; iv != "" {
+					iv = strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+					keyIv, err = hex.DecodeString(iv)
+					if err != nil || len(keyIv) != aes.BlockSize {
+						return p, errors.New("无效的IV: " + attr["IV"])
+					}
+				}
+			default:
+				return p, errors.New("不支持的加密方式: " + attr["METHOD"])
+			}
+		case tag == "#EXT-X-MAP":
+			p.media.InitUrl = resolve(parseM3u8Attr(value)["URI"])
+		case tag == "#EXT-X-BYTERANGE":
+			return p, errors.New("不支持 EXT-X-BYTERANGE")
+		case tag == "#EXT-X-ENDLIST":
+			p.media.IsLive = false
+		case strings.HasPrefix(line, "#"):
+		case variant != nil:
+			variant.url = resolve(line)
+			p.variantList = append(p.variantList, *variant)
+			variant = nil
+		default:
+			seg := HlsSegment{Url: resolve(line), Sequence: seq, Duration: duration, KeyUrl: keyUrl, Iv: keyIv}
+			if keyUrl != "" && keyIv == nil { // 没有IV时使用分片序号
+				seg.Iv = make([]byte, aes.BlockSize)
+				binary.BigEndian.PutUint64(seg.Iv[8:], uint64(seq))
+			}
+			p.media.SegmentList = append(p.media.SegmentList, seg)
+			seq++
+			duration = 0
+		}
+	}
+	return p, scanner.Err()
+}
+
+// parseM3u8Attr 解析 KEY=VALUE,KEY="VALUE" 格式的属性列表
+func parseM3u8Attr(value string) map[string]string {
+	m := map[string]string{}
+	for value != "" {
+		key, rest, ok := strings.Cut(value, "=")
+		if ok == false {
+			break
+		}
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			v = rest[1 : 1+end]
+			rest = strings.TrimPrefix(rest[1+end:], `"`)
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			v, rest, _ = strings.Cut(rest, ",")
+		}
+		m[strings.TrimSpace(key)] = v
+		value = rest
+	}
+	return m
+}
+
+// pickHlsVariant 不超过 maxHeight 的最高码率, 都超过时使用高度最小的
+func pickHlsVariant(list []hlsVariant, maxHeight int) hlsVariant {
+	sorted := append([]hlsVariant{}, list...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].bandwidth > sorted[j].bandwidth
+	})
+	if maxHeight <= 0 {
+		return sorted[0]
+	}
+	lowest := sorted[0]
+	for _, one := range sorted {
+		if one.height <= maxHeight {
+			return one
+		}
+		if one.height < lowest.height {
+			lowest = one
+		}
+	}
+	return lowest
+}
+
+type hlsExtractor struct{}
+
+func (hlsExtractor) Match(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && strings.HasSuffix(strings.ToLower(u.Path), ".m3u8")
+}
+
+func (hlsExtractor) Extract(ctx context.Context, urlStr string) (info VideoInfo, err error) {
+	this := getDownloaderFromCtx(ctx)
+	media, err := this.getHlsMedia(urlStr)
+	if err != nil {
+		return info, err
+	}
+	u, _ := url.Parse(urlStr)
+	name := TitleEdit(strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path)))
+	if dir := TitleEdit(path.Base(path.Dir(u.Path))); dir != "" {
+		name = dir + "_" + name
+	}
+	if media.IsLive { // 每次录制都保存为新的文件
+		name += "_" + time.Now().Format("20060102_150405")
+	}
+	ext := ".ts"
+	if media.InitUrl != "" {
+		ext = ".mp4"
+	}
+	info.Name = name
+	info.Title = name
+	info.PartList = []VideoPart{
+		{
+			Name:           name + ext,
+			FileExtWithDot: ext,
+			DownloadUrl:    media.Url,
+			Header:         http.Header{"User-Agent": {userAgent}},
+			Hls:            &media,
+		},
+	}
+	return info, nil
+}
+
+// getHlsMedia 主播放列表按 req.Hls.MaxHeight 选择一个清晰度, 再获取它的媒体播放列表
+func (this *BilibiliDownloader) getHlsMedia(urlStr string) (media HlsMedia, err error) {
+	header := http.Header{"User-Agent": {userAgent}}
+	for depth := 0; depth < 2; depth++ {
+		contents, err := this.fetchWithHeader(urlStr, header)
+		if err != nil {
+			return media, wrapError("getHlsMedia", err)
+		}
+		p, err := parseM3u8(contents, urlStr)
+		if err != nil {
+			return media, newError("getHlsMedia", ErrUnsupportedURL, err)
+		}
+		if len(p.variantList) == 0 {
+			if p.media.IsLive {
+				this.fnMessage("直播m3u8, 录制到直播结束或者停止下载")
+				return p.media, nil
+			}
+			if len(p.media.SegmentList) == 0 {
+				return media, newError("getHlsMedia", ErrVideoNotFound, nil)
+			}
+			this.fnMessage(fmt.Sprintf("m3u8分片数量: %d", len(p.media.SegmentList)))
+			return p.media, nil
+		}
+		variant := pickHlsVariant(p.variantList, this.req.Hls.MaxHeight)
+		this.fnMessage(fmt.Sprintf("选择清晰度: %dp %dkbps", variant.height, variant.bandwidth/1000))
+		urlStr = variant.url
+	}
+	return media, newError("getHlsMedia", ErrUnsupportedURL, errors.New("主播放列表嵌套"))
+}
+
+// downloadHlsPart 分片下载到 <输出文件>.segments 目录, 已下载的分片不会重复下载, 全部完成后按顺序拼接
+func (this *BilibiliDownloader) downloadHlsPart(part VideoPart, outputNameFullPath string) (err error) {
+	if _, err = os.Stat(outputNameFullPath); err == nil {
+		return nil
+	}
+	media := part.Hls
+	if media.IsLive {
+		return this.recordHlsLive(part, outputNameFullPath)
+	}
+	segDir := outputNameFullPath + ".segments"
+	err = os.MkdirAll(segDir, 0777)
+	if err != nil {
+		return err
+	}
+	var nameList []string
+	if media.InitUrl != "" {
+		nameList = append(nameList, filepath.Join(segDir, "init.mp4"))
+	}
+	for idx := range media.SegmentList {
+		nameList = append(nameList, filepath.Join(segDir, fmt.Sprintf("%05d.seg", idx)))
+	}
+
+	threads := this.req.Hls.Threads
+	if threads <= 0 {
+		threads = _hlsDefaultThreads
+	}
+	ctx, cancel := context.WithCancel(this.ctx)
+	defer cancel()
+	dl := &hlsDownloader{
+		downloader: this,
+		ctx:        ctx,
+		header:     part.Header,
+		keyMap:     map[string][]byte{},
+		total:      len(nameList),
+		partName:   part.Name,
+		ticker:     time.NewTicker(time.Millisecond * 100),
+	}
+	defer dl.ticker.Stop()
+	this.speedSetBegin()
+
+	idxCh := make(chan int)
+	var wg sync.WaitGroup
+	var firstErr error
+	var errLocker sync.Mutex
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxCh {
+				var seg HlsSegment
+				if media.InitUrl != "" {
+					if idx == 0 {
+						seg = HlsSegment{Url: media.InitUrl}
+					} else {
+						seg = media.SegmentList[idx-1]
+					}
+				} else {
+					seg = media.SegmentList[idx]
+				}
+				err := dl.downloadSegment(seg, nameList[idx])
+				if err != nil {
+					errLocker.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLocker.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+dispatch:
+	for idx := range nameList {
+		select {
+		case idxCh <- idx:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(idxCh)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if this.isCancel() {
+		return this.ctx.Err()
+	}
+
+	err = concatFiles(nameList, outputNameFullPath)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(segDir)
+}
+
+// recordHlsLive 定时刷新播放列表, 新的分片按顺序追加到输出文件, 直到 EXT-X-ENDLIST、长时间没有新的分片或者被停止.
+// 被停止时已经录制的部分正常保存
+func (this *BilibiliDownloader) recordHlsLive(part VideoPart, outputNameFullPath string) (err error) {
+	media := *part.Hls
+	dl := &hlsDownloader{
+		downloader: this,
+		ctx:        this.ctx,
+		header:     part.Header,
+		keyMap:     map[string][]byte{},
+		partName:   part.Name,
+		ticker:     time.NewTicker(time.Second),
+	}
+	defer dl.ticker.Stop()
+	tmpName := outputNameFullPath + ".downloading"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	var size int64
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil && size == 0 {
+			err = newError("录制直播m3u8失败", ErrVideoNotFound, errors.New("没有录制到任何分片"))
+		}
+		if err != nil {
+			os.Remove(tmpName)
+			return
+		}
+		err = os.Rename(tmpName, outputNameFullPath)
+	}()
+	this.fnMessage("录制到文件: " + filepath.Base(outputNameFullPath))
+	this.speedSetBegin()
+	if media.InitUrl != "" {
+		data, err := dl.fetchWithRetry(HlsSegment{Url: media.InitUrl})
+		if err != nil {
+			return err
+		}
+		_, err = file.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+	var lastSeq int64 = -1
+	var idleCount int
+	for {
+		var newCount int
+		for _, seg := range media.SegmentList {
+			if seg.Sequence <= lastSeq {
+				continue
+			}
+			if lastSeq >= 0 && seg.Sequence > lastSeq+1 {
+				this.fnMessage(fmt.Sprintf("刷新太慢, 丢失了 %d 个分片", seg.Sequence-lastSeq-1))
+			}
+			data, err := dl.fetchWithRetry(seg)
+			if this.isCancel() {
+				return nil
+			}
+			if err != nil {
+				this.fnMessage(err.Error())
+			} else {
+				_, err = file.Write(data)
+				if err != nil {
+					return err
+				}
+				size += int64(len(data))
+				dl.onSegmentDone(len(data))
+			}
+			lastSeq = seg.Sequence
+			newCount++
+		}
+		if media.IsLive == false {
+			this.fnMessage("直播已结束")
+			return nil
+		}
+		idleCount++
+		if newCount > 0 {
+			idleCount = 0
+		}
+		if idleCount >= _hlsLiveIdleCount {
+			this.fnMessage("长时间没有新的分片, 认为直播已结束")
+			return nil
+		}
+		// 播放列表没有变化时间隔减半
+		interval := time.Duration(media.TargetDuration * float64(time.Second))
+		if newCount == 0 {
+			interval /= 2
+		}
+		if interval < gHlsLiveMinRefresh {
+			interval = gHlsLiveMinRefresh
+		}
+		select {
+		case <-this.ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		latest, err := this.refreshHlsLive(media.Url, part.Header)
+		if this.isCancel() {
+			return nil
+		}
+		if err != nil {
+			this.fnMessage("刷新播放列表失败: " + err.Error())
+			media.SegmentList = nil
+			continue
+		}
+		if n := len(latest.SegmentList); n > 0 && latest.SegmentList[n-1].Sequence < lastSeq {
+			this.fnMessage("媒体序号重置, 重新开始计数")
+			lastSeq = latest.SegmentList[0].Sequence - 1
+		}
+		latest.InitUrl = media.InitUrl
+		media = latest
+	}
+}
+
+func (this *BilibiliDownloader) refreshHlsLive(urlStr string, header http.Header) (media HlsMedia, err error) {
+	contents, err := this.fetchWithHeader(urlStr, header)
+	if err != nil {
+		return media, err
+	}
+	p, err := parseM3u8(contents, urlStr)
+	if err != nil {
+		return media, err
+	}
+	if len(p.variantList) > 0 {
+		return media, errors.New("直播的播放列表变成了主播放列表")
+	}
+	return p.media, nil
+}
+
+type hlsDownloader struct {
+	downloader *BilibiliDownloader
+	ctx        context.Context
+	header     http.Header
+	keyLocker  sync.Mutex
+	keyMap     map[string][]byte
+
+	doneLocker sync.Mutex
+	done       int
+	total      int
+	partName   string
+	ticker     *time.Ticker
+}
+
+// downloadSegment 失败时重试, 已存在的分片直接跳过
+func (this *hlsDownloader) downloadSegment(seg HlsSegment, name string) (err error) {
+	if _, err = os.Stat(name); err == nil {
+		this.onSegmentDone(0)
+		return nil
+	}
+	data, err := this.fetchWithRetry(seg)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(name, data)
+	if err != nil {
+		return err
+	}
+	this.onSegmentDone(len(data))
+	return nil
+}
+
+// fetchWithRetry 下载并解密一个分片, 失败时重试
+func (this *hlsDownloader) fetchWithRetry(seg HlsSegment) (data []byte, err error) {
+	for retry := 0; retry < _hlsRetryCount; retry++ {
+		if retry > 0 {
+			select {
+			case <-this.ctx.Done():
+				return nil, this.ctx.Err()
+			case <-time.After(time.Second * time.Duration(retry)):
+			}
+		}
+		data, err = this.fetch(seg.Url)
+		if err == nil && seg.KeyUrl != "" {
+			data, err = this.decrypt(seg, data)
+		}
+		if err == nil || this.ctx.Err() != nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("下载分片失败 %s: %w", path.Base(seg.Url), err)
+	}
+	return data, nil
+}
+
+func (this *hlsDownloader) fetch(urlStr string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(this.ctx)
+	for k, vList := range this.header {
+		request.Header[k] = vList
+	}
+	resp, err := this.downloader.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("错误码： %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// decrypt AES-128-CBC, PKCS7填充, 同一个密钥只下载一次
+func (this *hlsDownloader) decrypt(seg HlsSegment, data []byte) ([]byte, error) {
+	this.keyLocker.Lock()
+	key, ok := this.keyMap[seg.KeyUrl]
+	this.keyLocker.Unlock()
+	if ok == false {
+		var err error
+		key, err = this.fetch(seg.KeyUrl)
+		if err != nil {
+			return nil, fmt.Errorf("下载密钥失败: %w", err)
+		}
+		this.keyLocker.Lock()
+		this.keyMap[seg.KeyUrl] = key
+		this.keyLocker.Unlock()
+	}
+	if len(key) != 16 { // aes.NewCipher 也接受 AES-192/256 的密钥长度
+		return nil, fmt.Errorf("密钥长度错误: %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("加密分片长度错误")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, seg.Iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || bytes.Count(out[len(out)-pad:], out[len(out)-1:]) != pad {
+		return nil, errors.New("解密失败, 密钥错误")
+	}
+	return out[:len(out)-pad], nil
+}
+
+// onSegmentDone 直播没有分片总数, 只统计速度
+func (this *hlsDownloader) onSegmentDone(size int) {
+	this.doneLocker.Lock()
+	this.done++
+	progress := float64(this.done) / float64(this.total)
+	this.doneLocker.Unlock()
+
+	d := this.downloader
+	if this.total > 0 {
+		d.emit(Event{Type: EventPartProgress, PartName: this.partName, Progress: progress})
+	}
+	d.speedAddBytes(size)
+	select {
+	case <-this.ticker.C:
+		speed := d.speedRecent5sGetAndUpdate()
+		if speed != "" {
+			d.emit(Event{Type: EventSpeed, Speed: speed, IsMultiThread: true})
+		}
+	default:
+	}
+}
+
+// concatFiles 按顺序拼接到临时文件, 成功后改名
+func concatFiles(nameList []string, outName string) (err error) {
+	tmpName := outName + ".downloading"
+	out, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	for _, name := range nameList {
+		var in *os.File
+		in, err = os.Open(name)
+		if err != nil {
+			break
+		}
+		_, err = io.Copy(out, in)
+		in.Close()
+		if err != nil {
+			break
+		}
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, outName)
+}
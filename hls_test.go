@@ -0,0 +1,190 @@
+package bilibili
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHlsDecrypt(t *testing.T) {
+	key16 := []byte("0123456789abcdef")
+	key24 := []byte("0123456789abcdef01234567")
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key16":
+			w.Write(key16)
+		case "/key24":
+			w.Write(key24)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	iv := make([]byte, aes.BlockSize)
+	iv[15] = 7
+	encrypt := func(key []byte, data []byte) []byte {
+		pad := aes.BlockSize - len(data)%aes.BlockSize
+		data = append(append([]byte{}, data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		block, _ := aes.NewCipher(key)
+		out := make([]byte, len(data))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+		return out
+	}
+	d := newBilibiliDownloader(BeginDownload_Req{})
+	dl := &hlsDownloader{downloader: d, ctx: context.Background(), keyMap: map[string][]byte{}}
+
+	plain := []byte("segment data")
+	out, err := dl.decrypt(HlsSegment{KeyUrl: gBilibiliApiHost + "/key16", Iv: iv}, encrypt(key16, plain))
+	if err != nil || bytes.Equal(out, plain) == false {
+		t.Fatal(out, err)
+	}
+	// AES-192 的密钥不是 AES-128
+	_, err = dl.decrypt(HlsSegment{KeyUrl: gBilibiliApiHost + "/key24", Iv: iv}, encrypt(key24, plain))
+	if err == nil || strings.Contains(err.Error(), "密钥长度错误") == false {
+		t.Fatal(err)
+	}
+	_, err = dl.decrypt(HlsSegment{KeyUrl: gBilibiliApiHost + "/key16", Iv: iv}, encrypt([]byte("fedcba9876543210"), plain))
+	if err == nil {
+		t.Fatal("wrong key")
+	}
+}
+
+func TestParseM3u8Live(t *testing.T) {
+	data := "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:100\n#EXTINF:2.0,\nseg100.ts\n#EXTINF:2.0,\nseg101.ts\n"
+	p, err := parseM3u8([]byte(data), "https://example.com/live/index.m3u8")
+	if err != nil || p.media.IsLive == false || p.media.TargetDuration != 2 || len(p.media.SegmentList) != 2 {
+		t.Fatal(p, err)
+	}
+	if seg := p.media.SegmentList[1]; seg.Url != "https://example.com/live/seg101.ts" || seg.Sequence != 101 {
+		t.Fatal(seg)
+	}
+	p, err = parseM3u8([]byte(data+"#EXT-X-ENDLIST\n"), "https://example.com/live/index.m3u8")
+	if err != nil || p.media.IsLive {
+		t.Fatal(p, err)
+	}
+}
+
+// newTestHlsLiveServer 每个播放列表按请求次数返回不同的内容, 分片的内容是 "segN|"
+func newTestHlsLiveServer(t *testing.T, playlistFn map[string]func(n int) string) (refreshCh chan string) {
+	var locker sync.Mutex
+	countMap := map[string]int{}
+	refreshCh = make(chan string, 100)
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if fn, ok := playlistFn[r.URL.Path]; ok {
+			locker.Lock()
+			countMap[r.URL.Path]++
+			n := countMap[r.URL.Path]
+			locker.Unlock()
+			w.Write([]byte(fn(n)))
+			refreshCh <- r.URL.Path
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			w.Write([]byte(strings.TrimSuffix(path.Base(r.URL.Path), ".ts") + "|"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	oldRefresh := gHlsLiveMinRefresh
+	gHlsLiveMinRefresh = time.Millisecond
+	t.Cleanup(func() {
+		gHlsLiveMinRefresh = oldRefresh
+	})
+	return refreshCh
+}
+
+func newTestLivePlaylist(seq int, count int, end bool) string {
+	s := fmt.Sprintf("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:%d\n", seq)
+	for i := seq; i < seq+count; i++ {
+		s += fmt.Sprintf("#EXTINF:0.001,\nseg%d.ts\n", i)
+	}
+	if end {
+		s += "#EXT-X-ENDLIST\n"
+	}
+	return s
+}
+
+func TestHlsLive(t *testing.T) {
+	newTestHlsLiveServer(t, map[string]func(n int) string{
+		"/a/index.m3u8": func(n int) string {
+			switch n {
+			case 1:
+				return newTestLivePlaylist(0, 2, false)
+			case 2, 3:
+				return newTestLivePlaylist(1, 2, false)
+			default: // seg3 没有刷新到
+				return newTestLivePlaylist(4, 2, true)
+			}
+		},
+		"/b/index.m3u8": func(n int) string {
+			return newTestLivePlaylist(7, 1, false)
+		},
+	})
+	dir := t.TempDir()
+	for _, cas := range []struct {
+		path    string
+		content string
+		message string
+	}{
+		{"/a/index.m3u8", "seg0|seg1|seg2|seg4|seg5|", "刷新太慢, 丢失了 1 个分片"},
+		{"/b/index.m3u8", "seg7|", "长时间没有新的分片, 认为直播已结束"},
+	} {
+		r := newTestEventRecorder(t)
+		d := newBilibiliDownloader(BeginDownload_Req{Url: gBilibiliApiHost + cas.path, SaveDir: dir})
+		d.sink = r
+		result, err := d.Run()
+		d.closeFn()
+		if err != nil {
+			t.Fatal(cas.path, err)
+		}
+		if strings.HasPrefix(result.OutName, path.Base(path.Dir(cas.path))+"_index_") == false {
+			t.Fatal(result.OutName)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, result.OutName+".ts"))
+		if err != nil || string(data) != cas.content {
+			t.Fatal(cas.path, string(data), err)
+		}
+		var found bool
+		for _, ev := range r.getEventList() {
+			found = found || (ev.Type == EventMessage && ev.Message == cas.message)
+		}
+		if found == false {
+			t.Fatal(cas.path, "no message", cas.message)
+		}
+	}
+}
+
+// TestHlsLiveStop 停止录制是正常结束, 已经录制的部分保存下来
+func TestHlsLiveStop(t *testing.T) {
+	refreshCh := newTestHlsLiveServer(t, map[string]func(n int) string{
+		"/c/index.m3u8": func(n int) string {
+			return newTestLivePlaylist(n, 2, false)
+		},
+	})
+	dir := t.TempDir()
+	r := newTestEventRecorder(t)
+	m := NewManager(0, r)
+	taskId := m.AddTask(BeginDownload_Req{Url: gBilibiliApiHost + "/c/index.m3u8", SaveDir: dir})
+	// 第一次是解析, 第二次刷新时第一个播放列表的分片已经写入
+	<-refreshCh
+	<-refreshCh
+	m.StopDownload(taskId)
+	ev := r.waitEvent(taskId, EventFinished)
+	data, err := os.ReadFile(filepath.Join(dir, ev.OutName+".ts"))
+	if err != nil || strings.HasPrefix(string(data), "seg1|seg2|") == false {
+		t.Fatal(ev.OutName, string(data), err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ev.OutName+".ts.downloading")); os.IsNotExist(err) == false {
+		t.Fatal(err)
+	}
+	m.Wait()
+}
@@ -0,0 +1,146 @@
+package bilibili
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// VideoEntry 列表(UP主投稿/收藏夹等)中的一个视频, 下载时才解析
+type VideoEntry struct {
+	Id      string    `json:"id"` // 用于记录已经下载完成的视频, 例如 BV号
+	Url     string    `json:"url"`
+	Title   string    `json:"title"`
+	Index   int       `json:"index,omitempty"` // 在列表中的序号, 大于0时加在输出文件名前面
+	PubDate time.Time `json:"pub_date"`
+}
+
+// ListFilter 列表下载时的过滤条件, 零值表示不过滤
+type ListFilter struct {
+	After    time.Time // 只下载这个时间之后发布的视频
+	Before   time.Time // 只下载这个时间之前发布的视频
+	MaxCount int       // 最多下载多少个视频
+	Keyword  string    // 标题包含关键字
+}
+
+func (this ListFilter) match(entry VideoEntry) bool {
+	if this.After.IsZero() == false && entry.PubDate.Before(this.After) {
+		return false
+	}
+	if this.Before.IsZero() == false && entry.PubDate.After(this.Before) {
+		return false
+	}
+	if this.Keyword != "" && strings.Contains(strings.ToLower(entry.Title), strings.ToLower(this.Keyword)) == false {
+		return false
+	}
+	return true
+}
+
+func (this ListFilter) isFull(count int) bool {
+	return this.MaxCount > 0 && count >= this.MaxCount
+}
+
+const _doneListFileName = ".download_done"
+
+func readDoneList(dir string) map[string]bool {
+	m := map[string]bool{}
+	file, err := os.Open(filepath.Join(dir, _doneListFileName))
+	if err != nil {
+		return m
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			m[line] = true
+		}
+	}
+	return m
+}
+
+func appendDoneList(dir string, id string) error {
+	file, err := os.OpenFile(filepath.Join(dir, _doneListFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(id + "\n")
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// downloadList 依次解析并下载列表中的视频, 输出到以列表命名的目录中.
+// 下载完成的视频记录在目录下的 _doneListFileName 里, 重新下载时跳过.
+// 单个视频失败时继续下载后面的视频
+func (this *BilibiliDownloader) downloadList(info VideoInfo) (outName string, err error) {
+	dir := filepath.Join(this.req.SaveDir, info.Name)
+	err = os.MkdirAll(dir, 0777)
+	if err != nil {
+		return "", wrapError("downloadList", err)
+	}
+	doneMap := readDoneList(dir)
+	var failedCount int
+	for idx, entry := range info.EntryList {
+		if this.isCancel() {
+			return "", newError("downloadList", ErrCanceled, this.ctx.Err())
+		}
+		prefix := fmt.Sprintf("(%d/%d) ", idx+1, len(info.EntryList))
+		if doneMap[entry.Id] {
+			this.fnMessage(prefix + "已下载, 跳过: " + entry.Title)
+			continue
+		}
+		this.fnMessage(prefix + entry.Title)
+		err = this.downloadEntry(dir, entry, idx, len(info.EntryList))
+		if err != nil {
+			if errors.Is(err, ErrCanceled) || this.isCancel() {
+				return "", err
+			}
+			failedCount++
+			this.fnMessage(prefix + "下载失败: " + err.Error())
+			continue
+		}
+		err = appendDoneList(dir, entry.Id)
+		if err != nil {
+			return "", wrapError("downloadList", err)
+		}
+	}
+	if failedCount > 0 {
+		return info.Name, newError("downloadList", nil, fmt.Errorf("%d 个视频下载失败", failedCount))
+	}
+	return info.Name, nil
+}
+
+// downloadEntry 使用一个子下载器下载列表中的一个视频, 进度换算为整个列表的进度
+func (this *BilibiliDownloader) downloadEntry(dir string, entry VideoEntry, idx int, count int) error {
+	req := this.req
+	req.Url = entry.Url
+	req.SaveDir = dir
+	req.Pages = ""
+	child := newBilibiliDownloaderWithClient(this.ctx, req, this.httpClient)
+	defer child.closeFn()
+	child.sink = EventSinkFunc(func(ev Event) {
+		if ev.Type == EventPartProgress {
+			ev.Progress = (float64(idx) + ev.Progress) / float64(count)
+		}
+		this.emit(ev)
+	})
+	info, err := child.getVideoInfo(entry.Url)
+	if err != nil {
+		return err
+	}
+	if len(info.EntryList) > 0 {
+		return newError("downloadEntry", ErrUnsupportedURL, nil)
+	}
+	if entry.Index > 0 {
+		info.Name = fmt.Sprintf("%03d_%s", entry.Index, info.Name)
+	}
+	_, err = child.downloadVideo(info)
+	return err
+}
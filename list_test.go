@@ -0,0 +1,71 @@
+package bilibili
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getTestMessageList(r *testEventRecorder, prefix string) (list []string) {
+	for _, ev := range r.getEventList() {
+		if ev.Type == EventMessage && strings.HasPrefix(ev.Message, prefix) {
+			list = append(list, ev.Message)
+		}
+	}
+	return list
+}
+
+// TestDownloadListResume 已经记录在 .download_done 里的视频跳过, 失败的视频不记录, 下次重新下载
+func TestDownloadListResume(t *testing.T) {
+	dir := t.TempDir()
+	url2 := newTestUrl(t, "b")
+	url3 := newTestUrl(t, "c")
+	releaseTestUrl(url2)
+	releaseTestUrl(url3)
+	info := VideoInfo{Name: "space2_up", EntryList: []VideoEntry{
+		{Id: "BV1", Url: "https://www.bilibili.com/", Title: "a"}, // 不跳过的话会下载失败
+		{Id: "BV2", Url: url2, Title: "b"},
+		{Id: "BV3", Url: "https://www.bilibili.com/", Title: "c"},
+	}}
+	doneName := filepath.Join(dir, info.Name, _doneListFileName)
+	err := os.MkdirAll(filepath.Dir(doneName), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(doneName, []byte("BV1\n\n  \n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := newTestEventRecorder(t)
+	d := newBilibiliDownloader(BeginDownload_Req{SaveDir: dir})
+	d.sink = r
+	defer d.closeFn()
+	outName, err := d.downloadList(info)
+	if err == nil || strings.Contains(err.Error(), "1 个视频下载失败") == false || outName != info.Name {
+		t.Fatal(outName, err)
+	}
+	if got := getTestMessageList(r, "("); len(got) != 4 || strings.HasPrefix(got[3], "(3/3) 下载失败: ") == false || reflect.DeepEqual(got[:3], []string{"(1/3) 已下载, 跳过: a", "(2/3) b", "(3/3) c"}) == false {
+		t.Fatal(got)
+	}
+	if data, _ := os.ReadFile(doneName); string(data) != "BV1\n\n  \nBV2\n" {
+		t.Fatalf("%q", data)
+	}
+
+	// 第二次只下载上次失败的视频
+	info.EntryList[2].Url = url3
+	r = newTestEventRecorder(t)
+	d.sink = r
+	_, err = d.downloadList(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := getTestMessageList(r, "("); reflect.DeepEqual(got, []string{"(1/3) 已下载, 跳过: a", "(2/3) 已下载, 跳过: b", "(3/3) c"}) == false {
+		t.Fatal(got)
+	}
+	if got := readDoneList(filepath.Dir(doneName)); reflect.DeepEqual(got, map[string]bool{"BV1": true, "BV2": true, "BV3": true}) == false {
+		t.Fatal(got)
+	}
+}
@@ -0,0 +1,316 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/orestonce/bilibili/muxer"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 直播接口的域名, 测试时可以替换成本地服务
+var gLiveApiHost = "https://api.live.bilibili.com"
+
+const _livePlayInfoUrlTemp = "%s/xlive/web-room/v2/index/getRoomPlayInfo?room_id=%d&protocol=0&format=0&codec=0,1&qn=%d&platform=web&ptype=8"
+const _liveRoomInfoUrlTemp = "%s/room/v1/Room/get_info?room_id=%d"
+
+const _liveQnMax = 10000 // 原画
+
+// 断线重连的等待时间, 每次失败翻倍, 测试时可以缩短
+var (
+	gLiveRetryMinDelay = time.Second
+	gLiveRetryMaxDelay = time.Second * 30
+)
+
+var gLiveRegexp = regexp.MustCompile(`live\.bilibili\.com/(?:h5/|blanc/)?(\d+)`)
+
+// LiveOption 直播录制的参数
+type LiveOption struct {
+	SplitDuration time.Duration // 按时长分割, 0 表示不分割
+	SplitSize     int64         // 按大小分割(字节), 0 表示不分割
+}
+
+// LiveInfo 直播间的状态和播放地址, 播放地址有时效, 重连时会重新获取
+type LiveInfo struct {
+	RoomId int64  `json:"room_id"` // 真实房间号, 网址里的可能是短号
+	Uid    int64  `json:"uid"`
+	Title  string `json:"title"` // getLiveInfo 不获取标题, 需要时调用 getLiveRoomTitle
+	IsLive bool   `json:"is_live"`
+	FlvUrl string `json:"flv_url"` // 录制只使用flv, 不请求hls的播放地址
+}
+
+type liveExtractor struct{}
+
+func (liveExtractor) Match(url string) bool {
+	return gLiveRegexp.MatchString(url)
+}
+
+func (liveExtractor) Extract(ctx context.Context, url string) (info VideoInfo, err error) {
+	params := gLiveRegexp.FindStringSubmatch(url)
+	if params == nil {
+		return info, ErrUnsupportedURL
+	}
+	this := getDownloaderFromCtx(ctx)
+	roomId, _ := strconv.ParseInt(params[1], 10, 64)
+	live, err := this.getLiveInfo(roomId)
+	if err != nil {
+		return info, err
+	}
+	if live.IsLive == false {
+		return info, newError("getLiveInfo", ErrVideoNotFound, errors.New("直播间未开播"))
+	}
+	live.Title = this.getLiveRoomTitle(live.RoomId)
+	this.fnMessage("直播间: " + live.Title)
+	info.Title = live.Title
+	info.Name = fmt.Sprintf("live%d_%s", live.RoomId, TitleEdit(live.Title))
+	info.Live = &live
+	return info, nil
+}
+
+// getLiveInfo 获取直播间状态, 开播时同时获取flv的播放地址. 标题需要单独请求, 这里不获取, 重连和定时检查时不用每次都请求
+func (this *BilibiliDownloader) getLiveInfo(roomId int64) (live LiveInfo, err error) {
+	qn := this.req.Quality
+	if qn <= 0 {
+		qn = _liveQnMax
+	}
+	contents, err := this.defaultFetcher(fmt.Sprintf(_livePlayInfoUrlTemp, gLiveApiHost, roomId, qn))
+	if err != nil {
+		return live, wrapError("getLiveInfo", err)
+	}
+	type liveCodec struct {
+		CodecName string `json:"codec_name"`
+		BaseUrl   string `json:"base_url"`
+		UrlInfo   []struct {
+			Host  string `json:"host"`
+			Extra string `json:"extra"`
+		} `json:"url_info"`
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			RoomId      int64 `json:"room_id"`
+			Uid         int64 `json:"uid"`
+			LiveStatus  int   `json:"live_status"` // 1 直播中, 2 轮播
+			PlayurlInfo *struct {
+				Playurl struct {
+					Stream []struct {
+						ProtocolName string `json:"protocol_name"`
+						Format       []struct {
+							FormatName string      `json:"format_name"`
+							Codec      []liveCodec `json:"codec"`
+						} `json:"format"`
+					} `json:"stream"`
+				} `json:"playurl"`
+			} `json:"playurl_info"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err != nil {
+		return live, wrapError("getLiveInfo_2", err)
+	}
+	if tmp.Code != 0 {
+		return live, apiCodeError("获取直播间信息失败", tmp.Code, tmp.Message)
+	}
+	live.RoomId = tmp.Data.RoomId
+	live.Uid = tmp.Data.Uid
+	live.IsLive = tmp.Data.LiveStatus == 1
+	if live.IsLive == false || tmp.Data.PlayurlInfo == nil {
+		return live, nil
+	}
+	// 优先 req.Codec 指定的编码, 否则 avc
+	pickUrl := func(codecList []liveCodec) string {
+		var url string
+		for _, one := range codecList {
+			if len(one.UrlInfo) == 0 {
+				continue
+			}
+			u := one.UrlInfo[0].Host + one.BaseUrl + one.UrlInfo[0].Extra
+			if one.CodecName == this.req.Codec {
+				return u
+			}
+			if url == "" || one.CodecName == CodecAvc {
+				url = u
+			}
+		}
+		return url
+	}
+	for _, stream := range tmp.Data.PlayurlInfo.Playurl.Stream {
+		for _, format := range stream.Format {
+			if stream.ProtocolName == "http_stream" && format.FormatName == "flv" {
+				live.FlvUrl = pickUrl(format.Codec)
+			}
+		}
+	}
+	return live, nil
+}
+
+// getLiveRoomTitle 失败时只提示
+func (this *BilibiliDownloader) getLiveRoomTitle(roomId int64) string {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_liveRoomInfoUrlTemp, gLiveApiHost, roomId))
+	if err != nil {
+		this.fnMessage("获取直播间标题失败: " + err.Error())
+		return ""
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Title string `json:"title"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err == nil && tmp.Code != 0 {
+		err = apiCodeError("getLiveRoomTitle", tmp.Code, tmp.Message)
+	}
+	if err != nil {
+		this.fnMessage("获取直播间标题失败: " + err.Error())
+		return ""
+	}
+	return tmp.Data.Title
+}
+
+// recordLive 录制flv直播流, 直到 StopDownload 或者主播下播. 断线时重新获取播放地址并自动重连,
+// 按 req.Live 的设置分割为多个文件, 返回最后一个文件名. 开启弹幕时每个文件旁边保存对应时间段的弹幕
+func (this *BilibiliDownloader) recordLive(info VideoInfo) (outName string, err error) {
+	live := *info.Live
+	rec := &muxer.FlvRecorder{
+		MaxDuration: this.req.Live.SplitDuration,
+		MaxSize:     this.req.Live.SplitSize,
+	}
+	var dw *liveDanmakuWriter
+	if this.req.Danmaku.Enable {
+		dw = &liveDanmakuWriter{downloader: this, positionFn: rec.Position}
+		ctx, cancel := context.WithCancel(this.ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			this.runLiveDanmaku(ctx, live.RoomId, dw.add)
+		}()
+		defer func() {
+			cancel()
+			wg.Wait()
+			dw.finish()
+		}()
+	}
+	rec.NextFile = func() (string, error) {
+		name := filepath.Join(this.req.SaveDir, fmt.Sprintf("%s_%s.flv", info.Name, time.Now().Format("20060102_150405")))
+		for idx := 2; ; idx++ {
+			if _, err := os.Stat(name); os.IsNotExist(err) {
+				break
+			}
+			name = filepath.Join(this.req.SaveDir, fmt.Sprintf("%s_%s_%d.flv", info.Name, time.Now().Format("20060102_150405"), idx))
+		}
+		this.fnMessage("录制到文件: " + filepath.Base(name))
+		if dw != nil {
+			dw.startFile(name)
+		}
+		return name, nil
+	}
+	defer func() {
+		closeErr := rec.Close()
+		if err == nil && closeErr != nil {
+			err = wrapError("录制直播失败", closeErr)
+		}
+		if rec.FileName() != "" {
+			outName = filepath.Base(rec.FileName())
+		}
+	}()
+	err = os.MkdirAll(this.req.SaveDir, 0777)
+	if err != nil {
+		return "", wrapError("录制直播失败", err)
+	}
+	this.speedSetBegin()
+	retryDelay := gLiveRetryMinDelay
+	for {
+		if live.FlvUrl == "" {
+			return "", newError("录制直播失败", ErrVideoNotFound, errors.New("没有可用的flv直播流"))
+		}
+		n, err := this.recordLiveOnce(rec, live.FlvUrl)
+		if this.isCancel() {
+			return "", nil
+		}
+		if n > 0 {
+			retryDelay = gLiveRetryMinDelay
+		}
+		msg := "直播连接断开"
+		if err != nil && err != io.EOF {
+			msg += ": " + err.Error()
+		}
+		this.fnMessage(fmt.Sprintf("%s, %v后重连", msg, retryDelay))
+		select {
+		case <-this.ctx.Done():
+			return "", nil
+		case <-time.After(retryDelay):
+		}
+		retryDelay *= 2
+		if retryDelay > gLiveRetryMaxDelay {
+			retryDelay = gLiveRetryMaxDelay
+		}
+		latest, err := this.getLiveInfo(live.RoomId)
+		if err != nil {
+			if this.isCancel() {
+				return "", nil
+			}
+			this.fnMessage("获取直播间信息失败: " + err.Error())
+			continue
+		}
+		if latest.IsLive == false {
+			this.fnMessage("直播已结束")
+			return "", nil
+		}
+		live = latest
+	}
+}
+
+func (this *BilibiliDownloader) recordLiveOnce(rec *muxer.FlvRecorder, flvUrl string) (n int64, err error) {
+	request, err := http.NewRequest(http.MethodGet, flvUrl, nil)
+	if err != nil {
+		return 0, err
+	}
+	request = request.WithContext(this.ctx)
+	request.Header.Set("User-Agent", userAgent)
+	request.Header.Set("Referer", "https://live.bilibili.com/")
+	request.Header.Set("Origin", "https://live.bilibili.com")
+	resp, err := this.httpClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("错误码： %d", resp.StatusCode)
+	}
+	lr := &liveReader{r: resp.Body, downloader: this, ticker: time.NewTicker(time.Second)}
+	defer lr.ticker.Stop()
+	return rec.ReadFrom(lr)
+}
+
+// liveReader 统计录制速度
+type liveReader struct {
+	r          io.Reader
+	downloader *BilibiliDownloader
+	ticker     *time.Ticker
+}
+
+func (this *liveReader) Read(buf []byte) (n int, err error) {
+	n, err = this.r.Read(buf)
+	this.downloader.speedAddBytes(n)
+	select {
+	case <-this.ticker.C:
+		speed := this.downloader.speedRecent5sGetAndUpdate()
+		if speed != "" {
+			this.downloader.emit(Event{Type: EventSpeed, Speed: speed})
+		}
+	default:
+	}
+	return n, err
+}
@@ -0,0 +1,416 @@
+package bilibili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const _liveDanmuInfoUrlTemp = "%s/xlive/web-room/v1/index/getDanmuInfo?%s"
+
+// 弹幕服务器的协议, 测试时可以替换成 ws
+var gLiveDanmakuScheme = "wss"
+
+const _liveDanmakuHeartbeatInterval = time.Second * 30
+
+// 数据包的操作码
+const (
+	liveOpHeartbeat      = 2
+	liveOpHeartbeatReply = 3
+	liveOpMessage        = 5
+	liveOpAuth           = 7
+	liveOpAuthReply      = 8
+)
+
+// 数据包的协议版本, 2 和 3 的body是压缩后的多个数据包
+const (
+	liveProtoJson   = 0
+	liveProtoInt    = 1
+	liveProtoZlib   = 2
+	liveProtoBrotli = 3
+)
+
+const _livePacketHeaderSize = 16
+
+type livePacket struct {
+	protover uint16
+	op       uint32
+	body     []byte
+}
+
+// encodeLivePacket 包头: 总长度u32 包头长度u16 协议版本u16 操作码u32 序号u32, 都是大端
+func encodeLivePacket(protover uint16, op uint32, body []byte) []byte {
+	buf := make([]byte, _livePacketHeaderSize, _livePacketHeaderSize+len(body))
+	binary.BigEndian.PutUint32(buf[0:], uint32(_livePacketHeaderSize+len(body)))
+	binary.BigEndian.PutUint16(buf[4:], _livePacketHeaderSize)
+	binary.BigEndian.PutUint16(buf[6:], protover)
+	binary.BigEndian.PutUint32(buf[8:], op)
+	binary.BigEndian.PutUint32(buf[12:], 1)
+	return append(buf, body...)
+}
+
+// decodeLivePackets 一条websocket消息里可能有多个数据包, 压缩的数据包解压后递归解析
+func decodeLivePackets(data []byte) (list []livePacket, err error) {
+	for len(data) > 0 {
+		if len(data) < _livePacketHeaderSize {
+			return nil, errors.New("decodeLivePackets invalid header")
+		}
+		total := int(binary.BigEndian.Uint32(data[0:]))
+		headerSize := int(binary.BigEndian.Uint16(data[4:]))
+		if total < headerSize || headerSize < _livePacketHeaderSize || total > len(data) {
+			return nil, errors.New("decodeLivePackets invalid length")
+		}
+		p := livePacket{
+			protover: binary.BigEndian.Uint16(data[6:]),
+			op:       binary.BigEndian.Uint32(data[8:]),
+			body:     data[headerSize:total],
+		}
+		data = data[total:]
+
+		var r io.Reader
+		switch {
+		case p.op == liveOpMessage && p.protover == liveProtoZlib:
+			zr, err := zlib.NewReader(bytes.NewReader(p.body))
+			if err != nil {
+				return nil, err
+			}
+			r = zr
+		case p.op == liveOpMessage && p.protover == liveProtoBrotli:
+			r = brotli.NewReader(bytes.NewReader(p.body))
+		default:
+			list = append(list, p)
+			continue
+		}
+		inner, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		innerList, err := decodeLivePackets(inner)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, innerList...)
+	}
+	return list, nil
+}
+
+// liveDanmaku 直播间的一条弹幕或醒目留言, 每条写入jsonl的一行
+type liveDanmaku struct {
+	Time     int64   `json:"time"`   // 收到的时间, unix毫秒
+	Offset   float64 `json:"offset"` // 在录制文件中的秒数
+	Cmd      string  `json:"cmd"`
+	Uid      int64   `json:"uid"`
+	Uname    string  `json:"uname"`
+	Text     string  `json:"text"`
+	Mode     int     `json:"mode"`
+	FontSize int     `json:"font_size"`
+	Color    int     `json:"color"`
+}
+
+// parseLiveMessage 只处理 DANMU_MSG 和 SUPER_CHAT_MESSAGE, 其它消息和无法转换为ass的弹幕返回 ok=false
+func parseLiveMessage(body []byte) (msg liveDanmaku, ok bool) {
+	var tmp struct {
+		Cmd  string            `json:"cmd"`
+		Info []json.RawMessage `json:"info"`
+		Data struct {
+			Uid      int64  `json:"uid"`
+			Message  string `json:"message"`
+			Price    int    `json:"price"`
+			UserInfo struct {
+				Uname string `json:"uname"`
+			} `json:"user_info"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &tmp) != nil {
+		return msg, false
+	}
+	// cmd 可能带有后缀, 例如 DANMU_MSG:4:0:2:2:2:0
+	msg.Cmd, _, _ = strings.Cut(tmp.Cmd, ":")
+	switch msg.Cmd {
+	case "DANMU_MSG":
+		if len(tmp.Info) < 3 {
+			return msg, false
+		}
+		var meta []json.Number
+		var user []interface{}
+		json.Unmarshal(tmp.Info[0], &meta)
+		json.Unmarshal(tmp.Info[1], &msg.Text)
+		json.Unmarshal(tmp.Info[2], &user)
+		// info[0]: [?, 模式, 字号, 颜色, 发送时间, ...], info[2]: [uid, 用户名, ...]
+		if len(meta) > 3 {
+			msg.Mode, _ = strconv.Atoi(meta[1].String())
+			msg.FontSize, _ = strconv.Atoi(meta[2].String())
+			msg.Color, _ = strconv.Atoi(meta[3].String())
+		}
+		if len(user) > 1 {
+			if uid, ok := user[0].(float64); ok {
+				msg.Uid = int64(uid)
+			}
+			msg.Uname, _ = user[1].(string)
+		}
+	case "SUPER_CHAT_MESSAGE":
+		msg.Uid = tmp.Data.Uid
+		msg.Uname = tmp.Data.UserInfo.Uname
+		msg.Text = fmt.Sprintf("[SC ¥%d] %s", tmp.Data.Price, tmp.Data.Message)
+		msg.Mode = danmakuModeTop
+		msg.Color = 0xffd700
+	default:
+		return msg, false
+	}
+	if msg.Mode == 0 {
+		msg.Mode = danmakuModeScroll
+	}
+	if msg.Mode, ok = normalizeDanmakuMode(msg.Mode); ok == false {
+		return msg, false
+	}
+	if msg.FontSize == 0 {
+		msg.FontSize = 25
+	}
+	if msg.Color == 0 {
+		msg.Color = 0xffffff
+	}
+	return msg, msg.Text != ""
+}
+
+// getLiveDanmakuServer 返回弹幕服务器地址和认证用的token
+func (this *BilibiliDownloader) getLiveDanmakuServer(roomId int64) (wsUrl string, token string, err error) {
+	query := url.Values{}
+	query.Set("id", strconv.FormatInt(roomId, 10))
+	query.Set("type", "0")
+	signed, err := this.signWbi(query)
+	if err != nil {
+		return "", "", err
+	}
+	contents, err := this.defaultFetcher(fmt.Sprintf(_liveDanmuInfoUrlTemp, gLiveApiHost, signed))
+	if err != nil {
+		return "", "", wrapError("getLiveDanmakuServer", err)
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Token    string `json:"token"`
+			HostList []struct {
+				Host    string `json:"host"`
+				WsPort  int    `json:"ws_port"`
+				WssPort int    `json:"wss_port"`
+			} `json:"host_list"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err != nil {
+		return "", "", wrapError("getLiveDanmakuServer_2", err)
+	}
+	if tmp.Code != 0 {
+		return "", "", apiCodeError("getLiveDanmakuServer", tmp.Code, tmp.Message)
+	}
+	if len(tmp.Data.HostList) == 0 {
+		return "", "", newError("getLiveDanmakuServer", nil, errors.New("没有弹幕服务器"))
+	}
+	host := tmp.Data.HostList[0]
+	port := host.WssPort
+	if gLiveDanmakuScheme == "ws" {
+		port = host.WsPort
+	}
+	return fmt.Sprintf("%s://%s:%d/sub", gLiveDanmakuScheme, host.Host, port), tmp.Data.Token, nil
+}
+
+// getLoginUid 已登录时从cookie里取uid, 未登录的连接收到的弹幕用户名是打码的
+func (this *BilibiliDownloader) getLoginUid() (uid int64) {
+	if this.jar == nil {
+		return 0
+	}
+	for _, c := range this.jar.Cookies(&url.URL{Scheme: "https", Host: "live.bilibili.com", Path: "/"}) {
+		if c.Name == "DedeUserID" {
+			uid, _ = strconv.ParseInt(c.Value, 10, 64)
+		}
+	}
+	return uid
+}
+
+// runLiveDanmaku 连接弹幕服务器直到ctx取消, 断线时自动重连
+func (this *BilibiliDownloader) runLiveDanmaku(ctx context.Context, roomId int64, onMessage func(msg liveDanmaku)) {
+	retryDelay := gLiveRetryMinDelay
+	for {
+		received, err := this.connectLiveDanmaku(ctx, roomId, onMessage)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			retryDelay = gLiveRetryMinDelay
+		}
+		this.fnMessage(fmt.Sprintf("弹幕连接断开: %s, %d秒后重连", err, int(retryDelay.Seconds())))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+		retryDelay *= 2
+		if retryDelay > gLiveRetryMaxDelay {
+			retryDelay = gLiveRetryMaxDelay
+		}
+	}
+}
+
+func (this *BilibiliDownloader) connectLiveDanmaku(ctx context.Context, roomId int64, onMessage func(msg liveDanmaku)) (received bool, err error) {
+	wsUrl, token, err := this.getLiveDanmakuServer(roomId)
+	if err != nil {
+		return false, err
+	}
+	header := http.Header{}
+	header.Set("User-Agent", userAgent)
+	header.Set("Origin", "https://live.bilibili.com")
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsUrl, header)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	auth, _ := json.Marshal(map[string]interface{}{
+		"uid":      this.getLoginUid(),
+		"roomid":   roomId,
+		"protover": liveProtoBrotli,
+		"platform": "web",
+		"type":     2,
+		"key":      token,
+	})
+	var writeLocker sync.Mutex
+	send := func(op uint32, body []byte) error {
+		writeLocker.Lock()
+		defer writeLocker.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, encodeLivePacket(liveProtoInt, op, body))
+	}
+	err = send(liveOpAuth, auth)
+	if err != nil {
+		return false, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(_liveDanmakuHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				conn.Close() // 让 ReadMessage 返回
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				send(liveOpHeartbeat, []byte("[object Object]"))
+			}
+		}
+	}()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		packetList, err := decodeLivePackets(data)
+		if err != nil {
+			return received, err
+		}
+		for _, p := range packetList {
+			switch p.op {
+			case liveOpAuthReply:
+				var reply struct {
+					Code int `json:"code"`
+				}
+				json.Unmarshal(p.body, &reply)
+				if reply.Code != 0 {
+					return received, fmt.Errorf("认证失败, 错误码: %d", reply.Code)
+				}
+				send(liveOpHeartbeat, []byte("[object Object]"))
+			case liveOpMessage:
+				if msg, ok := parseLiveMessage(p.body); ok {
+					received = true
+					msg.Time = time.Now().UnixMilli()
+					onMessage(msg)
+				}
+			}
+		}
+	}
+}
+
+// liveDanmakuWriter 每个录制文件旁边保存一个 .danmaku.jsonl, 文件结束时再根据时间偏移生成 .danmaku.ass.
+// 时间偏移是收到弹幕时录制文件已经录制到的媒体时间, 断线重连的时间不计算在内
+type liveDanmakuWriter struct {
+	locker     sync.Mutex
+	downloader *BilibiliDownloader
+	positionFn func() time.Duration // 当前录制文件的媒体时间
+	baseName   string               // 录制文件去掉扩展名
+	file       *os.File
+	itemList   []danmakuItem
+}
+
+// startFile 录制文件切换时调用, 结束上一个文件的弹幕
+func (this *liveDanmakuWriter) startFile(videoName string) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.finishLocked()
+	this.baseName = strings.TrimSuffix(videoName, ".flv")
+	file, err := os.Create(this.baseName + ".danmaku.jsonl")
+	if err != nil {
+		this.downloader.fnMessage("保存弹幕失败: " + err.Error())
+		return
+	}
+	this.file = file
+}
+
+// add 还没有开始录制时收到的弹幕丢弃
+func (this *liveDanmakuWriter) add(msg liveDanmaku) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	if this.file == nil {
+		return
+	}
+	msg.Offset = this.positionFn().Seconds()
+	line, _ := json.Marshal(msg)
+	_, err := this.file.Write(append(line, '\n'))
+	if err != nil {
+		this.downloader.fnMessage("保存弹幕失败: " + err.Error())
+	}
+	this.itemList = append(this.itemList, danmakuItem{
+		time:     msg.Offset,
+		mode:     msg.Mode,
+		fontSize: msg.FontSize,
+		color:    msg.Color,
+		text:     msg.Text,
+	})
+}
+
+func (this *liveDanmakuWriter) finish() {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	this.finishLocked()
+}
+
+func (this *liveDanmakuWriter) finishLocked() {
+	if this.file == nil {
+		return
+	}
+	this.file.Close()
+	this.file = nil
+	err := writeFileAtomic(this.baseName+".danmaku.ass", renderDanmakuAss(this.itemList, this.downloader.req.Danmaku))
+	if err != nil {
+		this.downloader.fnMessage("保存弹幕失败: " + err.Error())
+	}
+	this.itemList = nil
+}
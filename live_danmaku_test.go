@@ -0,0 +1,236 @@
+package bilibili
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestDanmuMsg(mode int, color int, text string, uid int64, uname string) []byte {
+	body := fmt.Sprintf(`{"cmd":"DANMU_MSG:4:0:2:2:2:0","info":[[0,%d,25,%d,1700000000000,0],%q,[%d,%q,0]]}`, mode, color, text, uid, uname)
+	return encodeLivePacket(liveProtoJson, liveOpMessage, []byte(body))
+}
+
+// TestLiveDanmaku 本地的弹幕服务器: 检查认证包和心跳包, 然后发送 brotli 和 zlib 压缩的弹幕
+func TestLiveDanmaku(t *testing.T) {
+	type authInfo struct {
+		RoomId   int64  `json:"roomid"`
+		Protover int    `json:"protover"`
+		Key      string `json:"key"`
+	}
+	authCh := make(chan authInfo, 1)
+	heartbeatCh := make(chan struct{}, 1)
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xlive/web-room/v1/index/getDanmuInfo":
+			if r.URL.Query().Get("w_rid") == "" || r.URL.Query().Get("id") != "1000" {
+				w.Write([]byte(`{"code":-352,"message":"风控校验失败"}`))
+				return
+			}
+			u, _ := url.Parse("http://" + r.Host)
+			fmt.Fprintf(w, `{"code":0,"data":{"token":"TOKEN","host_list":[{"host":"%s","ws_port":%s,"wss_port":443}]}}`, u.Hostname(), u.Port())
+		case "/sub":
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			packetList, err := decodeLivePackets(data)
+			if err != nil || len(packetList) != 1 || packetList[0].op != liveOpAuth {
+				t.Errorf("invalid auth packet %v", err)
+				return
+			}
+			var auth authInfo
+			json.Unmarshal(packetList[0].body, &auth)
+			authCh <- auth
+			conn.WriteMessage(websocket.BinaryMessage, encodeLivePacket(liveProtoInt, liveOpAuthReply, []byte(`{"code":0}`)))
+
+			_, data, err = conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if binary.BigEndian.Uint32(data[8:]) == liveOpHeartbeat {
+				heartbeatCh <- struct{}{}
+			}
+
+			// brotli: 一条普通弹幕, 一条高级弹幕(丢弃), 一条其它消息
+			var inner []byte
+			inner = append(inner, newTestDanmuMsg(1, 0xff0000, "你好{world}", 42, "张三")...)
+			inner = append(inner, newTestDanmuMsg(7, 0xffffff, `[0,0,"1-1",4.5,"高级弹幕"]`, 43, "王五")...)
+			inner = append(inner, encodeLivePacket(liveProtoJson, liveOpMessage, []byte(`{"cmd":"INTERACT_WORD","data":{}}`))...)
+			var bb bytes.Buffer
+			bw := brotli.NewWriter(&bb)
+			bw.Write(inner)
+			bw.Close()
+			conn.WriteMessage(websocket.BinaryMessage, encodeLivePacket(liveProtoBrotli, liveOpMessage, bb.Bytes()))
+
+			// zlib: 模式2也是滚动弹幕, 以及一条醒目留言
+			inner = newTestDanmuMsg(2, 0xffffff, "第二条", 44, "赵六")
+			inner = append(inner, encodeLivePacket(liveProtoJson, liveOpMessage, []byte(`{"cmd":"SUPER_CHAT_MESSAGE","data":{"uid":7,"message":"加油","price":30,"user_info":{"uname":"李四"}}}`))...)
+			var zb bytes.Buffer
+			zw := zlib.NewWriter(&zb)
+			zw.Write(inner)
+			zw.Close()
+			conn.WriteMessage(websocket.BinaryMessage, encodeLivePacket(liveProtoZlib, liveOpMessage, zb.Bytes()))
+			conn.WriteMessage(websocket.BinaryMessage, encodeLivePacket(liveProtoInt, liveOpHeartbeatReply, []byte{0, 0, 0, 9}))
+			for { // 等待客户端断开
+				if _, _, err = conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	oldScheme := gLiveDanmakuScheme
+	gLiveDanmakuScheme = "ws"
+	defer func() {
+		gLiveDanmakuScheme = oldScheme
+	}()
+
+	dir := t.TempDir()
+	d := newBilibiliDownloader(BeginDownload_Req{Danmaku: DanmakuOption{Enable: true}})
+	d.sink = gDiscardEventSink
+	// 弹幕的时间是收到时录制到的媒体时间
+	var position time.Duration
+	dw := &liveDanmakuWriter{downloader: d, positionFn: func() time.Duration {
+		position += 1500 * time.Millisecond
+		return position
+	}}
+	dw.startFile(filepath.Join(dir, "room.flv"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	msgCh := make(chan liveDanmaku, 10)
+	done := make(chan struct{})
+	go func() {
+		d.runLiveDanmaku(ctx, 1000, func(msg liveDanmaku) {
+			dw.add(msg)
+			msgCh <- msg
+		})
+		close(done)
+	}()
+
+	timeout := time.After(time.Second * 10)
+	select {
+	case auth := <-authCh:
+		if auth.RoomId != 1000 || auth.Key != "TOKEN" || auth.Protover != liveProtoBrotli {
+			t.Fatal("auth", auth)
+		}
+	case <-timeout:
+		t.Fatal("no auth packet")
+	}
+	select {
+	case <-heartbeatCh:
+	case <-timeout:
+		t.Fatal("no heartbeat")
+	}
+	var msgList []liveDanmaku
+	for len(msgList) < 3 {
+		select {
+		case msg := <-msgCh:
+			msgList = append(msgList, msg)
+		case <-timeout:
+			t.Fatal("messages", msgList)
+		}
+	}
+	cancel()
+	<-done
+	dw.finish()
+
+	data, err := os.ReadFile(filepath.Join(dir, "room.danmaku.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatal(string(data))
+	}
+	want := []liveDanmaku{
+		{Cmd: "DANMU_MSG", Uid: 42, Uname: "张三", Text: "你好{world}", Mode: danmakuModeScroll, FontSize: 25, Color: 0xff0000},
+		{Cmd: "DANMU_MSG", Uid: 44, Uname: "赵六", Text: "第二条", Mode: danmakuModeScroll, FontSize: 25, Color: 0xffffff},
+		{Cmd: "SUPER_CHAT_MESSAGE", Uid: 7, Uname: "李四", Text: "[SC ¥30] 加油", Mode: danmakuModeTop, FontSize: 25, Color: 0xffd700},
+	}
+	for idx, line := range lines {
+		var got liveDanmaku
+		err = json.Unmarshal([]byte(line), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Time == 0 || got.Offset != float64(idx+1)*1.5 {
+			t.Fatal(line)
+		}
+		got.Time, got.Offset = 0, 0
+		if got != want[idx] {
+			t.Errorf("line %d: got %+v, want %+v", idx, got, want[idx])
+		}
+	}
+
+	data, err = os.ReadFile(filepath.Join(dir, "room.danmaku.ass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dialogueList []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "Dialogue:") {
+			dialogueList = append(dialogueList, line)
+		}
+	}
+	if len(dialogueList) != 3 {
+		t.Fatal(string(data))
+	}
+	for _, line := range dialogueList {
+		if strings.Contains(line, `\move(`) == false && strings.Contains(line, `\pos(`) == false {
+			t.Error("no position:", line)
+		}
+	}
+	if strings.Contains(dialogueList[0], `\c&H0000FF&`) == false || strings.Contains(dialogueList[0], "你好｛world｝") == false {
+		t.Error(dialogueList[0])
+	}
+}
+
+func TestParseLiveMessageMode(t *testing.T) {
+	tests := []struct {
+		mode   int
+		want   int
+		wantOk bool
+	}{
+		{0, danmakuModeScroll, true},
+		{1, danmakuModeScroll, true},
+		{2, danmakuModeScroll, true},
+		{3, danmakuModeScroll, true},
+		{danmakuModeBottom, danmakuModeBottom, true},
+		{danmakuModeTop, danmakuModeTop, true},
+		{danmakuModeReverse, danmakuModeReverse, true},
+		{7, 0, false},
+		{8, 0, false},
+	}
+	for _, tt := range tests {
+		packetList, err := decodeLivePackets(newTestDanmuMsg(tt.mode, 0xffffff, "text", 1, "user"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, ok := parseLiveMessage(packetList[0].body)
+		if ok != tt.wantOk || ok && msg.Mode != tt.want {
+			t.Errorf("mode %d: got %d %v, want %d %v", tt.mode, msg.Mode, ok, tt.want, tt.wantOk)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package bilibili
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestLiveFlv 只有视频的flv直播流, tsList 都是关键帧
+func newTestLiveFlv(tsList ...int64) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'L', 'V', 1, 1, 0, 0, 0, 9, 0, 0, 0, 0})
+	writeTag := func(ts int64, data []byte) {
+		buf.Write([]byte{9, 0, 0, byte(len(data)), byte(ts >> 16), byte(ts >> 8), byte(ts), byte(ts >> 24), 0, 0, 0})
+		buf.Write(data)
+		binary.Write(&buf, binary.BigEndian, uint32(len(data)+11))
+	}
+	writeTag(0, []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f})
+	for _, ts := range tsList {
+		writeTag(ts, []byte{0x17, 1, 0, 0, 0, byte(ts)})
+	}
+	return buf.Bytes()
+}
+
+// readTestFlvTimestamps 返回序列头之外的所有tag的时间戳
+func readTestFlvTimestamps(t *testing.T, name string) (list []int64) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pos := 13; pos+11 <= len(data); {
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		ts := int64(data[pos+7])<<24 | int64(data[pos+4])<<16 | int64(data[pos+5])<<8 | int64(data[pos+6])
+		if data[pos] == 9 && data[pos+12] != 0 {
+			list = append(list, ts)
+		}
+		pos += 11 + size + 4
+	}
+	return list
+}
+
+type testLiveServer struct {
+	offline    int32
+	flvCount   int32
+	titleCount int32 // 请求直播间标题的次数
+}
+
+// newTestLiveServer 房间号1000, 真实房间号1001. flvHandler 的 n 是第几次连接直播流
+func newTestLiveServer(t *testing.T, flvHandler func(n int32, w http.ResponseWriter, r *http.Request)) *testLiveServer {
+	s := &testLiveServer{}
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/xlive/web-room/v2/index/getRoomPlayInfo":
+			if atomic.LoadInt32(&s.offline) == 1 {
+				w.Write([]byte(`{"code":0,"data":{"room_id":1001,"uid":5,"live_status":0}}`))
+				return
+			}
+			fmt.Fprintf(w, `{"code":0,"data":{"room_id":1001,"uid":5,"live_status":1,"playurl_info":{"playurl":{"stream":[
+				{"protocol_name":"http_stream","format":[{"format_name":"flv","codec":[{"codec_name":"avc","base_url":"/live.flv","url_info":[{"host":"http://%s","extra":"?expires=1"}]}]}]}
+			]}}}}`, r.Host)
+		case "/room/v1/Room/get_info":
+			atomic.AddInt32(&s.titleCount, 1)
+			w.Write([]byte(`{"code":0,"data":{"title":"测试直播"}}`))
+		case "/live.flv":
+			flvHandler(atomic.AddInt32(&s.flvCount, 1), w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	return s
+}
+
+func setTestLiveRetryDelay(t *testing.T, minDelay time.Duration, maxDelay time.Duration) {
+	oldMin, oldMax := gLiveRetryMinDelay, gLiveRetryMaxDelay
+	gLiveRetryMinDelay, gLiveRetryMaxDelay = minDelay, maxDelay
+	t.Cleanup(func() {
+		gLiveRetryMinDelay, gLiveRetryMaxDelay = oldMin, oldMax
+	})
+}
+
+// TestRecordLive 断线重连: 失败时等待时间翻倍, 有数据后恢复; 重连后时间戳接在之前的数据之后, 下播后结束
+func TestRecordLive(t *testing.T) {
+	setTestLiveRetryDelay(t, time.Millisecond, 4*time.Millisecond)
+	var s *testLiveServer
+	s = newTestLiveServer(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		switch n {
+		case 1:
+			w.Write(newTestLiveFlv(1000, 1040))
+		case 2, 3, 4:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 5:
+			atomic.StoreInt32(&s.offline, 1)
+			w.Write(newTestLiveFlv(5000, 5040))
+		default:
+			t.Errorf("unexpected connection %d", n)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	dir := t.TempDir()
+	r := newTestEventRecorder(t)
+	d := newBilibiliDownloader(BeginDownload_Req{Url: "https://live.bilibili.com/1000", SaveDir: dir})
+	d.sink = r
+	defer d.closeFn()
+
+	result, err := d.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(result.OutName, "live1001_测试直播_") == false || result.Info.Live == nil || result.Info.Live.RoomId != 1001 {
+		t.Fatal(result.OutName, result.Info)
+	}
+	if ts := readTestFlvTimestamps(t, filepath.Join(dir, result.OutName)); reflect.DeepEqual(ts, []int64{0, 40, 41, 81}) == false {
+		t.Fatal(ts)
+	}
+	var delayList []string
+	var ended bool
+	for _, ev := range r.getEventList() {
+		if ev.Type != EventMessage {
+			continue
+		}
+		if idx := strings.LastIndex(ev.Message, ", "); idx >= 0 && strings.HasSuffix(ev.Message, "后重连") {
+			delayList = append(delayList, ev.Message[idx+2:])
+		}
+		ended = ended || ev.Message == "直播已结束"
+	}
+	if reflect.DeepEqual(delayList, []string{"1ms后重连", "2ms后重连", "4ms后重连", "4ms后重连", "1ms后重连"}) == false || ended == false {
+		t.Fatal(delayList, ended)
+	}
+	// 重连时不重新获取标题
+	if n := atomic.LoadInt32(&s.titleCount); n != 1 {
+		t.Fatal(n)
+	}
+	if ev := r.waitEvent(0, EventFinished); ev.OutName != result.OutName {
+		t.Fatal(ev)
+	}
+}
+
+// TestRecordLiveStop 录制中停止是正常结束, 还没有开始写文件时停止是取消
+func TestRecordLiveStop(t *testing.T) {
+	var keyFrame int32 = 1
+	newTestLiveServer(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&keyFrame) == 1 {
+			w.Write(newTestLiveFlv(0))
+		} else {
+			w.Write(newTestLiveFlv())
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	dir := t.TempDir()
+	r := newTestEventRecorder(t)
+	m := NewManager(0, r)
+	taskId := m.AddTask(BeginDownload_Req{Url: "https://live.bilibili.com/1000", SaveDir: dir})
+	r.waitMessage(taskId, "录制到文件")
+	m.StopDownload(taskId)
+	ev := r.waitEvent(taskId, EventFinished)
+	if _, err := os.Stat(filepath.Join(dir, ev.OutName)); err != nil || ev.OutName == "" {
+		t.Fatal(ev, err)
+	}
+
+	atomic.StoreInt32(&keyFrame, 0)
+	taskId = m.AddTask(BeginDownload_Req{Url: "https://live.bilibili.com/1000", SaveDir: dir})
+	r.waitMessage(taskId, "直播间: ")
+	m.StopDownload(taskId)
+	r.waitEvent(taskId, EventCanceled)
+	m.Wait()
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateFinished, TaskStateCanceled}) == false {
+		t.Fatal(got)
+	}
+	for _, ev := range r.getEventList() {
+		if ev.Type == EventError {
+			t.Fatal(ev)
+		}
+	}
+}
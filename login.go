@@ -0,0 +1,248 @@
+package bilibili
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 登录接口的域名, 测试时可以替换成本地服务
+var gPassportHost = "https://passport.bilibili.com"
+
+const _qrcodeGenerateUrlTemp = "%s/x/passport-login/web/qrcode/generate"
+const _qrcodePollUrlTemp = "%s/x/passport-login/web/qrcode/poll?qrcode_key=%s"
+
+// 扫码登录的状态, 即 poll 接口返回的 data.code
+const (
+	LoginStateSuccess     = 0
+	LoginStateWaitScan    = 86101
+	LoginStateWaitConfirm = 86090
+	LoginStateExpired     = 86038
+)
+
+// 查询扫码状态的间隔, 测试时可以缩短
+var gLoginPollInterval = time.Second * 2
+
+// 登录后保存的cookie的名字, poll接口没有返回 Set-Cookie 时从 data.url 中取
+var gLoginCookieNameList = []string{"DedeUserID", "DedeUserID__ckMd5", "SESSDATA", "bili_jct", "sid"}
+
+// gSessionFileFn 返回没有设置cookie时使用的会话文件, 测试时替换掉, 不读取真实的登录信息
+var gSessionFileFn = DefaultSessionFile
+
+// DefaultSessionFile 扫码登录后保存cookie的文件, 和界面的配置文件 ~/.bilibili.json 放在一起.
+// BeginDownload_Req 没有设置 Cookie/CookieFile 时自动使用这个文件
+func DefaultSessionFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".bilibili_session.txt")
+}
+
+// newLoginClient 登录时使用空的cookie, 不能带上已经过期或者损坏的会话文件
+func newLoginClient() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar}
+}
+
+type LoginQrcode struct {
+	Url       string // 二维码的内容
+	QrcodeKey string
+}
+
+// GenerateLoginQrcode 申请一个登录二维码, 有效期180秒
+func GenerateLoginQrcode(ctx context.Context) (qr LoginQrcode, err error) {
+	tmp := newBilibiliDownloaderWithClient(ctx, BeginDownload_Req{}, newLoginClient())
+	defer tmp.closeFn()
+
+	return tmp.generateLoginQrcode()
+}
+
+func (this *BilibiliDownloader) generateLoginQrcode() (qr LoginQrcode, err error) {
+	contents, err := this.defaultFetcher(fmt.Sprintf(_qrcodeGenerateUrlTemp, gPassportHost))
+	if err != nil {
+		return qr, wrapError("GenerateLoginQrcode", err)
+	}
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Url       string `json:"url"`
+			QrcodeKey string `json:"qrcode_key"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &resp)
+	if err != nil {
+		return qr, wrapError("GenerateLoginQrcode_2", err)
+	}
+	if resp.Code != 0 {
+		return qr, apiCodeError("GenerateLoginQrcode", resp.Code, resp.Message)
+	}
+	qr.Url = resp.Data.Url
+	qr.QrcodeKey = resp.Data.QrcodeKey
+	return qr, nil
+}
+
+// PollLoginQrcode 查询一次扫码状态, state 为 LoginStateSuccess 时返回登录后的cookie
+func PollLoginQrcode(ctx context.Context, qrcodeKey string) (state int, cookieList []*http.Cookie, err error) {
+	tmp := newBilibiliDownloaderWithClient(ctx, BeginDownload_Req{}, newLoginClient())
+	defer tmp.closeFn()
+
+	return tmp.pollLoginQrcode(qrcodeKey)
+}
+
+// pollLoginQrcode 需要响应里的 Set-Cookie, 不能使用 defaultFetcher
+func (this *BilibiliDownloader) pollLoginQrcode(qrcodeKey string) (state int, cookieList []*http.Cookie, err error) {
+	request, err := http.NewRequest(http.MethodGet, fmt.Sprintf(_qrcodePollUrlTemp, gPassportHost, url.QueryEscape(qrcodeKey)), nil)
+	if err != nil {
+		return 0, nil, wrapError("PollLoginQrcode", err)
+	}
+	request = request.WithContext(this.ctx)
+	request.Header.Set("User-Agent", userAgent)
+	httpResp, err := this.httpClient.Do(request)
+	if err != nil {
+		return 0, nil, wrapError("PollLoginQrcode", err)
+	}
+	defer httpResp.Body.Close()
+
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Url     string `json:"url"`
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"data"`
+	}
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return 0, nil, wrapError("PollLoginQrcode_2", err)
+	}
+	if resp.Code != 0 {
+		return 0, nil, apiCodeError("PollLoginQrcode", resp.Code, resp.Message)
+	}
+	if resp.Data.Code != LoginStateSuccess {
+		return resp.Data.Code, nil, nil
+	}
+	cookieList = httpResp.Cookies()
+	if len(cookieList) == 0 {
+		cookieList = getCookieListFromLoginUrl(resp.Data.Url)
+	}
+	if len(cookieList) == 0 {
+		return 0, nil, newError("PollLoginQrcode", nil, errors.New("登录成功但没有返回cookie"))
+	}
+	return LoginStateSuccess, cookieList, nil
+}
+
+func getCookieListFromLoginUrl(loginUrl string) (cookieList []*http.Cookie) {
+	u, err := url.Parse(loginUrl)
+	if err != nil {
+		return nil
+	}
+	query := u.Query()
+	expires, _ := strconv.ParseInt(query.Get("Expires"), 10, 64)
+	for _, name := range gLoginCookieNameList {
+		if v := query.Get(name); v != "" {
+			c := &http.Cookie{Name: name, Value: v}
+			if expires > 0 {
+				c.Expires = time.Unix(expires, 0)
+			}
+			cookieList = append(cookieList, c)
+		}
+	}
+	return cookieList
+}
+
+// LoginByQrcode 扫码登录, 成功后把cookie保存到 sessionFile, sessionFile 为空时使用 DefaultSessionFile.
+// onQrcode 用于显示二维码, 二维码过期时返回错误; onState 在扫码状态变化时调用, 可以为nil
+func LoginByQrcode(ctx context.Context, sessionFile string, onQrcode func(qr LoginQrcode), onState func(state int)) error {
+	if sessionFile == "" {
+		sessionFile = DefaultSessionFile()
+	}
+	tmp := newBilibiliDownloaderWithClient(ctx, BeginDownload_Req{}, newLoginClient())
+	defer tmp.closeFn()
+
+	qr, err := tmp.generateLoginQrcode()
+	if err != nil {
+		return err
+	}
+	onQrcode(qr)
+	lastState := -1
+	for {
+		state, cookieList, err := tmp.pollLoginQrcode(qr.QrcodeKey)
+		if err != nil {
+			return err
+		}
+		if state != lastState && onState != nil {
+			onState(state)
+		}
+		lastState = state
+		switch state {
+		case LoginStateSuccess:
+			return SaveSessionFile(sessionFile, cookieList)
+		case LoginStateExpired:
+			return newError("LoginByQrcode", nil, errors.New("二维码已过期"))
+		}
+		select {
+		case <-ctx.Done():
+			return newError("LoginByQrcode", ErrCanceled, ctx.Err())
+		case <-time.After(gLoginPollInterval):
+		}
+	}
+}
+
+// SaveSessionFile 以 Netscape cookies.txt 格式保存, 可以直接作为 BeginDownload_Req.CookieFile 使用
+func SaveSessionFile(sessionFile string, cookieList []*http.Cookie) (err error) {
+	tmpName := sessionFile + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return wrapError("SaveSessionFile", err)
+	}
+	w := bufio.NewWriter(file)
+	w.WriteString("# Netscape HTTP Cookie File\n")
+	for _, c := range cookieList {
+		domain := c.Domain
+		if domain == "" {
+			domain = _defaultCookieDomain
+		}
+		var expires int64
+		if c.Expires.IsZero() == false {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(w, "%s\tTRUE\t/\tFALSE\t%d\t%s\t%s\n", domain, expires, c.Name, c.Value)
+	}
+	err = w.Flush()
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return wrapError("SaveSessionFile", err)
+	}
+	return wrapError("SaveSessionFile", os.Rename(tmpName, sessionFile))
+}
+
+// RenderQrcodeAscii 把二维码渲染为终端里可以扫描的字符画
+func RenderQrcodeAscii(content string) (string, error) {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return "", err
+	}
+	return q.ToSmallString(false), nil
+}
+
+// WriteQrcodePng 把二维码保存为png图片
+func WriteQrcodePng(content string, fileName string) error {
+	return qrcode.WriteFile(content, qrcode.Medium, 256, fileName)
+}
@@ -0,0 +1,142 @@
+package bilibili
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLoginServer poll 接口按顺序返回 stateList 里的状态, 最后一个状态一直重复
+func newTestLoginServer(t *testing.T, stateList []int) {
+	var locker sync.Mutex
+	var pollCount int
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Cookies()) > 0 {
+			w.Write([]byte(`{"code":-400,"message":"登录请求带上了旧的cookie"}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/x/passport-login/web/qrcode/generate":
+			w.Write([]byte(`{"code":0,"data":{"url":"https://account.bilibili.com/h5/account-h5/auth/scan-web?qrcode_key=KEY","qrcode_key":"KEY"}}`))
+		case "/x/passport-login/web/qrcode/poll":
+			if r.URL.Query().Get("qrcode_key") != "KEY" || r.Header.Get("User-Agent") != userAgent {
+				w.Write([]byte(`{"code":-400,"message":"请求错误"}`))
+				return
+			}
+			locker.Lock()
+			state := stateList[len(stateList)-1]
+			if pollCount < len(stateList) {
+				state = stateList[pollCount]
+			}
+			pollCount++
+			locker.Unlock()
+			switch state {
+			case LoginStateSuccess:
+				http.SetCookie(w, &http.Cookie{Name: "SESSDATA", Value: "abc%2C123", Domain: ".bilibili.com", Path: "/"})
+				http.SetCookie(w, &http.Cookie{Name: "DedeUserID", Value: "42", Domain: ".bilibili.com", Path: "/"})
+				w.Write([]byte(`{"code":0,"data":{"url":"https://passport.biligame.com/crossDomain?DedeUserID=42","code":0,"message":""}}`))
+			default:
+				w.Write([]byte(`{"code":0,"data":{"url":"","code":` + strconv.Itoa(state) + `,"message":""}}`))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestPollLoginQrcode(t *testing.T) {
+	newTestLoginServer(t, []int{LoginStateWaitScan, LoginStateWaitConfirm, LoginStateExpired, LoginStateSuccess})
+	ctx := context.Background()
+	qr, err := GenerateLoginQrcode(ctx)
+	if err != nil || qr.QrcodeKey != "KEY" {
+		t.Fatal(qr, err)
+	}
+	for _, want := range []int{LoginStateWaitScan, LoginStateWaitConfirm, LoginStateExpired} {
+		state, cookieList, err := PollLoginQrcode(ctx, qr.QrcodeKey)
+		if err != nil || state != want || cookieList != nil {
+			t.Fatal(state, cookieList, err)
+		}
+	}
+	state, cookieList, err := PollLoginQrcode(ctx, qr.QrcodeKey)
+	if err != nil || state != LoginStateSuccess {
+		t.Fatal(state, err)
+	}
+	var nameList []string
+	for _, c := range cookieList {
+		nameList = append(nameList, c.Name+"="+c.Value)
+	}
+	if reflect.DeepEqual(nameList, []string{"SESSDATA=abc%2C123", "DedeUserID=42"}) == false {
+		t.Fatal(nameList)
+	}
+
+	cancelCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _, err = PollLoginQrcode(cancelCtx, qr.QrcodeKey)
+	if errors.Is(err, context.Canceled) == false {
+		t.Fatal(err)
+	}
+}
+
+func TestLoginByQrcode(t *testing.T) {
+	oldInterval := gLoginPollInterval
+	gLoginPollInterval = time.Millisecond
+	defer func() {
+		gLoginPollInterval = oldInterval
+	}()
+
+	newTestLoginServer(t, []int{LoginStateWaitScan, LoginStateWaitScan, LoginStateWaitConfirm, LoginStateSuccess})
+	sessionFile := filepath.Join(t.TempDir(), "session.txt")
+	// 已有的会话文件过期了, 登录时不能带上
+	err := os.WriteFile(sessionFile, []byte("127.0.0.1\tFALSE\t/\tFALSE\t0\tSESSDATA\tstale\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSessionFileFn := gSessionFileFn
+	gSessionFileFn = func() string { return sessionFile }
+	defer func() {
+		gSessionFileFn = oldSessionFileFn
+	}()
+	var qrUrl string
+	var stateList []int
+	err = LoginByQrcode(context.Background(), sessionFile, func(qr LoginQrcode) {
+		qrUrl = qr.Url
+	}, func(state int) {
+		stateList = append(stateList, state)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(qrUrl, "qrcode_key=KEY") == false {
+		t.Fatal(qrUrl)
+	}
+	// 状态没有变化时不重复通知
+	if reflect.DeepEqual(stateList, []int{LoginStateWaitScan, LoginStateWaitConfirm, LoginStateSuccess}) == false {
+		t.Fatal(stateList)
+	}
+	jar, err := LoadCookieJar("", sessionFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nameList []string
+	for _, c := range jar.Cookies(&url.URL{Scheme: "https", Host: "api.bilibili.com", Path: "/"}) {
+		nameList = append(nameList, c.Name+"="+c.Value)
+	}
+	if reflect.DeepEqual(nameList, []string{"SESSDATA=abc%2C123", "DedeUserID=42"}) == false {
+		t.Fatal(nameList)
+	}
+
+	newTestLoginServer(t, []int{LoginStateWaitScan, LoginStateExpired})
+	err = LoginByQrcode(context.Background(), filepath.Join(t.TempDir(), "expired.txt"), func(qr LoginQrcode) {}, nil)
+	if err == nil || strings.Contains(err.Error(), "二维码已过期") == false {
+		t.Fatal(err)
+	}
+}
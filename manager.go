@@ -0,0 +1,245 @@
+package bilibili
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	TaskStatePending  = "pending"
+	TaskStateRunning  = "running"
+	TaskStateFinished = "finished"
+	TaskStateFailed   = "failed"
+	TaskStateCanceled = "canceled"
+)
+
+// ManagerPrintFnS 和 PrintFnS 相同, 只是每个回调都带上了任务id. 实现了 EventSink, 可以直接传给 NewManager
+type ManagerPrintFnS struct {
+	FnError          func(taskId int64, errMsg string)
+	FnMessage        func(taskId int64, msg string)
+	FnUpdateProgress func(taskId int64, d float64)
+	FnUpdateState    func(taskId int64, state string)
+	FnDownloadFinish func(taskId int64, outMp4File string)
+}
+
+func (this ManagerPrintFnS) OnEvent(ev Event) {
+	var state string
+	switch ev.Type {
+	case EventQueued:
+		state = TaskStatePending
+	case EventStarted:
+		state = TaskStateRunning
+	case EventMessage:
+		if this.FnMessage != nil {
+			this.FnMessage(ev.TaskId, ev.Message)
+		}
+	case EventPartProgress:
+		if this.FnUpdateProgress != nil {
+			this.FnUpdateProgress(ev.TaskId, ev.Progress)
+		}
+	case EventSpeed:
+		if this.FnMessage != nil {
+			vt := "(1)"
+			if ev.IsMultiThread {
+				vt = "(n)"
+			}
+			this.FnMessage(ev.TaskId, "下载速度"+vt+": "+ev.Speed)
+		}
+	case EventError:
+		if this.FnError != nil {
+			this.FnError(ev.TaskId, ev.Message)
+		}
+		state = TaskStateFailed
+	case EventFinished:
+		if this.FnDownloadFinish != nil {
+			this.FnDownloadFinish(ev.TaskId, ev.OutName)
+		}
+		state = TaskStateFinished
+	case EventCanceled:
+		state = TaskStateCanceled
+	}
+	if state != "" && this.FnUpdateState != nil {
+		this.FnUpdateState(ev.TaskId, state)
+	}
+}
+
+type TaskInfo struct {
+	Id    int64
+	Req   BeginDownload_Req
+	State string
+}
+
+// Manager 同时管理多个下载任务, 超过 maxRunning 的任务排队等待
+type Manager struct {
+	locker      sync.Mutex
+	maxRunning  int
+	runningCnt  int
+	nextTaskId  int64
+	taskList    []*managerTask // 按添加顺序保存所有任务
+	pendingList []*managerTask
+	sink        EventSink
+	sinkLocker  sync.Mutex // 多个任务的事件依次发送到sink
+	wg          sync.WaitGroup
+}
+
+type managerTask struct {
+	id         int64
+	req        BeginDownload_Req
+	state      string
+	downloader *BilibiliDownloader
+}
+
+// NewManager maxRunning <= 0 时不限制同时运行的任务数量, 所有任务的事件都带上 TaskId 发送到sink.
+// sink 不会被同时调用, 回调里不能调用 StopDownload/StopAll
+func NewManager(maxRunning int, sink EventSink) *Manager {
+	return &Manager{
+		maxRunning: maxRunning,
+		sink:       sink,
+	}
+}
+
+// AddTask 添加一个下载任务, 返回任务id
+func (this *Manager) AddTask(req BeginDownload_Req) (taskId int64) {
+	this.locker.Lock()
+	this.nextTaskId++
+	task := &managerTask{
+		id:    this.nextTaskId,
+		req:   req,
+		state: TaskStatePending,
+	}
+	this.taskList = append(this.taskList, task)
+	this.pendingList = append(this.pendingList, task)
+	this.wg.Add(1)
+	this.locker.Unlock()
+
+	this.emit(task.id, Event{Type: EventQueued, Url: req.Url})
+	this.schedule()
+	return task.id
+}
+
+// StopDownload 取消一个任务, 排队中的任务直接移除, 运行中的任务会被中断. 直播录制停止后是正常完成的状态
+func (this *Manager) StopDownload(taskId int64) {
+	this.locker.Lock()
+	for idx, task := range this.pendingList {
+		if task.id == taskId {
+			this.pendingList = append(this.pendingList[:idx], this.pendingList[idx+1:]...)
+			task.state = TaskStateCanceled
+			this.locker.Unlock()
+
+			this.emit(taskId, Event{Type: EventCanceled})
+			this.wg.Done()
+			return
+		}
+	}
+	for _, task := range this.taskList {
+		if task.id == taskId && task.downloader != nil {
+			task.downloader.closeFn()
+		}
+	}
+	this.locker.Unlock()
+}
+
+// StopAll 取消所有未结束的任务
+func (this *Manager) StopAll() {
+	for _, one := range this.GetTaskList() {
+		if one.State == TaskStatePending || one.State == TaskStateRunning {
+			this.StopDownload(one.Id)
+		}
+	}
+}
+
+// SetMaxRunning 修改同时运行的任务数量上限, 调大时会立即启动排队中的任务
+func (this *Manager) SetMaxRunning(maxRunning int) {
+	this.locker.Lock()
+	this.maxRunning = maxRunning
+	this.locker.Unlock()
+
+	this.schedule()
+}
+
+func (this *Manager) GetTaskList() (list []TaskInfo) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, task := range this.taskList {
+		list = append(list, TaskInfo{
+			Id:    task.id,
+			Req:   task.req,
+			State: task.state,
+		})
+	}
+	return list
+}
+
+// GetTaskInfo 返回一个任务的状态, 任务不存在时 ok=false
+func (this *Manager) GetTaskInfo(taskId int64) (info TaskInfo, ok bool) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, task := range this.taskList {
+		if task.id == taskId {
+			return TaskInfo{Id: task.id, Req: task.req, State: task.state}, true
+		}
+	}
+	return info, false
+}
+
+// Wait 等待所有已添加的任务结束
+func (this *Manager) Wait() {
+	this.wg.Wait()
+}
+
+func (this *Manager) schedule() {
+	var startList []*managerTask
+
+	this.locker.Lock()
+	for len(this.pendingList) > 0 && (this.maxRunning <= 0 || this.runningCnt < this.maxRunning) {
+		task := this.pendingList[0]
+		this.pendingList = this.pendingList[1:]
+		task.state = TaskStateRunning
+		task.downloader = newBilibiliDownloader(task.req)
+		task.downloader.sink = this.newTaskSink(task.id)
+		this.runningCnt++
+		startList = append(startList, task)
+	}
+	this.locker.Unlock()
+
+	for _, task := range startList {
+		go this.runTask(task)
+	}
+}
+
+func (this *Manager) runTask(task *managerTask) {
+	_, err := task.downloader.Run()
+	state := TaskStateFinished
+	if errors.Is(err, ErrCanceled) {
+		state = TaskStateCanceled
+	} else if err != nil {
+		state = TaskStateFailed
+	}
+	task.downloader.closeFn()
+
+	this.locker.Lock()
+	task.state = state
+	this.runningCnt--
+	this.locker.Unlock()
+
+	this.wg.Done()
+	this.schedule()
+}
+
+func (this *Manager) newTaskSink(taskId int64) EventSink {
+	return EventSinkFunc(func(ev Event) {
+		this.emit(taskId, ev)
+	})
+}
+
+func (this *Manager) emit(taskId int64, ev Event) {
+	if this.sink == nil {
+		return
+	}
+	ev.TaskId = taskId
+	this.sinkLocker.Lock()
+	defer this.sinkLocker.Unlock()
+	this.sink.OnEvent(ev)
+}
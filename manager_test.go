@@ -0,0 +1,354 @@
+package bilibili
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testExtractor 解析 test:// 开头的网址, 一直等到 releaseTestUrl 或者被取消
+type testExtractor struct{}
+
+var gTestUrlLocker sync.Mutex
+var gTestUrlMap = map[string]chan struct{}{}
+
+func getTestUrlCh(url string) chan struct{} {
+	gTestUrlLocker.Lock()
+	defer gTestUrlLocker.Unlock()
+
+	ch, ok := gTestUrlMap[url]
+	if ok == false {
+		ch = make(chan struct{})
+		gTestUrlMap[url] = ch
+	}
+	return ch
+}
+
+func releaseTestUrl(url string) {
+	close(getTestUrlCh(url))
+}
+
+func (testExtractor) Match(url string) bool {
+	return strings.HasPrefix(url, "test://")
+}
+
+func (testExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	select {
+	case <-getTestUrlCh(url):
+		return VideoInfo{Name: strings.TrimPrefix(url, "test://")}, nil
+	case <-ctx.Done():
+		return VideoInfo{}, ctx.Err()
+	}
+}
+
+func init() {
+	RegisterExtractor(testExtractor{})
+}
+
+// testEventRecorder 记录 Manager 的事件, 同时调用 OnEvent 时报错
+type testEventRecorder struct {
+	t      *testing.T
+	inCall int32
+	locker sync.Mutex
+	cond   *sync.Cond
+	evList []Event
+}
+
+func newTestEventRecorder(t *testing.T) *testEventRecorder {
+	r := &testEventRecorder{t: t}
+	r.cond = sync.NewCond(&r.locker)
+	return r
+}
+
+func (this *testEventRecorder) OnEvent(ev Event) {
+	if atomic.AddInt32(&this.inCall, 1) != 1 {
+		this.t.Error("OnEvent called concurrently")
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&this.inCall, -1)
+
+	this.locker.Lock()
+	this.evList = append(this.evList, ev)
+	this.locker.Unlock()
+	this.cond.Broadcast()
+}
+
+func (this *testEventRecorder) getEventList() []Event {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	return append([]Event{}, this.evList...)
+}
+
+// waitEvent 等待一个任务的指定事件
+func (this *testEventRecorder) waitEvent(taskId int64, typ string) Event {
+	this.t.Helper()
+	return this.waitEventFn(fmt.Sprintf("task %d: %s", taskId, typ), func(ev Event) bool {
+		return ev.TaskId == taskId && ev.Type == typ
+	})
+}
+
+// waitMessage 等待一个任务的以 prefix 开头的提示信息
+func (this *testEventRecorder) waitMessage(taskId int64, prefix string) Event {
+	this.t.Helper()
+	return this.waitEventFn(fmt.Sprintf("task %d: %s", taskId, prefix), func(ev Event) bool {
+		return ev.TaskId == taskId && ev.Type == EventMessage && strings.HasPrefix(ev.Message, prefix)
+	})
+}
+
+func (this *testEventRecorder) waitEventFn(desc string, fn func(ev Event) bool) Event {
+	this.t.Helper()
+	timer := time.AfterFunc(5*time.Second, this.cond.Broadcast)
+	defer timer.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+
+	this.locker.Lock()
+	defer this.locker.Unlock()
+	for {
+		for _, ev := range this.evList {
+			if fn(ev) {
+				return ev
+			}
+		}
+		if time.Now().After(deadline) {
+			this.t.Fatalf("timeout waiting %s", desc)
+		}
+		this.cond.Wait()
+	}
+}
+
+func getTestTaskStateList(m *Manager) (list []string) {
+	for _, one := range m.GetTaskList() {
+		list = append(list, one.State)
+	}
+	return list
+}
+
+var gTestUrlId int64
+
+// newTestUrl 每次返回不同的网址, -count 多次运行时也不会重复
+func newTestUrl(t *testing.T, name string) string {
+	return fmt.Sprintf("test://%s/%d/%s", t.Name(), atomic.AddInt64(&gTestUrlId, 1), name)
+}
+
+func TestManagerMaxRunning(t *testing.T) {
+	r := newTestEventRecorder(t)
+	m := NewManager(2, r)
+	var urlList []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		urlList = append(urlList, newTestUrl(t, name))
+		m.AddTask(BeginDownload_Req{Url: urlList[len(urlList)-1]})
+	}
+	r.waitEvent(1, EventStarted)
+	r.waitEvent(2, EventStarted)
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateRunning, TaskStateRunning, TaskStatePending, TaskStatePending}) == false {
+		t.Fatal(got)
+	}
+	// 空出一个位置后, 排队的任务按顺序开始
+	releaseTestUrl(urlList[1])
+	ev := r.waitEvent(2, EventFinished)
+	if ev.OutName != strings.TrimPrefix(urlList[1], "test://") {
+		t.Fatal(ev)
+	}
+	r.waitEvent(3, EventStarted)
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateRunning, TaskStateFinished, TaskStateRunning, TaskStatePending}) == false {
+		t.Fatal(got)
+	}
+	// 调大上限时立即开始
+	m.SetMaxRunning(3)
+	r.waitEvent(4, EventStarted)
+	for _, one := range urlList {
+		if one != urlList[1] {
+			releaseTestUrl(one)
+		}
+	}
+	m.Wait()
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateFinished, TaskStateFinished, TaskStateFinished, TaskStateFinished}) == false {
+		t.Fatal(got)
+	}
+}
+
+func TestManagerStopDownload(t *testing.T) {
+	r := newTestEventRecorder(t)
+	m := NewManager(1, r)
+	lastUrl := newTestUrl(t, "last")
+	running := m.AddTask(BeginDownload_Req{Url: newTestUrl(t, "running")})
+	queued := m.AddTask(BeginDownload_Req{Url: newTestUrl(t, "queued")})
+	last := m.AddTask(BeginDownload_Req{Url: lastUrl})
+	r.waitEvent(running, EventStarted)
+
+	// 排队中的任务直接移除, 不影响正在运行的任务
+	m.StopDownload(queued)
+	r.waitEvent(queued, EventCanceled)
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateRunning, TaskStateCanceled, TaskStatePending}) == false {
+		t.Fatal(got)
+	}
+	// 运行中的任务被中断, 下一个任务开始
+	m.StopDownload(running)
+	r.waitEvent(running, EventCanceled)
+	r.waitEvent(last, EventStarted)
+	info, ok := m.GetTaskInfo(running)
+	if ok == false || info.State != TaskStateCanceled {
+		t.Fatal(info, ok)
+	}
+	releaseTestUrl(lastUrl)
+	m.Wait()
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateCanceled, TaskStateCanceled, TaskStateFinished}) == false {
+		t.Fatal(got)
+	}
+	if _, ok = m.GetTaskInfo(100); ok {
+		t.Fatal("task 100")
+	}
+}
+
+func TestManagerStopAll(t *testing.T) {
+	r := newTestEventRecorder(t)
+	m := NewManager(2, r)
+	for _, name := range []string{"a", "b", "c"} {
+		m.AddTask(BeginDownload_Req{Url: newTestUrl(t, name)})
+	}
+	r.waitEvent(1, EventStarted)
+	r.waitEvent(2, EventStarted)
+	m.StopAll()
+	m.Wait()
+	if got := getTestTaskStateList(m); reflect.DeepEqual(got, []string{TaskStateCanceled, TaskStateCanceled, TaskStateCanceled}) == false {
+		t.Fatal(got)
+	}
+	// 排队中的任务被取消后不会再开始
+	canceledMap := map[int64]bool{}
+	for _, ev := range r.getEventList() {
+		if ev.Type == EventStarted && ev.TaskId == 3 {
+			t.Fatal(ev)
+		}
+		if ev.Type == EventCanceled {
+			canceledMap[ev.TaskId] = true
+		}
+	}
+	if reflect.DeepEqual(canceledMap, map[int64]bool{1: true, 2: true, 3: true}) == false {
+		t.Fatal(canceledMap)
+	}
+}
+
+func TestManagerPrintFnS(t *testing.T) {
+	var got []string
+	fnS := ManagerPrintFnS{
+		FnError: func(taskId int64, errMsg string) {
+			got = append(got, "error "+errMsg)
+		},
+		FnMessage: func(taskId int64, msg string) {
+			got = append(got, "message "+msg)
+		},
+		FnUpdateState: func(taskId int64, state string) {
+			if taskId != 3 {
+				t.Error(taskId)
+			}
+			got = append(got, "state "+state)
+		},
+		FnDownloadFinish: func(taskId int64, outMp4File string) {
+			got = append(got, "finish "+outMp4File)
+		},
+	}
+	var sink EventSink = fnS
+	for _, ev := range []Event{
+		{Type: EventQueued},
+		{Type: EventStarted},
+		{Type: EventMessage, Message: "解析中"},
+		{Type: EventPartProgress, Progress: 0.5}, // FnUpdateProgress 为nil
+		{Type: EventSpeed, Speed: "1MB/s", IsMultiThread: true},
+		{Type: EventFinished, OutName: "a.mp4"},
+		{Type: EventError, Message: "失败"},
+		{Type: EventCanceled},
+	} {
+		ev.TaskId = 3
+		sink.OnEvent(ev)
+	}
+	want := []string{
+		"state pending", "state running", "message 解析中", "message 下载速度(n): 1MB/s",
+		"finish a.mp4", "state finished", "error 失败", "state failed", "state canceled",
+	}
+	if reflect.DeepEqual(got, want) == false {
+		t.Fatal(got)
+	}
+}
+
+// 两个 Manager 使用不同的sink, 只收到自己的任务的事件
+func TestManagerSeparateSink(t *testing.T) {
+	r1, r2 := newTestEventRecorder(t), newTestEventRecorder(t)
+	m1, m2 := NewManager(0, r1), NewManager(0, r2)
+	url1, url2 := newTestUrl(t, "1"), newTestUrl(t, "2")
+	m1.AddTask(BeginDownload_Req{Url: url1})
+	m2.AddTask(BeginDownload_Req{Url: url2})
+	releaseTestUrl(url1)
+	releaseTestUrl(url2)
+	m1.Wait()
+	m2.Wait()
+	for _, one := range []struct {
+		r   *testEventRecorder
+		url string
+	}{
+		{r1, url1},
+		{r2, url2},
+	} {
+		var typeList []string
+		for _, ev := range one.r.getEventList() {
+			if ev.Type == EventMessage {
+				continue
+			}
+			if ev.Url != "" && ev.Url != one.url || ev.OutName != "" && ev.OutName != strings.TrimPrefix(one.url, "test://") {
+				t.Fatal(one.url, ev)
+			}
+			typeList = append(typeList, ev.Type)
+		}
+		if reflect.DeepEqual(typeList, []string{EventQueued, EventStarted, EventResolved, EventFinished}) == false {
+			t.Fatal(one.url, typeList)
+		}
+	}
+}
+
+// BeginDownloadAsync 开始新的下载时不会中断之前的下载
+func TestBeginDownloadAsync(t *testing.T) {
+	var locker sync.Mutex
+	var finishList []string
+	runningCh := make(chan bool, 10)
+	InitPrintFnS(PrintFnS{
+		FnUpdateRunning: func(b bool) {
+			runningCh <- b
+		},
+		FnDownloadFinish: func(outMp4File string) {
+			locker.Lock()
+			finishList = append(finishList, outMp4File)
+			locker.Unlock()
+		},
+	})
+	defer InitPrintFnS(PrintFnS{})
+
+	url1, url2 := newTestUrl(t, "1"), newTestUrl(t, "2")
+	BeginDownloadAsync(BeginDownload_Req{Url: url1})
+	BeginDownloadAsync(BeginDownload_Req{Url: url2})
+	if <-runningCh != true {
+		t.Fatal("running")
+	}
+	releaseTestUrl(url2)
+	releaseTestUrl(url1)
+	if <-runningCh != false {
+		t.Fatal("running")
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	if len(finishList) != 2 {
+		t.Fatal(finishList)
+	}
+
+	// StopDownload 中断所有任务
+	BeginDownloadAsync(BeginDownload_Req{Url: newTestUrl(t, "3")})
+	<-runningCh
+	StopDownload()
+	if <-runningCh != false {
+		t.Fatal("running")
+	}
+}
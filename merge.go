@@ -0,0 +1,193 @@
+package bilibili
+
+import (
+	"github.com/orestonce/bilibili/muxer"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isFlvPart(part VideoPart) bool {
+	return part.StreamType == "" && strings.HasSuffix(part.FileExtWithDot, ".flv")
+}
+
+func isTsPart(part VideoPart) bool {
+	return part.Hls != nil && part.FileExtWithDot == ".ts"
+}
+
+// needMerge dash的音视频流总是需要合并, flv分段在有多段或者需要转换为mp4时才需要合并, ts只在需要转换为mp4时合并
+func (this *BilibiliDownloader) needMerge(info VideoInfo) bool {
+	if info.hasDash() {
+		return true
+	}
+	for _, one := range info.PartList {
+		if isFlvPart(one) {
+			return len(info.PartList) > 1 || this.req.FlvToMp4
+		}
+		if isTsPart(one) {
+			return this.req.FlvToMp4
+		}
+	}
+	return false
+}
+
+// getMergedExt 只有音频流时flac编码输出 .flac, 其它音频输出 .m4a
+func (this *BilibiliDownloader) getMergedExt(info VideoInfo, group string) string {
+	var hasVideo bool
+	var audioCodecs string
+	for _, one := range info.PartList {
+		if one.Group != group {
+			continue
+		}
+		switch one.StreamType {
+		case StreamTypeVideo:
+			hasVideo = true
+		case StreamTypeAudio:
+			audioCodecs = one.Codecs
+		}
+	}
+	if hasVideo == false && audioCodecs != "" {
+		if strings.EqualFold(audioCodecs, muxer.AudioCodecFlac) {
+			return ".flac"
+		}
+		return ".m4a"
+	}
+	if info.hasDash() || this.req.FlvToMp4 {
+		return ".mp4"
+	}
+	return ".flv"
+}
+
+// getMergedOutName 返回一个Group合并后的文件路径, 只有一个Group时直接放在下载目录下
+func (this *BilibiliDownloader) getMergedOutName(info VideoInfo, group string, extWithDot string) string {
+	if len(info.getGroupList()) == 1 {
+		return filepath.Join(this.req.SaveDir, info.Name+extWithDot)
+	}
+	return filepath.Join(this.req.SaveDir, info.Name, group+extWithDot)
+}
+
+// isGroupMerged 合并后的文件已经存在时, 这个Group不需要再下载了
+func (this *BilibiliDownloader) isGroupMerged(info VideoInfo, group string) bool {
+	if this.needMerge(info) == false {
+		return false
+	}
+	_, err := os.Stat(this.getMergedOutName(info, group, this.getMergedExt(info, group)))
+	return err == nil
+}
+
+// mergeGroups 把每个Group的分段/音视频流合并为一个文件, 成功后删除分段文件
+func (this *BilibiliDownloader) mergeGroups(info VideoInfo) (outName string, err error) {
+	groupList := info.getGroupList()
+	for _, group := range groupList {
+		outName = this.getMergedOutName(info, group, this.getMergedExt(info, group))
+		if this.isGroupMerged(info, group) {
+			continue
+		}
+		if this.isCancel() {
+			return "", this.ctx.Err()
+		}
+		var videoName, audioName string
+		var flvList []string
+		var tsName string
+		for _, one := range info.PartList {
+			if one.Group != group {
+				continue
+			}
+			switch {
+			case one.StreamType == StreamTypeVideo:
+				videoName = this.getPartOutName(info, one)
+			case one.StreamType == StreamTypeAudio:
+				audioName = this.getPartOutName(info, one)
+			case isFlvPart(one):
+				flvList = append(flvList, this.getPartOutName(info, one))
+			case isTsPart(one):
+				tsName = this.getPartOutName(info, one)
+			}
+		}
+		if videoName != "" {
+			this.fnMessage("正在合并音视频: " + filepath.Base(outName))
+			err = muxer.MergeDash(videoName, audioName, outName)
+			if err != nil {
+				return "", err
+			}
+			os.Remove(videoName)
+			if audioName != "" {
+				os.Remove(audioName)
+			}
+		} else if audioName != "" {
+			this.fnMessage("正在转换音频: " + filepath.Base(outName))
+			err = muxer.ExtractAudio(audioName, outName, this.getAudioTag(info, group))
+			if err != nil {
+				return "", err
+			}
+			os.Remove(audioName)
+		} else if len(flvList) > 0 {
+			err = this.mergeFlv(flvList, this.getMergedOutName(info, group, ".flv"), outName)
+			if err != nil {
+				return "", err
+			}
+		} else if tsName != "" {
+			this.fnMessage("正在转换为mp4: " + filepath.Base(outName))
+			err = muxer.TsToMp4(tsName, outName)
+			if err != nil {
+				return "", err
+			}
+			os.Remove(tsName)
+		}
+	}
+	if len(groupList) == 1 {
+		os.Remove(filepath.Join(this.req.SaveDir, info.Name)) // 目录为空时才会删除成功
+		return filepath.Base(outName), nil
+	}
+	return info.Name, nil
+}
+
+// mergeFlv 拼接flv分段, ConcatFlv 校验输出文件通过后才删除分段; outName 为mp4时再转换一次
+func (this *BilibiliDownloader) mergeFlv(flvList []string, flvOutName string, outName string) (err error) {
+	if len(flvList) == 1 {
+		if flvList[0] != flvOutName {
+			err = os.Rename(flvList[0], flvOutName)
+		}
+	} else {
+		this.fnMessage("正在合并flv分段: " + filepath.Base(flvOutName))
+		err = muxer.ConcatFlv(flvList, flvOutName)
+		if err == nil {
+			for _, one := range flvList {
+				os.Remove(one)
+			}
+		}
+	}
+	if err != nil || flvOutName == outName {
+		return err
+	}
+	this.fnMessage("正在转换为mp4: " + filepath.Base(outName))
+	err = muxer.FlvToMp4(flvOutName, outName)
+	if err != nil {
+		return err
+	}
+	return os.Remove(flvOutName)
+}
+
+// getAudioTag 多个分P时标题使用分P的标题, 专辑使用视频标题. 封面下载失败时只提示
+func (this *BilibiliDownloader) getAudioTag(info VideoInfo, group string) (tag muxer.AudioTag) {
+	tag.Title = info.Title
+	tag.Artist = info.Author
+	if len(info.getGroupList()) > 1 {
+		tag.Album = info.Title
+		for _, one := range info.PageList {
+			if one.Group == group && one.Title != "" {
+				tag.Title = one.Title
+			}
+		}
+	}
+	if info.CoverUrl != "" {
+		cover, err := this.fetchWithHeader(info.CoverUrl, http.Header{"User-Agent": {userAgent}})
+		if err != nil {
+			this.fnMessage("下载封面失败: " + err.Error())
+		} else {
+			tag.Cover = cover
+		}
+	}
+	return tag
+}
@@ -0,0 +1,257 @@
+package bilibili
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const _archiveTagsUrlTemp = "%s/x/tag/archive/tags?aid=%d"
+
+// viewMeta view接口中生成nfo需要的字段, .info.json 保存接口返回的完整data
+type viewMeta struct {
+	Bvid     string `json:"bvid"`
+	Aid      int64  `json:"aid"`
+	Title    string `json:"title"`
+	Desc     string `json:"desc"`
+	Pic      string `json:"pic"`
+	Pubdate  int64  `json:"pubdate"`
+	Duration int64  `json:"duration"`
+	Tname    string `json:"tname"`
+	Owner    struct {
+		Mid  int64  `json:"mid"`
+		Name string `json:"name"`
+	} `json:"owner"`
+}
+
+// nfoUniqueId <uniqueid type="bilibili" default="true">BVxxx</uniqueid>
+type nfoUniqueId struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// nfoInfo Kodi/Jellyfin 的nfo文件, XMLName 为 movie/tvshow/episodedetails
+type nfoInfo struct {
+	XMLName   xml.Name
+	Title     string      `xml:"title"`
+	ShowTitle string      `xml:"showtitle,omitempty"`
+	Plot      string      `xml:"plot,omitempty"`
+	Season    int         `xml:"season,omitempty"`
+	Episode   int         `xml:"episode,omitempty"`
+	Premiered string      `xml:"premiered,omitempty"`
+	Aired     string      `xml:"aired,omitempty"`
+	Year      int         `xml:"year,omitempty"`
+	Runtime   int64       `xml:"runtime,omitempty"` // 分钟
+	Genre     string      `xml:"genre,omitempty"`
+	TagList   []string    `xml:"tag"`
+	Studio    string      `xml:"studio,omitempty"`
+	Director  string      `xml:"director,omitempty"`
+	Thumb     string      `xml:"thumb,omitempty"`
+	UniqueId  nfoUniqueId `xml:"uniqueid"`
+}
+
+func (this nfoInfo) marshal() []byte {
+	data, _ := xml.MarshalIndent(this, "", "  ")
+	return append([]byte(xml.Header), append(data, '\n')...)
+}
+
+// pgcSeasonMeta 番剧season接口中生成nfo需要的字段, .info.json 保存接口返回的完整result
+type pgcSeasonMeta struct {
+	SeasonId int64  `json:"season_id"`
+	Title    string `json:"title"`
+	Evaluate string `json:"evaluate"`
+	Cover    string `json:"cover"`
+	Publish  struct {
+		PubTime string `json:"pub_time"` // 2006-01-02 15:04:05
+	} `json:"publish"`
+	Episodes []struct {
+		Id      int64 `json:"id"`
+		PubTime int64 `json:"pub_time"`
+	} `json:"episodes"`
+}
+
+// getMetadataSidecarList 根据view接口的返回生成 .info.json/封面/nfo, 在分P都解析完成后调用
+func (this *BilibiliDownloader) getMetadataSidecarList(viewContents []byte, pageList []PageInfo) (list []SidecarFile) {
+	if this.req.Metadata == false {
+		return nil
+	}
+	var view struct {
+		Data json.RawMessage `json:"data"`
+	}
+	var meta viewMeta
+	err := json.Unmarshal(viewContents, &view)
+	if err == nil {
+		err = json.Unmarshal(view.Data, &meta)
+	}
+	if err != nil {
+		this.fnMessage("解析视频元数据失败: " + err.Error())
+		return nil
+	}
+	tagList := this.getArchiveTagList(meta.Aid)
+
+	// 完整的data, 再加上单独获取的标签
+	var full map[string]json.RawMessage
+	var infoJson []byte
+	if json.Unmarshal(view.Data, &full) == nil {
+		full["tags"], _ = json.Marshal(tagList)
+		infoJson = marshalInfoJson(full)
+	}
+	pub := time.Unix(meta.Pubdate, 0)
+	nfo := nfoInfo{
+		Title:     meta.Title,
+		Plot:      meta.Desc,
+		Premiered: pub.Format("2006-01-02"),
+		Year:      pub.Year(),
+		Runtime:   (meta.Duration + 59) / 60,
+		Genre:     meta.Tname,
+		TagList:   tagList,
+		Studio:    "bilibili",
+		Director:  meta.Owner.Name,
+		UniqueId:  nfoUniqueId{Type: "bilibili", Default: true, Value: meta.Bvid},
+	}
+	return buildMetadataSidecarList(infoJson, meta.Pic, nfo, pageList, func(idx int, page PageInfo) nfoInfo {
+		return nfoInfo{
+			Title:    page.Title,
+			Season:   1,
+			Episode:  page.Index,
+			Aired:    nfo.Premiered,
+			Runtime:  (int64(page.Duration) + 59) / 60,
+			Director: meta.Owner.Name,
+			UniqueId: nfoUniqueId{Type: "bilibili", Default: true, Value: fmt.Sprintf("%s_p%d", meta.Bvid, page.Index)},
+		}
+	})
+}
+
+// getPgcMetadataSidecarList 根据番剧season接口的返回生成 .info.json/封面/nfo, epIdList 是 pageList 中每一集的ep_id
+func (this *BilibiliDownloader) getPgcMetadataSidecarList(seasonContents []byte, pageList []PageInfo, epIdList []int64) (list []SidecarFile) {
+	if this.req.Metadata == false {
+		return nil
+	}
+	var season struct {
+		Result json.RawMessage `json:"result"`
+	}
+	var meta pgcSeasonMeta
+	err := json.Unmarshal(seasonContents, &season)
+	if err == nil {
+		err = json.Unmarshal(season.Result, &meta)
+	}
+	if err != nil {
+		this.fnMessage("解析番剧元数据失败: " + err.Error())
+		return nil
+	}
+	var full map[string]json.RawMessage
+	var infoJson []byte
+	if json.Unmarshal(season.Result, &full) == nil {
+		infoJson = marshalInfoJson(full)
+	}
+	epPubTime := map[int64]int64{}
+	for _, ep := range meta.Episodes {
+		epPubTime[ep.Id] = ep.PubTime
+	}
+	nfo := nfoInfo{
+		Title:    meta.Title,
+		Plot:     meta.Evaluate,
+		Studio:   "bilibili",
+		UniqueId: nfoUniqueId{Type: "bilibili", Default: true, Value: fmt.Sprintf("ss%d", meta.SeasonId)},
+	}
+	if pub, err := time.ParseInLocation("2006-01-02 15:04:05", meta.Publish.PubTime, time.Local); err == nil {
+		nfo.Premiered = pub.Format("2006-01-02")
+		nfo.Year = pub.Year()
+	}
+	if len(pageList) == 1 {
+		nfo.Runtime = (int64(pageList[0].Duration) + 59) / 60
+		// 单独下载一集时标题带上剧集名
+		if len(meta.Episodes) > 1 {
+			nfo.Title = strings.TrimSpace(meta.Title + " " + pageList[0].Title)
+			nfo.UniqueId.Value = fmt.Sprintf("ep%d", epIdList[0])
+		}
+	}
+	return buildMetadataSidecarList(infoJson, meta.Cover, nfo, pageList, func(idx int, page PageInfo) nfoInfo {
+		episode := nfoInfo{
+			Title:     page.Title,
+			ShowTitle: meta.Title,
+			Season:    1,
+			Episode:   page.Index,
+			Runtime:   (int64(page.Duration) + 59) / 60,
+			UniqueId:  nfoUniqueId{Type: "bilibili", Default: true, Value: fmt.Sprintf("ep%d", epIdList[idx])},
+		}
+		if pubTime := epPubTime[epIdList[idx]]; pubTime > 0 {
+			episode.Aired = time.Unix(pubTime, 0).Format("2006-01-02")
+		}
+		return episode
+	})
+}
+
+// buildMetadataSidecarList 只有一个分P时nfo为movie, 多个分P时视频目录下保存 tvshow.nfo 和 poster.jpg, 每个分P一个 episodedetails 的nfo
+func buildMetadataSidecarList(infoJson []byte, coverUrl string, nfo nfoInfo, pageList []PageInfo, newEpisode func(idx int, page PageInfo) nfoInfo) (list []SidecarFile) {
+	if infoJson != nil {
+		list = append(list, SidecarFile{Suffix: ".info.json", Kind: SidecarRaw, Data: infoJson})
+	}
+	if strings.HasPrefix(coverUrl, "http://") {
+		coverUrl = "https://" + strings.TrimPrefix(coverUrl, "http://")
+	}
+	if coverUrl != "" {
+		// 封面可能是png/webp, 加上 @.jpg 让图片服务器转换为jpg, 文件名固定使用 .jpg
+		list = append(list, SidecarFile{Suffix: ".jpg", FileName: "poster.jpg", Kind: SidecarRaw, Url: coverUrl + "@.jpg", Header: http.Header{"User-Agent": {userAgent}}})
+	}
+	nfo.Thumb = coverUrl
+	if len(pageList) <= 1 {
+		nfo.XMLName.Local = "movie"
+		return append(list, SidecarFile{Suffix: ".nfo", Kind: SidecarRaw, Data: nfo.marshal()})
+	}
+	nfo.XMLName.Local = "tvshow"
+	nfo.Runtime = 0
+	list = append(list, SidecarFile{Suffix: ".nfo", FileName: "tvshow.nfo", Kind: SidecarRaw, Data: nfo.marshal()})
+	for idx, page := range pageList {
+		episode := newEpisode(idx, page)
+		episode.XMLName.Local = "episodedetails"
+		list = append(list, SidecarFile{Group: page.Group, Suffix: ".nfo", Kind: SidecarRaw, Data: episode.marshal()})
+	}
+	return list
+}
+
+// marshalInfoJson 缩进保存, 不转义html字符
+func marshalInfoJson(v interface{}) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if encoder.Encode(v) != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// getArchiveTagList view接口里没有标签, 失败时只提示
+func (this *BilibiliDownloader) getArchiveTagList(aid int64) (list []string) {
+	list = []string{}
+	contents, err := this.defaultFetcher(fmt.Sprintf(_archiveTagsUrlTemp, gBilibiliApiHost, aid))
+	if err != nil {
+		this.fnMessage("获取视频标签失败: " + err.Error())
+		return list
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    []struct {
+			TagName string `json:"tag_name"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err == nil && tmp.Code != 0 {
+		err = apiCodeError("getArchiveTagList", tmp.Code, tmp.Message)
+	}
+	if err != nil {
+		this.fnMessage("获取视频标签失败: " + err.Error())
+		return list
+	}
+	for _, one := range tmp.Data {
+		list = append(list, one.TagName)
+	}
+	return list
+}
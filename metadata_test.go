@@ -0,0 +1,47 @@
+package bilibili
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetadataCover(t *testing.T) {
+	newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/x/tag/archive/tags" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"code":0,"data":[{"tag_name":"测试"}]}`))
+	})
+	view := []byte(`{"code":0,"data":{"bvid":"BV1xx411c7mD","aid":1,"title":"标题","pic":"http://i0.hdslb.com/bfs/archive/abc.png"}}`)
+	d := newBilibiliDownloader(BeginDownload_Req{Metadata: true})
+	d.sink = gDiscardEventSink
+	for _, pageList := range [][]PageInfo{
+		{{Index: 1, Group: ""}},
+		{{Index: 1, Group: "1_a"}, {Index: 2, Group: "2_b"}},
+	} {
+		var cover *SidecarFile
+		list := d.getMetadataSidecarList(view, pageList)
+		for idx := range list {
+			if list[idx].Url != "" {
+				cover = &list[idx]
+			}
+		}
+		// png封面也转换为jpg保存
+		if cover == nil || cover.Url != "https://i0.hdslb.com/bfs/archive/abc.png@.jpg" || cover.Suffix != ".jpg" || cover.FileName != "poster.jpg" {
+			t.Fatal(len(pageList), cover)
+		}
+		info := VideoInfo{Name: "BV1xx411c7mD_标题"}
+		want := "BV1xx411c7mD_标题.jpg"
+		if len(pageList) > 1 {
+			for _, page := range pageList {
+				info.PartList = append(info.PartList, VideoPart{Group: page.Group})
+			}
+			want = filepath.Join("BV1xx411c7mD_标题", "poster.jpg")
+		}
+		if got := d.getSidecarOutName(info, *cover); got != want {
+			t.Fatal(got)
+		}
+	}
+}
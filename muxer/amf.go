@@ -0,0 +1,244 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// amfValue 只保留 onMetaData 需要的几种类型, 其它类型原样保存
+type amfValue struct {
+	typ    byte
+	number float64
+	bool   bool
+	str    string
+	object []amfProperty // object / ecma array
+	array  []amfValue    // strict array
+	raw    []byte        // date 等不需要修改的类型
+}
+
+type amfProperty struct {
+	key   string
+	value amfValue
+}
+
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfEcmaArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0a
+	amfDate        = 0x0b
+	amfLongString  = 0x0c
+)
+
+var errAmfFormat = errors.New("amf format error")
+
+type amfReader struct {
+	data []byte
+	pos  int
+}
+
+func (this *amfReader) next(n int) ([]byte, error) {
+	if this.pos+n > len(this.data) {
+		return nil, errAmfFormat
+	}
+	b := this.data[this.pos : this.pos+n]
+	this.pos += n
+	return b, nil
+}
+
+func (this *amfReader) readString(long bool) (string, error) {
+	var n int
+	if long {
+		b, err := this.next(4)
+		if err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint32(b))
+	} else {
+		b, err := this.next(2)
+		if err != nil {
+			return "", err
+		}
+		n = int(binary.BigEndian.Uint16(b))
+	}
+	b, err := this.next(n)
+	return string(b), err
+}
+
+func (this *amfReader) readProperties() (list []amfProperty, err error) {
+	for {
+		key, err := this.readString(false)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" && this.pos < len(this.data) && this.data[this.pos] == amfObjectEnd {
+			this.pos++
+			return list, nil
+		}
+		v, err := this.readValue()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, amfProperty{key: key, value: v})
+	}
+}
+
+func (this *amfReader) readValue() (v amfValue, err error) {
+	b, err := this.next(1)
+	if err != nil {
+		return v, err
+	}
+	v.typ = b[0]
+	switch v.typ {
+	case amfNumber:
+		b, err = this.next(8)
+		if err != nil {
+			return v, err
+		}
+		v.number = math.Float64frombits(binary.BigEndian.Uint64(b))
+	case amfBoolean:
+		b, err = this.next(1)
+		if err != nil {
+			return v, err
+		}
+		v.bool = b[0] != 0
+	case amfString, amfLongString:
+		v.str, err = this.readString(v.typ == amfLongString)
+	case amfObject:
+		v.object, err = this.readProperties()
+	case amfEcmaArray:
+		_, err = this.next(4) // 数量不可靠, 以结束标记为准
+		if err != nil {
+			return v, err
+		}
+		v.object, err = this.readProperties()
+	case amfStrictArray:
+		b, err = this.next(4)
+		if err != nil {
+			return v, err
+		}
+		count := binary.BigEndian.Uint32(b)
+		for i := uint32(0); i < count; i++ {
+			one, err := this.readValue()
+			if err != nil {
+				return v, err
+			}
+			v.array = append(v.array, one)
+		}
+	case amfDate:
+		v.raw, err = this.next(10)
+	case amfNull, amfUndefined:
+	default:
+		return v, errAmfFormat
+	}
+	return v, err
+}
+
+func writeAmfString(buf *bytes.Buffer, s string) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(len(s)))
+	buf.Write(b[:])
+	buf.WriteString(s)
+}
+
+func writeAmfValue(buf *bytes.Buffer, v amfValue) {
+	buf.WriteByte(v.typ)
+	var b [8]byte
+	switch v.typ {
+	case amfNumber:
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v.number))
+		buf.Write(b[:])
+	case amfBoolean:
+		if v.bool {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case amfString:
+		writeAmfString(buf, v.str)
+	case amfLongString:
+		binary.BigEndian.PutUint32(b[:4], uint32(len(v.str)))
+		buf.Write(b[:4])
+		buf.WriteString(v.str)
+	case amfObject, amfEcmaArray:
+		if v.typ == amfEcmaArray {
+			binary.BigEndian.PutUint32(b[:4], uint32(len(v.object)))
+			buf.Write(b[:4])
+		}
+		for _, one := range v.object {
+			writeAmfString(buf, one.key)
+			writeAmfValue(buf, one.value)
+		}
+		buf.Write([]byte{0, 0, amfObjectEnd})
+	case amfStrictArray:
+		binary.BigEndian.PutUint32(b[:4], uint32(len(v.array)))
+		buf.Write(b[:4])
+		for _, one := range v.array {
+			writeAmfValue(buf, one)
+		}
+	case amfDate:
+		buf.Write(v.raw)
+	}
+}
+
+func (this amfValue) get(key string) (v amfValue, ok bool) {
+	for _, one := range this.object {
+		if one.key == key {
+			return one.value, true
+		}
+	}
+	return v, false
+}
+
+func (this *amfValue) set(key string, v amfValue) {
+	for idx, one := range this.object {
+		if one.key == key {
+			this.object[idx].value = v
+			return
+		}
+	}
+	this.object = append(this.object, amfProperty{key: key, value: v})
+}
+
+func (this *amfValue) remove(key string) {
+	for idx, one := range this.object {
+		if one.key == key {
+			this.object = append(this.object[:idx], this.object[idx+1:]...)
+			return
+		}
+	}
+}
+
+// parseOnMetaData 解析script tag的数据, 返回 onMetaData 的内容
+func parseOnMetaData(data []byte) (meta amfValue, err error) {
+	r := &amfReader{data: data}
+	name, err := r.readValue()
+	if err != nil {
+		return meta, err
+	}
+	if name.typ != amfString || name.str != "onMetaData" {
+		return meta, errors.New("not onMetaData")
+	}
+	meta, err = r.readValue()
+	if err != nil {
+		return meta, err
+	}
+	if meta.typ != amfEcmaArray && meta.typ != amfObject {
+		return meta, errAmfFormat
+	}
+	return meta, nil
+}
+
+func encodeOnMetaData(meta amfValue) []byte {
+	var buf bytes.Buffer
+	writeAmfValue(&buf, amfValue{typ: amfString, str: "onMetaData"})
+	writeAmfValue(&buf, meta)
+	return buf.Bytes()
+}
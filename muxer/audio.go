@@ -0,0 +1,271 @@
+package muxer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AudioTag 写入音频文件的标签, Cover 为jpg或png图片, 为空时不写封面
+type AudioTag struct {
+	Title  string
+	Artist string
+	Album  string
+	Cover  []byte
+}
+
+// 音频 sample entry 的类型
+const (
+	AudioCodecAac  = "mp4a"
+	AudioCodecFlac = "fLaC"
+	AudioCodecEac3 = "ec-3"
+)
+
+// ExtractAudio 把dash下载的音频m4s转换为普通的音频文件.
+// outFile 以 .flac 结尾时输出flac文件, 音频必须是flac编码; 否则输出m4a(aac/杜比音频)
+func ExtractAudio(audioFile string, outFile string, tag AudioTag) (err error) {
+	file, err := os.Open(audioFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	trackList, err := readFragmentedMp4(file, info.Size())
+	if err != nil {
+		return err
+	}
+	var t *track
+	for _, one := range trackList {
+		if one.handler == handlerAudio {
+			t = one
+			break
+		}
+	}
+	if t == nil {
+		return errors.New("ExtractAudio no audio track")
+	}
+	if strings.EqualFold(filepath.Ext(outFile), ".flac") {
+		return writeFlacFile(outFile, t, tag)
+	}
+	opt := mp4Options{
+		majorBrand: "M4A ",
+		compatible: []string{"M4A ", "mp42", "isom"},
+		udta:       buildItunesUdta(tag),
+	}
+	return writeMp4File(outFile, []*track{t}, opt)
+}
+
+// buildItunesUdta udta/meta/ilst 格式的标签, 大多数播放器都能识别
+func buildItunesUdta(tag AudioTag) []byte {
+	w := &boxWriter{}
+	w.begin("udta")
+	w.beginFull("meta", 0, 0)
+	w.beginFull("hdlr", 0, 0)
+	w.u32(0)
+	w.write([]byte("mdir"))
+	w.write([]byte("appl"))
+	w.zero(9)
+	w.end()
+	w.begin("ilst")
+	for _, one := range []struct {
+		typ   string
+		value string
+	}{
+		{"\xa9nam", tag.Title},
+		{"\xa9ART", tag.Artist},
+		{"\xa9alb", tag.Album},
+	} {
+		if one.value != "" {
+			writeIlstData(w, one.typ, 1, []byte(one.value)) // 1: utf8
+		}
+	}
+	if len(tag.Cover) > 0 {
+		var dataType uint32 = 13 // jpeg
+		if isPng(tag.Cover) {
+			dataType = 14
+		}
+		writeIlstData(w, "covr", dataType, tag.Cover)
+	}
+	w.end() // ilst
+	w.end() // meta
+	w.end() // udta
+	return w.Bytes()
+}
+
+func writeIlstData(w *boxWriter, typ string, dataType uint32, value []byte) {
+	w.begin(typ)
+	w.begin("data")
+	w.u32(dataType)
+	w.u32(0) // locale
+	w.write(value)
+	w.end()
+	w.end()
+}
+
+func isPng(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\x89PNG"))
+}
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+)
+
+// getFlacMetadata 从 fLaC sample entry 的 dfLa box 里取出flac的元数据块(至少有STREAMINFO)
+func getFlacMetadata(stsd []byte) (blockList []byte, err error) {
+	if getSampleEntryType(stsd) != AudioCodecFlac {
+		return nil, errors.New("getFlacMetadata not flac")
+	}
+	entrySize := int(binary.BigEndian.Uint32(stsd[16:]))
+	if 16+entrySize > len(stsd) {
+		return nil, errors.New("getFlacMetadata invalid stsd")
+	}
+	entry := stsd[16 : 16+entrySize]
+	// AudioSampleEntry 的固定部分是36字节, 后面是子box
+	for pos := 36; pos+8 <= len(entry); {
+		size := int(binary.BigEndian.Uint32(entry[pos:]))
+		if size < 8 || pos+size > len(entry) {
+			break
+		}
+		if string(entry[pos+4:pos+8]) == "dfLa" && size > 12 {
+			return entry[pos+12 : pos+size], nil
+		}
+		pos += size
+	}
+	return nil, errors.New("getFlacMetadata dfLa not found")
+}
+
+// writeFlacFile 把mp4里的flac帧原样写出, 元数据块后面追加 VORBIS_COMMENT 和 PICTURE
+func writeFlacFile(outFile string, t *track, tag AudioTag) (err error) {
+	metadata, err := getFlacMetadata(t.stsd)
+	if err != nil {
+		return err
+	}
+	tmpName := outFile + ".merging"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	err = writeFlac(file, t, metadata, tag)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, outFile)
+}
+
+func writeFlac(w io.Writer, t *track, metadata []byte, tag AudioTag) (err error) {
+	type flacBlock struct {
+		typ  byte
+		data []byte
+	}
+	var blockList []flacBlock
+	for pos := 0; pos+4 <= len(metadata); {
+		typ := metadata[pos] & 0x7f
+		size := int(metadata[pos+1])<<16 | int(metadata[pos+2])<<8 | int(metadata[pos+3])
+		if pos+4+size > len(metadata) {
+			return errors.New("writeFlac invalid metadata")
+		}
+		// 原有的标签和图片使用 tag 里的代替
+		if typ != flacBlockVorbisComment && typ != flacBlockPicture {
+			blockList = append(blockList, flacBlock{typ: typ, data: metadata[pos+4 : pos+4+size]})
+		}
+		pos += 4 + size
+	}
+	if len(blockList) == 0 || blockList[0].typ != flacBlockStreamInfo {
+		return errors.New("writeFlac STREAMINFO not found")
+	}
+	blockList = append(blockList, flacBlock{typ: flacBlockVorbisComment, data: buildVorbisComment(tag)})
+	if len(tag.Cover) > 0 && len(tag.Cover) < 1<<24-1024 {
+		blockList = append(blockList, flacBlock{typ: flacBlockPicture, data: buildFlacPicture(tag.Cover)})
+	}
+
+	bw := bufio.NewWriterSize(w, 1024*1024)
+	bw.WriteString("fLaC")
+	for idx, one := range blockList {
+		header := one.typ
+		if idx == len(blockList)-1 {
+			header |= 0x80 // last-metadata-block
+		}
+		size := len(one.data)
+		bw.Write([]byte{header, byte(size >> 16), byte(size >> 8), byte(size)})
+		bw.Write(one.data)
+	}
+	var buf []byte
+	for _, one := range t.sampleList {
+		if cap(buf) < int(one.size) {
+			buf = make([]byte, one.size)
+		}
+		buf = buf[:one.size]
+		_, err = t.src.ReadAt(buf, one.offset)
+		if err != nil {
+			return err
+		}
+		_, err = bw.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// buildVorbisComment 长度都是小端
+func buildVorbisComment(tag AudioTag) []byte {
+	var buf bytes.Buffer
+	writeString := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	writeString("bilibili")
+	var list []string
+	for _, one := range []struct {
+		key   string
+		value string
+	}{
+		{"TITLE", tag.Title},
+		{"ARTIST", tag.Artist},
+		{"ALBUM", tag.Album},
+	} {
+		if one.value != "" {
+			list = append(list, one.key+"="+one.value)
+		}
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(len(list)))
+	for _, one := range list {
+		writeString(one)
+	}
+	return buf.Bytes()
+}
+
+// buildFlacPicture 图片类型3(封面), 宽高等信息可以为0
+func buildFlacPicture(cover []byte) []byte {
+	mime := "image/jpeg"
+	if isPng(cover) {
+		mime = "image/png"
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(3))
+	binary.Write(&buf, binary.BigEndian, uint32(len(mime)))
+	buf.WriteString(mime)
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // description
+	buf.Write(make([]byte, 16))                     // width height depth colors
+	binary.Write(&buf, binary.BigEndian, uint32(len(cover)))
+	buf.Write(cover)
+	return buf.Bytes()
+}
@@ -0,0 +1,215 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testStreamInfo = bytes.Repeat([]byte{0x12}, 34)
+
+// buildTestAudioStsd 构造一个sample entry, 子box放在固定的36字节后面
+func buildTestAudioStsd(typ string, child func(w *boxWriter)) []byte {
+	w := &boxWriter{}
+	w.beginFull("stsd", 0, 0)
+	w.u32(1)
+	w.begin(typ)
+	w.zero(6)
+	w.u16(1) // data_reference_index
+	w.zero(8)
+	w.u16(2)  // channelcount
+	w.u16(16) // samplesize
+	w.zero(4)
+	w.u32(48000 << 16)
+	if child != nil {
+		child(w)
+	}
+	w.end()
+	w.end()
+	return w.Bytes()
+}
+
+// buildTestFlacStsd dfLa 里有 STREAMINFO 和一个原有的 VORBIS_COMMENT
+func buildTestFlacStsd() []byte {
+	return buildTestAudioStsd(AudioCodecFlac, func(w *boxWriter) {
+		w.beginFull("dfLa", 0, 0)
+		w.write([]byte{flacBlockStreamInfo, 0, 0, 34})
+		w.write(testStreamInfo)
+		w.write([]byte{0x80 | flacBlockVorbisComment, 0, 0, 3})
+		w.write([]byte("old"))
+		w.end()
+	})
+}
+
+func writeTestAudio(t *testing.T, stsd []byte) (inFile string, tt testTrack) {
+	tt = testTrack{
+		trackId:   1,
+		handler:   handlerAudio,
+		timescale: 48000,
+		stsd:      stsd,
+		fragList:  [][]testSample{newTestSampleList(3, 4608, 0x40, nil), newTestSampleList(2, 4608, 0x50, nil)},
+	}
+	inFile = filepath.Join(t.TempDir(), "audio.m4s")
+	err := os.WriteFile(inFile, buildTestFmp4(tt), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inFile, tt
+}
+
+func TestExtractAudioFlac(t *testing.T) {
+	inFile, tt := writeTestAudio(t, buildTestFlacStsd())
+	outFile := filepath.Join(filepath.Dir(inFile), "audio.flac")
+	cover := []byte("\x89PNG\r\n\x1a\ncover")
+	err := ExtractAudio(inFile, outFile, AudioTag{Title: "标题", Artist: "作者", Cover: cover})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(data, []byte("fLaC")) == false {
+		t.Fatal(data[:4])
+	}
+	// 原有的 VORBIS_COMMENT 被替换, 最后一个是 PICTURE
+	var typList []byte
+	var vorbis, picture []byte
+	pos := 4
+	for {
+		header := data[pos]
+		size := int(data[pos+1])<<16 | int(data[pos+2])<<8 | int(data[pos+3])
+		block := data[pos+4 : pos+4+size]
+		typList = append(typList, header&0x7f)
+		switch header & 0x7f {
+		case flacBlockStreamInfo:
+			if bytes.Equal(block, testStreamInfo) == false {
+				t.Fatal("STREAMINFO", block)
+			}
+		case flacBlockVorbisComment:
+			vorbis = block
+		case flacBlockPicture:
+			picture = block
+		}
+		pos += 4 + size
+		if header&0x80 != 0 {
+			break
+		}
+	}
+	if bytes.Equal(typList, []byte{flacBlockStreamInfo, flacBlockVorbisComment, flacBlockPicture}) == false {
+		t.Fatal(typList)
+	}
+	if bytes.Contains(vorbis, []byte("TITLE=标题")) == false || bytes.Contains(vorbis, []byte("ARTIST=作者")) == false ||
+		bytes.Contains(vorbis, []byte("ALBUM=")) || binary.LittleEndian.Uint32(vorbis[4+8:]) != 2 {
+		t.Fatal("VORBIS_COMMENT", vorbis)
+	}
+	if binary.BigEndian.Uint32(picture) != 3 || bytes.Contains(picture, []byte("image/png")) == false || bytes.HasSuffix(picture, cover) == false {
+		t.Fatal("PICTURE", picture)
+	}
+	// 元数据后面是原样的flac帧
+	var frames []byte
+	for _, frag := range tt.fragList {
+		for _, one := range frag {
+			frames = append(frames, one.data...)
+		}
+	}
+	if bytes.Equal(data[pos:], frames) == false {
+		t.Fatal("frames", data[pos:])
+	}
+	if _, err = os.Stat(outFile + ".merging"); err == nil {
+		t.Fatal("tmp file left")
+	}
+}
+
+func TestExtractAudioM4a(t *testing.T) {
+	stsd := buildTestAudioStsd(AudioCodecAac, nil)
+	inFile, _ := writeTestAudio(t, stsd)
+	outFile := filepath.Join(filepath.Dir(inFile), "audio.m4a")
+	cover := []byte("\xff\xd8\xffcover")
+	err := ExtractAudio(inFile, outFile, AudioTag{Title: "标题", Album: "专辑", Cover: cover})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(outFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	topList, err := readChildren(r, 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ftyp, ok := findTestBox(r, topList, "ftyp")
+	if ok == false || string(ftyp[:4]) != "M4A " {
+		t.Fatal("ftyp", ftyp)
+	}
+	moov, _ := findChild(topList, "moov")
+	moovList, err := readChildren(r, moov.bodyOffset(), moov.end())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sample entry 原样保留
+	body, ok := findTestBox(r, moovList, "trak", "mdia", "minf", "stbl", "stsd")
+	if ok == false || bytes.Equal(body, stsd[8:]) == false {
+		t.Fatal("stsd", body)
+	}
+	body, ok = findTestBox(r, moovList, "trak", "mdia", "minf", "stbl", "stsz")
+	if ok == false || binary.BigEndian.Uint32(body[8:]) != 5 {
+		t.Fatal("stsz", body)
+	}
+	meta, ok := findTestBox(r, moovList, "udta", "meta")
+	if ok == false {
+		t.Fatal("udta/meta not found")
+	}
+	// meta 是full box, 子box从第4个字节开始
+	metaReader := bytes.NewReader(meta)
+	metaList, err := readChildren(metaReader, 4, int64(len(meta)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ilst, ok := findTestBox(metaReader, metaList, "ilst")
+	if ok == false {
+		t.Fatal("ilst not found")
+	}
+	ilstReader := bytes.NewReader(ilst)
+	itemList, err := readChildren(ilstReader, 0, int64(len(ilst)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typList []string
+	for _, one := range itemList {
+		typList = append(typList, one.typ)
+	}
+	if strings.Join(typList, ",") != "\xa9nam,\xa9alb,covr" {
+		t.Fatalf("%q", typList)
+	}
+	for _, one := range []struct {
+		typ      string
+		dataType uint32
+		value    []byte
+	}{
+		{"\xa9nam", 1, []byte("标题")},
+		{"\xa9alb", 1, []byte("专辑")},
+		{"covr", 13, cover},
+	} {
+		body, ok := findTestBox(ilstReader, itemList, one.typ, "data")
+		if ok == false || binary.BigEndian.Uint32(body) != one.dataType || bytes.Equal(body[8:], one.value) == false {
+			t.Fatalf("%q %v", one.typ, body)
+		}
+	}
+}
+
+func TestExtractAudioFlacError(t *testing.T) {
+	inFile, _ := writeTestAudio(t, buildTestAudioStsd(AudioCodecAac, nil))
+	outFile := filepath.Join(filepath.Dir(inFile), "audio.flac")
+	err := ExtractAudio(inFile, outFile, AudioTag{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = os.Stat(outFile); err == nil {
+		t.Fatal("out file created")
+	}
+}
@@ -0,0 +1,147 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type boxHeader struct {
+	typ        string
+	offset     int64 // box 在文件中的起始位置
+	headerSize int64
+	size       int64 // 包含header的总大小
+}
+
+func (this boxHeader) bodyOffset() int64 {
+	return this.offset + this.headerSize
+}
+
+func (this boxHeader) end() int64 {
+	return this.offset + this.size
+}
+
+func readBoxHeader(r io.ReaderAt, offset int64, end int64) (h boxHeader, err error) {
+	var buf [16]byte
+	_, err = r.ReadAt(buf[:8], offset)
+	if err != nil {
+		return h, err
+	}
+	h.offset = offset
+	h.typ = string(buf[4:8])
+	h.headerSize = 8
+	h.size = int64(binary.BigEndian.Uint32(buf[:4]))
+	switch h.size {
+	case 0: // 直到文件末尾
+		h.size = end - offset
+	case 1:
+		_, err = r.ReadAt(buf[8:16], offset+8)
+		if err != nil {
+			return h, err
+		}
+		h.headerSize = 16
+		h.size = int64(binary.BigEndian.Uint64(buf[8:16]))
+	}
+	// 64位的size可能很大, 不能用 offset+h.size 比较, 会溢出
+	if h.size < h.headerSize || h.size > end-offset {
+		return h, errors.New("invalid box size " + h.typ)
+	}
+	return h, nil
+}
+
+func readChildren(r io.ReaderAt, offset int64, end int64) (list []boxHeader, err error) {
+	for offset+8 <= end {
+		h, err := readBoxHeader(r, offset, end)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+		offset = h.end()
+	}
+	return list, nil
+}
+
+func findChild(list []boxHeader, typ string) (h boxHeader, ok bool) {
+	for _, one := range list {
+		if one.typ == typ {
+			return one, true
+		}
+	}
+	return h, false
+}
+
+func readBoxBody(r io.ReaderAt, h boxHeader) ([]byte, error) {
+	buf := make([]byte, h.size-h.headerSize)
+	_, err := r.ReadAt(buf, h.bodyOffset())
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func readBoxAll(r io.ReaderAt, h boxHeader) ([]byte, error) {
+	buf := make([]byte, h.size)
+	_, err := r.ReadAt(buf, h.offset)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// boxWriter 在内存中构造嵌套的box, end时回填box大小
+type boxWriter struct {
+	buf   bytes.Buffer
+	stack []int
+}
+
+func (this *boxWriter) begin(typ string) {
+	this.stack = append(this.stack, this.buf.Len())
+	this.u32(0)
+	this.buf.WriteString(typ)
+}
+
+func (this *boxWriter) beginFull(typ string, version uint8, flags uint32) {
+	this.begin(typ)
+	this.u32(uint32(version)<<24 | flags&0xffffff)
+}
+
+func (this *boxWriter) end() {
+	start := this.stack[len(this.stack)-1]
+	this.stack = this.stack[:len(this.stack)-1]
+	binary.BigEndian.PutUint32(this.buf.Bytes()[start:], uint32(this.buf.Len()-start))
+}
+
+func (this *boxWriter) u8(v uint8) {
+	this.buf.WriteByte(v)
+}
+
+func (this *boxWriter) u16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	this.buf.Write(b[:])
+}
+
+func (this *boxWriter) u32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	this.buf.Write(b[:])
+}
+
+func (this *boxWriter) u64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	this.buf.Write(b[:])
+}
+
+func (this *boxWriter) write(b []byte) {
+	this.buf.Write(b)
+}
+
+func (this *boxWriter) zero(n int) {
+	this.buf.Write(make([]byte, n))
+}
+
+func (this *boxWriter) Bytes() []byte {
+	return this.buf.Bytes()
+}
@@ -0,0 +1,280 @@
+package muxer
+
+import (
+	"errors"
+)
+
+var aacSampleRateList = []uint32{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+type aacConfig struct {
+	objectType   uint8
+	sampleRate   uint32
+	channelCount uint16
+	frameLength  uint32 // 每帧的采样数, 1024 或者 960
+}
+
+// parseAudioSpecificConfig 只解析 objectType/采样率/声道数/每帧的采样数
+func parseAudioSpecificConfig(asc []byte) (cfg aacConfig, err error) {
+	if len(asc) < 2 {
+		return cfg, errors.New("invalid AudioSpecificConfig")
+	}
+	br := &bitReader{data: asc}
+	cfg.objectType = uint8(br.read(5))
+	idx := br.read(4)
+	if idx == 0x0f {
+		cfg.sampleRate = br.read(24)
+	} else if int(idx) < len(aacSampleRateList) {
+		cfg.sampleRate = aacSampleRateList[idx]
+	}
+	cfg.channelCount = uint16(br.read(4))
+	if br.err != nil || cfg.sampleRate == 0 {
+		return cfg, errors.New("invalid AudioSpecificConfig")
+	}
+	cfg.frameLength = 1024
+	objectType := cfg.objectType
+	if objectType == 5 || objectType == 29 { // 显式的SBR/PS, 后面是扩展采样率和真正的objectType
+		if br.read(4) == 0x0f {
+			br.read(24)
+		}
+		objectType = uint8(br.read(5))
+	}
+	switch objectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23: // GASpecificConfig 的 frameLengthFlag
+		if br.read(1) == 1 && br.err == nil {
+			cfg.frameLength = 960
+		}
+	}
+	return cfg, nil
+}
+
+func writeStsdHeader(w *boxWriter) {
+	w.beginFull("stsd", 0, 0)
+	w.u32(1)
+}
+
+func writeVisualSampleEntryHeader(w *boxWriter, typ string, width uint16, height uint16) {
+	w.begin(typ)
+	w.zero(6)
+	w.u16(1) // data_reference_index
+	w.zero(16)
+	w.u16(width)
+	w.u16(height)
+	w.u32(0x00480000)
+	w.u32(0x00480000)
+	w.u32(0)
+	w.u16(1) // frame_count
+	w.zero(32)
+	w.u16(0x0018)
+	w.u16(0xffff)
+}
+
+// buildVideoStsd typ 为 avc1/hvc1, configType 为 avcC/hvcC
+func buildVideoStsd(typ string, configType string, config []byte, width uint16, height uint16) []byte {
+	w := &boxWriter{}
+	writeStsdHeader(w)
+	writeVisualSampleEntryHeader(w, typ, width, height)
+	w.begin(configType)
+	w.write(config)
+	w.end()
+	w.end()
+	w.end()
+	return w.Bytes()
+}
+
+func writeAudioSampleEntryHeader(w *boxWriter, typ string, channelCount uint16, sampleRate uint32) {
+	w.begin(typ)
+	w.zero(6)
+	w.u16(1) // data_reference_index
+	w.zero(8)
+	w.u16(channelCount)
+	w.u16(16)
+	w.u32(0)
+	if sampleRate > 0xffff { // 16.16 定点数放不下时按规范写0
+		w.u32(0)
+	} else {
+		w.u32(sampleRate << 16)
+	}
+}
+
+func writeDescriptor(w *boxWriter, tag uint8, size int) {
+	w.u8(tag)
+	w.u8(0x80 | uint8(size>>21&0x7f))
+	w.u8(0x80 | uint8(size>>14&0x7f))
+	w.u8(0x80 | uint8(size>>7&0x7f))
+	w.u8(uint8(size & 0x7f))
+}
+
+func buildAacStsd(asc []byte) (stsd []byte, cfg aacConfig, err error) {
+	cfg, err = parseAudioSpecificConfig(asc)
+	if err != nil {
+		return nil, cfg, err
+	}
+	w := &boxWriter{}
+	writeStsdHeader(w)
+	writeAudioSampleEntryHeader(w, "mp4a", cfg.channelCount, cfg.sampleRate)
+	w.beginFull("esds", 0, 0)
+	decSpecificSize := 5 + len(asc)
+	decConfigSize := 13 + decSpecificSize
+	esSize := 3 + 5 + decConfigSize + 5 + 1
+	writeDescriptor(w, 0x03, esSize)
+	w.u16(1) // ES_ID
+	w.u8(0)
+	writeDescriptor(w, 0x04, decConfigSize)
+	w.u8(0x40) // Audio ISO/IEC 14496-3
+	w.u8(0x15) // AudioStream
+	w.zero(3)  // bufferSizeDB
+	w.u32(0)   // maxBitrate
+	w.u32(0)   // avgBitrate
+	writeDescriptor(w, 0x05, len(asc))
+	w.write(asc)
+	writeDescriptor(w, 0x06, 1)
+	w.u8(0x02)
+	w.end()
+	w.end()
+	w.end()
+	return w.Bytes(), cfg, nil
+}
+
+type bitReader struct {
+	data []byte
+	pos  int // bit
+	err  error
+}
+
+func (this *bitReader) read(n int) uint32 {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if this.pos >= len(this.data)*8 {
+			this.err = errors.New("bitReader eof")
+			return 0
+		}
+		bit := this.data[this.pos/8] >> (7 - uint(this.pos%8)) & 1
+		v = v<<1 | uint32(bit)
+		this.pos++
+	}
+	return v
+}
+
+func (this *bitReader) readUe() uint32 {
+	zeros := 0
+	for this.read(1) == 0 && this.err == nil && zeros < 32 {
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + this.read(zeros)
+}
+
+func (this *bitReader) readSe() int32 {
+	v := this.readUe()
+	if v&1 == 1 {
+		return int32((v + 1) / 2)
+	}
+	return -int32(v / 2)
+}
+
+// removeEmulationPrevention 去掉nalu中的 0x000003 防竞争字节
+func removeEmulationPrevention(nalu []byte) []byte {
+	out := make([]byte, 0, len(nalu))
+	for i := 0; i < len(nalu); i++ {
+		if i >= 2 && nalu[i] == 3 && nalu[i-1] == 0 && nalu[i-2] == 0 {
+			continue
+		}
+		out = append(out, nalu[i])
+	}
+	return out
+}
+
+// parseAvcSpsSize 从h264的sps中解析出视频宽高
+func parseAvcSpsSize(sps []byte) (width uint16, height uint16, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errors.New("invalid sps")
+	}
+	br := &bitReader{data: removeEmulationPrevention(sps[1:])}
+	profileIdc := br.read(8)
+	br.read(16) // constraint flags + level
+	br.readUe() // seq_parameter_set_id
+	chromaFormatIdc := uint32(1)
+	switch profileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIdc = br.readUe()
+		if chromaFormatIdc == 3 {
+			br.read(1)
+		}
+		br.readUe() // bit_depth_luma_minus8
+		br.readUe() // bit_depth_chroma_minus8
+		br.read(1)
+		if br.read(1) == 1 { // seq_scaling_matrix_present_flag
+			count := 8
+			if chromaFormatIdc == 3 {
+				count = 12
+			}
+			for i := 0; i < count; i++ {
+				if br.read(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						next = (last + br.readSe() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	br.readUe() // log2_max_frame_num_minus4
+	pocType := br.readUe()
+	if pocType == 0 {
+		br.readUe()
+	} else if pocType == 1 {
+		br.read(1)
+		br.readSe()
+		br.readSe()
+		n := br.readUe()
+		for i := uint32(0); i < n && br.err == nil; i++ {
+			br.readSe()
+		}
+	}
+	br.readUe() // max_num_ref_frames
+	br.read(1)
+	widthInMbs := br.readUe() + 1
+	heightInMapUnits := br.readUe() + 1
+	frameMbsOnly := br.read(1)
+	if frameMbsOnly == 0 {
+		br.read(1)
+	}
+	br.read(1) // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom uint32
+	if br.read(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = br.readUe(), br.readUe(), br.readUe(), br.readUe()
+	}
+	if br.err != nil {
+		return 0, 0, br.err
+	}
+	cropUnitX, cropUnitY := uint32(1), 2-frameMbsOnly
+	if chromaFormatIdc == 1 {
+		cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+	} else if chromaFormatIdc == 2 {
+		cropUnitX, cropUnitY = 2, 2-frameMbsOnly
+	}
+	w := widthInMbs*16 - (cropLeft+cropRight)*cropUnitX
+	h := (2-frameMbsOnly)*heightInMapUnits*16 - (cropTop+cropBottom)*cropUnitY
+	return uint16(w), uint16(h), nil
+}
+
+// getAvcSpsFromConfig 取出 AVCDecoderConfigurationRecord 中的第一个sps
+func getAvcSpsFromConfig(config []byte) []byte {
+	if len(config) < 8 || config[5]&0x1f == 0 {
+		return nil
+	}
+	size := int(config[6])<<8 | int(config[7])
+	if 8+size > len(config) {
+		return nil
+	}
+	return config[8 : 8+size]
+}
@@ -0,0 +1,56 @@
+package muxer
+
+import (
+	"errors"
+	"os"
+)
+
+// MergeDash 把dash下载的视频m4s和音频m4s合并为一个普通的mp4文件, audioFile 为空时只转换视频
+func MergeDash(videoFile string, audioFile string, outFile string) (err error) {
+	var trackList []*track
+	for _, name := range []string{videoFile, audioFile} {
+		if name == "" {
+			continue
+		}
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		list, err := readFragmentedMp4(file, info.Size())
+		if err != nil {
+			return err
+		}
+		trackList = append(trackList, list...)
+	}
+	if len(trackList) == 0 {
+		return errors.New("MergeDash no track")
+	}
+	return writeMp4File(outFile, trackList, defaultMp4Options)
+}
+
+// writeMp4File 先写入临时文件, 成功后再改名, 避免留下不完整的输出
+func writeMp4File(outFile string, trackList []*track, opt mp4Options) (err error) {
+	tmpName := outFile + ".merging"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	err = writeMp4(file, trackList, opt)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, outFile)
+}
@@ -0,0 +1,323 @@
+package muxer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	flvTagAudio  = 8
+	flvTagVideo  = 9
+	flvTagScript = 18
+)
+
+const flvTagHeaderSize = 11
+
+type flvTag struct {
+	typ        byte
+	timestamp  int64 // 毫秒
+	dataOffset int64
+	dataSize   int64
+	head       []byte // data的前几个字节, 用于判断编码和包类型
+}
+
+func (this flvTag) isSequenceHeader() bool {
+	if len(this.head) < 2 {
+		return false
+	}
+	switch this.typ {
+	case flvTagVideo:
+		codecId := this.head[0] & 0x0f
+		return (codecId == flvCodecAvc || codecId == flvCodecHevc) && this.head[1] == 0
+	case flvTagAudio:
+		return this.head[0]>>4 == flvSoundAac && this.head[1] == 0
+	}
+	return false
+}
+
+const (
+	flvCodecAvc  = 7
+	flvCodecHevc = 12
+	flvSoundAac  = 10
+)
+
+type flvFile struct {
+	header  []byte
+	tagList []flvTag
+	size    int64
+}
+
+// readFlv 扫描flv文件的所有tag, 只读取tag头和少量数据
+func readFlv(r io.ReaderAt, fileSize int64) (f *flvFile, err error) {
+	var buf [flvTagHeaderSize]byte
+	_, err = r.ReadAt(buf[:9], 0)
+	if err != nil {
+		return nil, err
+	}
+	if string(buf[:3]) != "FLV" {
+		return nil, errors.New("readFlv not flv file")
+	}
+	f = &flvFile{
+		header: append([]byte{}, buf[:9]...),
+		size:   fileSize,
+	}
+	offset := int64(binary.BigEndian.Uint32(buf[5:9])) + 4 // 跳过 PreviousTagSize0
+	for offset+flvTagHeaderSize <= fileSize {
+		_, err = r.ReadAt(buf[:], offset)
+		if err != nil {
+			return nil, err
+		}
+		tag := flvTag{
+			typ:        buf[0] & 0x1f,
+			dataSize:   int64(buf[1])<<16 | int64(buf[2])<<8 | int64(buf[3]),
+			timestamp:  int64(buf[7])<<24 | int64(buf[4])<<16 | int64(buf[5])<<8 | int64(buf[6]),
+			dataOffset: offset + flvTagHeaderSize,
+		}
+		if tag.dataOffset+tag.dataSize > fileSize { // 文件被截断, 丢弃最后一个不完整的tag
+			break
+		}
+		headSize := tag.dataSize
+		if headSize > 5 {
+			headSize = 5
+		}
+		tag.head = make([]byte, headSize)
+		_, err = r.ReadAt(tag.head, tag.dataOffset)
+		if err != nil {
+			return nil, err
+		}
+		f.tagList = append(f.tagList, tag)
+		offset = tag.dataOffset + tag.dataSize + 4
+	}
+	return f, nil
+}
+
+func readFlvTagData(r io.ReaderAt, tag flvTag) ([]byte, error) {
+	buf := make([]byte, tag.dataSize)
+	_, err := r.ReadAt(buf, tag.dataOffset)
+	return buf, err
+}
+
+type flvOutTag struct {
+	tag       flvTag
+	src       io.ReaderAt
+	timestamp int64
+}
+
+// ConcatFlv 把分段的flv按顺序拼接为一个连续的flv, 重写时间戳并修正 onMetaData 里的 duration/filesize
+// 输出文件写完后会重新扫描校验, 校验通过才返回nil
+func ConcatFlv(inFileList []string, outFile string) (err error) {
+	if len(inFileList) == 0 {
+		return errors.New("ConcatFlv no input")
+	}
+	var outList []flvOutTag
+	var header []byte
+	var meta amfValue
+	var hasMeta bool
+	var lastSeqHeader = map[byte][]byte{}
+	var offset int64
+
+	for _, name := range inFileList {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		f, err := readFlv(file, info.Size())
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			header = f.header
+		}
+		// 序列头的时间戳一般是0, 不能作为分段的开始时间
+		var segStart int64 = -1
+		for _, tag := range f.tagList {
+			if tag.typ != flvTagScript && tag.isSequenceHeader() == false && (segStart < 0 || tag.timestamp < segStart) {
+				segStart = tag.timestamp
+			}
+		}
+		if segStart < 0 {
+			segStart = 0
+		}
+		var maxTs, lastVideoTs, frameGap int64 = offset, -1, 0
+		for _, tag := range f.tagList {
+			switch tag.typ {
+			case flvTagScript:
+				if hasMeta == false {
+					data, err := readFlvTagData(file, tag)
+					if err != nil {
+						return err
+					}
+					meta, err = parseOnMetaData(data)
+					hasMeta = err == nil
+				}
+				continue
+			case flvTagAudio, flvTagVideo:
+			default:
+				continue
+			}
+			if tag.isSequenceHeader() { // 每个分段都带有相同的序列头, 只保留变化了的
+				data, err := readFlvTagData(file, tag)
+				if err != nil {
+					return err
+				}
+				if bytes.Equal(lastSeqHeader[tag.typ], data) {
+					continue
+				}
+				lastSeqHeader[tag.typ] = data
+			}
+			ts := tag.timestamp - segStart + offset
+			if ts < offset {
+				ts = offset
+			}
+			if tag.typ == flvTagVideo {
+				if lastVideoTs >= 0 && ts > lastVideoTs {
+					frameGap = ts - lastVideoTs
+				}
+				lastVideoTs = ts
+			}
+			if ts > maxTs {
+				maxTs = ts
+			}
+			outList = append(outList, flvOutTag{tag: tag, src: file, timestamp: ts})
+		}
+		if frameGap <= 0 {
+			frameGap = 1
+		}
+		offset = maxTs + frameGap
+	}
+
+	if hasMeta == false {
+		meta = amfValue{typ: amfEcmaArray}
+	}
+	meta.remove("keyframes") // 原来的关键帧位置已经不正确了
+	meta.set("duration", amfValue{typ: amfNumber, number: float64(offset) / 1000})
+	meta.set("filesize", amfValue{typ: amfNumber})
+	metaData := encodeOnMetaData(meta)
+
+	totalSize := int64(9+4) + flvTagHeaderSize + int64(len(metaData)) + 4
+	for _, one := range outList {
+		totalSize += flvTagHeaderSize + one.tag.dataSize + 4
+	}
+	meta.set("filesize", amfValue{typ: amfNumber, number: float64(totalSize)})
+	metaData = encodeOnMetaData(meta)
+
+	err = writeFlvFile(outFile, header, metaData, outList)
+	if err != nil {
+		return err
+	}
+	return verifyFlv(outFile, totalSize, metaData, outList)
+}
+
+func writeFlvFile(outFile string, header []byte, metaData []byte, outList []flvOutTag) (err error) {
+	tmpName := outFile + ".merging"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+	err = writeFlv(file, header, metaData, outList)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, outFile)
+}
+
+func writeFlv(w io.Writer, header []byte, metaData []byte, outList []flvOutTag) (err error) {
+	bw := bufio.NewWriterSize(w, 1024*1024)
+	h := append([]byte{}, header[:5]...)
+	h = append(h, 0, 0, 0, 9, 0, 0, 0, 0)
+	bw.Write(h)
+	if metaData != nil {
+		writeFlvTag(bw, flvTagScript, 0, metaData)
+	}
+	var buf []byte
+	for _, one := range outList {
+		if cap(buf) < int(one.tag.dataSize) {
+			buf = make([]byte, one.tag.dataSize)
+		}
+		buf = buf[:one.tag.dataSize]
+		_, err = one.src.ReadAt(buf, one.tag.dataOffset)
+		if err != nil {
+			return err
+		}
+		err = writeFlvTag(bw, one.tag.typ, one.timestamp, buf)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func writeFlvTag(w io.Writer, typ byte, timestamp int64, data []byte) error {
+	var h [flvTagHeaderSize]byte
+	size := len(data)
+	h[0] = typ
+	h[1], h[2], h[3] = byte(size>>16), byte(size>>8), byte(size)
+	h[4], h[5], h[6], h[7] = byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24)
+	_, err := w.Write(h[:])
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	if err != nil {
+		return err
+	}
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], uint32(size+flvTagHeaderSize))
+	_, err = w.Write(tail[:])
+	return err
+}
+
+// verifyFlv 检查输出文件的大小, 以及每个tag的类型/时间戳/大小/开头的数据是否和要写入的一致
+func verifyFlv(name string, expectSize int64, metaData []byte, outList []flvOutTag) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != expectSize {
+		return errors.New("verifyFlv file size mismatch")
+	}
+	f, err := readFlv(file, info.Size())
+	if err != nil {
+		return err
+	}
+	tagList := f.tagList
+	if metaData != nil {
+		if len(tagList) == 0 || tagList[0].typ != flvTagScript || tagList[0].dataSize != int64(len(metaData)) {
+			return errors.New("verifyFlv onMetaData mismatch")
+		}
+		tagList = tagList[1:]
+	}
+	if len(tagList) != len(outList) {
+		return errors.New("verifyFlv tag count mismatch")
+	}
+	for idx, tag := range tagList {
+		want := outList[idx]
+		if tag.typ != want.tag.typ || tag.timestamp != want.timestamp || tag.dataSize != want.tag.dataSize || bytes.Equal(tag.head, want.tag.head) == false {
+			return fmt.Errorf("verifyFlv tag %d mismatch", idx)
+		}
+	}
+	return nil
+}
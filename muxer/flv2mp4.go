@@ -0,0 +1,173 @@
+package muxer
+
+import (
+	"errors"
+	"os"
+)
+
+// FlvToMp4 把 h264/h265 + aac 的flv转换为mp4, 不重新编码
+func FlvToMp4(inFile string, outFile string) (err error) {
+	file, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	f, err := readFlv(file, info.Size())
+	if err != nil {
+		return err
+	}
+	video := &track{handler: handlerVideo, timescale: 1000, src: file}
+	audio := &track{handler: handlerAudio, src: file}
+	var videoDtsList []int64
+	var width, height uint16
+	var videoCodecId byte
+	var audioFrameLength uint32
+
+	for _, tag := range f.tagList {
+		switch tag.typ {
+		case flvTagScript:
+			data, err := readFlvTagData(file, tag)
+			if err != nil {
+				return err
+			}
+			if meta, err := parseOnMetaData(data); err == nil {
+				if v, ok := meta.get("width"); ok && v.typ == amfNumber {
+					width = uint16(v.number)
+				}
+				if v, ok := meta.get("height"); ok && v.typ == amfNumber {
+					height = uint16(v.number)
+				}
+			}
+		case flvTagVideo:
+			if len(tag.head) < 5 {
+				continue
+			}
+			frameType, codecId := tag.head[0]>>4, tag.head[0]&0x0f
+			if codecId != flvCodecAvc && codecId != flvCodecHevc {
+				return errors.New("FlvToMp4 unsupported video codec")
+			}
+			if frameType == 5 { // 视频信息/命令帧
+				continue
+			}
+			switch tag.head[1] {
+			case 0:
+				if video.stsd != nil {
+					continue
+				}
+				data, err := readFlvTagData(file, tag)
+				if err != nil {
+					return err
+				}
+				videoCodecId = codecId
+				video.stsd = buildFlvVideoStsd(codecId, data[5:], width, height)
+			case 1:
+				cts := int32(tag.head[2])<<16 | int32(tag.head[3])<<8 | int32(tag.head[4])
+				if cts&0x800000 != 0 {
+					cts -= 1 << 24
+				}
+				video.sampleList = append(video.sampleList, sample{
+					offset:    tag.dataOffset + 5,
+					size:      uint32(tag.dataSize - 5),
+					ctsOffset: cts,
+					isSync:    frameType == 1,
+				})
+				videoDtsList = append(videoDtsList, tag.timestamp)
+			}
+		case flvTagAudio:
+			if len(tag.head) < 2 {
+				continue
+			}
+			if tag.head[0]>>4 != flvSoundAac {
+				return errors.New("FlvToMp4 unsupported audio codec")
+			}
+			if tag.head[1] == 0 {
+				if audio.stsd != nil {
+					continue
+				}
+				data, err := readFlvTagData(file, tag)
+				if err != nil {
+					return err
+				}
+				stsd, cfg, err := buildAacStsd(data[2:])
+				if err != nil {
+					return err
+				}
+				audio.stsd = stsd
+				audio.timescale = cfg.sampleRate
+				audioFrameLength = cfg.frameLength
+				continue
+			}
+			if audio.stsd == nil {
+				continue
+			}
+			if len(audio.sampleList) == 0 {
+				audio.startDts = tag.timestamp * int64(audio.timescale) / 1000
+			}
+			audio.sampleList = append(audio.sampleList, sample{
+				offset:   tag.dataOffset + 2,
+				size:     uint32(tag.dataSize - 2),
+				duration: audioFrameLength,
+				isSync:   true,
+			})
+		}
+	}
+	if video.stsd != nil && width == 0 && videoCodecId == flvCodecAvc {
+		// onMetaData 里没有宽高, 从sps里解析
+		video.stsd = nil
+		for _, tag := range f.tagList {
+			if tag.typ != flvTagVideo || tag.isSequenceHeader() == false {
+				continue
+			}
+			data, err := readFlvTagData(file, tag)
+			if err != nil {
+				return err
+			}
+			width, height, _ = parseAvcSpsSize(getAvcSpsFromConfig(data[5:]))
+			video.stsd = buildFlvVideoStsd(videoCodecId, data[5:], width, height)
+			break
+		}
+	}
+	video.width, video.height = uint32(width)<<16, uint32(height)<<16
+	setDurationByDts(video, videoDtsList, 40)
+
+	var trackList []*track
+	for _, t := range []*track{video, audio} {
+		if t.stsd != nil && len(t.sampleList) > 0 {
+			trackList = append(trackList, t)
+		}
+	}
+	if len(trackList) == 0 {
+		return errors.New("FlvToMp4 no track")
+	}
+	return writeMp4File(outFile, trackList, defaultMp4Options)
+}
+
+func buildFlvVideoStsd(codecId byte, config []byte, width uint16, height uint16) []byte {
+	if codecId == flvCodecHevc {
+		return buildVideoStsd("hvc1", "hvcC", config, width, height)
+	}
+	return buildVideoStsd("avc1", "avcC", config, width, height)
+}
+
+// setDurationByDts 用相邻两个sample的解码时间差作为时长, 最后一个sample沿用前一个的时长
+func setDurationByDts(t *track, dtsList []int64, defaultDuration uint32) {
+	if len(dtsList) == 0 {
+		return
+	}
+	t.startDts = dtsList[0]
+	last := defaultDuration
+	for i := range t.sampleList {
+		if i+1 < len(dtsList) {
+			d := dtsList[i+1] - dtsList[i]
+			if d < 0 {
+				d = 0
+			}
+			last = uint32(d)
+		}
+		t.sampleList[i].duration = last
+	}
+}
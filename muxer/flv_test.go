@@ -0,0 +1,330 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testFlvTag struct {
+	typ  byte
+	ts   int64
+	data []byte
+}
+
+var (
+	testFlvAvcSeq = testFlvTag{typ: flvTagVideo, data: []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}}
+	testFlvAacSeq = testFlvTag{typ: flvTagAudio, data: []byte{0xaf, 0, 0x11, 0x90}} // AAC-LC 48000Hz 双声道
+)
+
+// testFlvVideo n 用于区分每一帧的数据
+func testFlvVideo(ts int64, key bool, n byte) testFlvTag {
+	var frameType byte = 0x27
+	if key {
+		frameType = 0x17
+	}
+	return testFlvTag{typ: flvTagVideo, ts: ts, data: []byte{frameType, 1, 0, 0, 0, n, n}}
+}
+
+func testFlvAudio(ts int64, n byte) testFlvTag {
+	return testFlvTag{typ: flvTagAudio, ts: ts, data: []byte{0xaf, 1, n, n}}
+}
+
+func newTestFlvMeta(width float64, height float64) []byte {
+	meta := amfValue{typ: amfEcmaArray}
+	meta.set("duration", amfValue{typ: amfNumber, number: 10})
+	meta.set("width", amfValue{typ: amfNumber, number: width})
+	meta.set("height", amfValue{typ: amfNumber, number: height})
+	meta.set("filesize", amfValue{typ: amfNumber, number: 999})
+	meta.set("keyframes", amfValue{typ: amfNumber, number: 1})
+	return encodeOnMetaData(meta)
+}
+
+func buildTestFlv(metaData []byte, tagList []testFlvTag) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{'F', 'L', 'V', 1, 5, 0, 0, 0, 9, 0, 0, 0, 0})
+	if metaData != nil {
+		writeFlvTag(&buf, flvTagScript, 0, metaData)
+	}
+	for _, one := range tagList {
+		writeFlvTag(&buf, one.typ, one.ts, one.data)
+	}
+	return buf.Bytes()
+}
+
+func writeTestFlv(t *testing.T, name string, metaData []byte, tagList []testFlvTag) {
+	err := os.WriteFile(name, buildTestFlv(metaData, tagList), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFlv 返回 onMetaData 和其他的tag
+func readTestFlv(t *testing.T, name string) (meta amfValue, tagList []testFlvTag) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(data)
+	f, err := readFlv(r, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range f.tagList {
+		body, err := readFlvTagData(r, tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag.typ == flvTagScript {
+			meta, err = parseOnMetaData(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		tagList = append(tagList, testFlvTag{typ: tag.typ, ts: tag.timestamp, data: body})
+	}
+	return meta, tagList
+}
+
+func getTestAmfNumber(t *testing.T, meta amfValue, key string) float64 {
+	v, ok := meta.get(key)
+	if ok == false || v.typ != amfNumber {
+		t.Fatal(key, "not found")
+	}
+	return v.number
+}
+
+func TestConcatFlv(t *testing.T) {
+	dir := t.TempDir()
+	in1 := filepath.Join(dir, "1.flv")
+	in2 := filepath.Join(dir, "2.flv")
+	out := filepath.Join(dir, "out.flv")
+	// 每个分段的时间戳都不是从0开始, 都带有相同的序列头
+	writeTestFlv(t, in1, newTestFlvMeta(640, 360), []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(1000, true, 1), testFlvAudio(1000, 2), testFlvAudio(1023, 3),
+		testFlvVideo(1040, false, 4), testFlvAudio(1046, 5), testFlvVideo(1080, false, 6),
+	})
+	writeTestFlv(t, in2, newTestFlvMeta(640, 360), []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(5000, true, 7), testFlvAudio(5000, 8), testFlvVideo(5040, false, 9),
+	})
+	err := ConcatFlv([]string{in1, in2}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, tagList := readTestFlv(t, out)
+	// 第二个分段接在第一个分段最后一帧加一帧间隔之后
+	want := []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(0, true, 1), testFlvAudio(0, 2), testFlvAudio(23, 3),
+		testFlvVideo(40, false, 4), testFlvAudio(46, 5), testFlvVideo(80, false, 6),
+		testFlvVideo(120, true, 7), testFlvAudio(120, 8), testFlvVideo(160, false, 9),
+	}
+	if reflect.DeepEqual(tagList, want) == false {
+		t.Fatal(tagList)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if getTestAmfNumber(t, meta, "duration") != 0.2 || getTestAmfNumber(t, meta, "filesize") != float64(info.Size()) || getTestAmfNumber(t, meta, "width") != 640 {
+		t.Fatal(meta)
+	}
+	if _, ok := meta.get("keyframes"); ok {
+		t.Fatal("keyframes")
+	}
+
+	// 序列头变化时保留新的序列头
+	newSeq := testFlvTag{typ: flvTagVideo, data: []byte{0x17, 0, 0, 0, 0, 1, 0x4d, 0, 0x1f, 0xff}}
+	writeTestFlv(t, in2, nil, []testFlvTag{newSeq, testFlvVideo(0, true, 7)})
+	err = ConcatFlv([]string{in1, in2}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tagList = readTestFlv(t, out)
+	if len(tagList) != 10 || reflect.DeepEqual(tagList[8], testFlvTag{typ: flvTagVideo, ts: 120, data: newSeq.data}) == false {
+		t.Fatal(tagList)
+	}
+
+	err = ConcatFlv([]string{filepath.Join(dir, "not_exist.flv")}, out)
+	if os.IsNotExist(err) == false {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFlv(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.flv")
+	metaData := newTestFlvMeta(640, 360)
+	data := buildTestFlv(metaData, []testFlvTag{testFlvVideo(0, true, 1), testFlvVideo(40, false, 2)})
+	err := os.WriteFile(name, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := bytes.NewReader(data)
+	f, err := readFlv(src, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outList := []flvOutTag{
+		{tag: f.tagList[1], src: src, timestamp: 0},
+		{tag: f.tagList[2], src: src, timestamp: 40},
+	}
+	err = verifyFlv(name, int64(len(data)), metaData, outList)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 大小和数量都一样, 但是时间戳或者数据不一样
+	outList[1].timestamp = 41
+	if verifyFlv(name, int64(len(data)), metaData, outList) == nil {
+		t.Fatal("timestamp")
+	}
+	outList[1].timestamp = 40
+	outList[0], outList[1] = outList[1], outList[0]
+	outList[0].timestamp, outList[1].timestamp = 0, 40
+	if verifyFlv(name, int64(len(data)), metaData, outList) == nil {
+		t.Fatal("data")
+	}
+}
+
+type testMp4Trak struct {
+	r    *bytes.Reader
+	list []boxHeader
+}
+
+func readTestMp4Traks(t *testing.T, data []byte) (trakList []testMp4Trak) {
+	r := bytes.NewReader(data)
+	topList, err := readChildren(r, 0, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	moov, ok := findChild(topList, "moov")
+	if ok == false {
+		t.Fatal("moov not found")
+	}
+	moovList, err := readChildren(r, moov.bodyOffset(), moov.end())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, one := range moovList {
+		if one.typ != "trak" {
+			continue
+		}
+		list, err := readChildren(r, one.bodyOffset(), one.end())
+		if err != nil {
+			t.Fatal(err)
+		}
+		trakList = append(trakList, testMp4Trak{r: r, list: list})
+	}
+	return trakList
+}
+
+func (this testMp4Trak) box(t *testing.T, path ...string) []byte {
+	body, ok := findTestBox(this.r, this.list, path...)
+	if ok == false {
+		t.Fatal(path, "not found")
+	}
+	return body
+}
+
+func (this testMp4Trak) stbl(t *testing.T, typ string) []byte {
+	return this.box(t, "mdia", "minf", "stbl", typ)
+}
+
+// sampleDurationList 展开 stts
+func (this testMp4Trak) sampleDurationList(t *testing.T) (list []uint32) {
+	body := this.stbl(t, "stts")
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	for i := 0; i < count; i++ {
+		for n := binary.BigEndian.Uint32(body[8+i*8:]); n > 0; n-- {
+			list = append(list, binary.BigEndian.Uint32(body[12+i*8:]))
+		}
+	}
+	return list
+}
+
+func TestFlvToMp4(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.flv")
+	out := filepath.Join(dir, "out.mp4")
+	// 960个采样一帧的AAC: frameLengthFlag=1
+	aacSeq := testFlvTag{typ: flvTagAudio, data: []byte{0xaf, 0, 0x11, 0x94}}
+	writeTestFlv(t, in, newTestFlvMeta(640, 360), []testFlvTag{
+		testFlvAvcSeq, aacSeq,
+		testFlvVideo(0, true, 1), testFlvAudio(0, 2), testFlvAudio(20, 3),
+		testFlvVideo(40, false, 4), testFlvAudio(40, 5), testFlvVideo(80, false, 6),
+	})
+	err := FlvToMp4(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trakList := readTestMp4Traks(t, data)
+	if len(trakList) != 2 {
+		t.Fatal(len(trakList))
+	}
+	video, audio := trakList[0], trakList[1]
+	tkhd := video.box(t, "tkhd")
+	if binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) != 640<<16 || binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) != 360<<16 {
+		t.Fatal("video size", tkhd[len(tkhd)-8:])
+	}
+	if strings.Contains(string(video.stbl(t, "stsd")), "avcC") == false {
+		t.Fatal("no avcC")
+	}
+	if list := video.sampleDurationList(t); reflect.DeepEqual(list, []uint32{40, 40, 40}) == false {
+		t.Fatal("video duration", list)
+	}
+	stss := video.stbl(t, "stss")
+	if binary.BigEndian.Uint32(stss[4:]) != 1 || binary.BigEndian.Uint32(stss[8:]) != 1 {
+		t.Fatal("stss", stss)
+	}
+	mdhd := audio.box(t, "mdia", "mdhd")
+	if binary.BigEndian.Uint32(mdhd[20:]) != 48000 {
+		t.Fatal("audio timescale", binary.BigEndian.Uint32(mdhd[20:]))
+	}
+	if list := audio.sampleDurationList(t); reflect.DeepEqual(list, []uint32{960, 960, 960}) == false {
+		t.Fatal("audio duration", list)
+	}
+	// 第一个音频帧的数据, 去掉了flv的2字节音频头
+	stco := audio.stbl(t, "stco")
+	offset := binary.BigEndian.Uint32(stco[8:])
+	if bytes.Equal(data[offset:offset+6], []byte{2, 2, 3, 3, 5, 5}) == false {
+		t.Fatal("audio data", data[offset:offset+6])
+	}
+
+	writeTestFlv(t, in, nil, []testFlvTag{{typ: flvTagAudio, data: []byte{0x2f, 1, 0}}})
+	if FlvToMp4(in, out) == nil {
+		t.Fatal("mp3 audio")
+	}
+}
+
+func TestParseAudioSpecificConfig(t *testing.T) {
+	for _, cas := range []struct {
+		asc         []byte
+		sampleRate  uint32
+		frameLength uint32
+	}{
+		{[]byte{0x12, 0x10}, 44100, 1024},
+		{[]byte{0x11, 0x94}, 48000, 960},
+		// 显式SBR: 基础采样率24000, 真正的objectType是AAC-LC
+		{[]byte{0x2b, 0x11, 0x8a}, 24000, 960},
+		{[]byte{0x2b, 0x11}, 24000, 1024},
+	} {
+		cfg, err := parseAudioSpecificConfig(cas.asc)
+		if err != nil || cfg.sampleRate != cas.sampleRate || cfg.frameLength != cas.frameLength || cfg.channelCount != 2 {
+			t.Fatal(cas.asc, cfg, err)
+		}
+	}
+	_, err := parseAudioSpecificConfig([]byte{0x12})
+	if err == nil {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,231 @@
+package muxer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 同一个连接里时间戳往回跳超过这个值时认为直播流重置了时间戳
+const flvTimestampResetMs = 1000
+
+// FlvRecorder 把直播的flv流写入一个或多个文件. 每个文件都以 flv头/onMetaData/音视频序列头 开始, 时间戳从0开始,
+// 有视频时只在关键帧处分割. 断线重连后新连接的时间戳接在上一个连接之后
+type FlvRecorder struct {
+	MaxDuration time.Duration          // 0 表示不按时长分割
+	MaxSize     int64                  // 0 表示不按大小分割
+	NextFile    func() (string, error) // 返回下一个输出文件的路径
+
+	header   []byte
+	metaData []byte
+	videoSeq []byte
+	audioSeq []byte
+	hasVideo bool
+
+	file     *os.File
+	w        *bufio.Writer
+	fileName string
+	fileSize int64
+	fileBase int64 // 当前文件第一个tag的连续时间戳
+
+	offset  int64 // 输入时间戳加上offset得到连续的时间戳
+	lastTs  int64
+	hasLast bool
+	newConn bool
+
+	position int64 // 当前文件已经录制到的时间(毫秒), 其它goroutine通过 Position 读取
+}
+
+// ReadFrom 读取一个连接的完整flv流直到出错或结束, 每次重连后用新的连接再调用一次
+func (this *FlvRecorder) ReadFrom(r io.Reader) (n int64, err error) {
+	br := bufio.NewReaderSize(r, 256*1024)
+	header := make([]byte, 13)
+	_, err = io.ReadFull(br, header)
+	if err != nil {
+		return n, err
+	}
+	n += 13
+	if string(header[:3]) != "FLV" {
+		return n, errors.New("FlvRecorder invalid flv header")
+	}
+	if this.header == nil {
+		this.header = header[:9]
+	}
+	this.newConn = true
+	var tagHeader [flvTagHeaderSize]byte
+	var tail [4]byte
+	for {
+		_, err = io.ReadFull(br, tagHeader[:])
+		if err != nil {
+			return n, err
+		}
+		size := int(tagHeader[1])<<16 | int(tagHeader[2])<<8 | int(tagHeader[3])
+		ts := int64(tagHeader[4])<<16 | int64(tagHeader[5])<<8 | int64(tagHeader[6]) | int64(tagHeader[7])<<24
+		data := make([]byte, size)
+		_, err = io.ReadFull(br, data)
+		if err != nil {
+			return n, err
+		}
+		_, err = io.ReadFull(br, tail[:])
+		if err != nil {
+			return n, err
+		}
+		n += int64(flvTagHeaderSize + size + 4)
+		err = this.writeTag(tagHeader[0]&0x1f, ts, data)
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+func (this *FlvRecorder) writeTag(typ byte, ts int64, data []byte) (err error) {
+	tag := flvTag{typ: typ, head: data}
+	switch {
+	case typ == flvTagScript:
+		if meta, err := parseOnMetaData(data); err == nil {
+			// 直播流里的时长和大小没有意义
+			meta.remove("duration")
+			meta.remove("filesize")
+			this.metaData = encodeOnMetaData(meta)
+		}
+		return nil
+	case tag.isSequenceHeader():
+		if typ == flvTagVideo {
+			this.videoSeq = data
+		} else {
+			this.audioSeq = data
+		}
+		if this.file == nil {
+			return nil
+		}
+		// 序列头的时间戳一般是0, 不参与时间戳的计算, 写在当前位置
+		err = writeFlvTag(this.w, typ, this.lastTs-this.fileBase, data)
+		if err != nil {
+			return err
+		}
+		this.fileSize += int64(flvTagHeaderSize + len(data) + 4)
+		return nil
+	case typ == flvTagVideo:
+		this.hasVideo = true
+	case typ != flvTagAudio:
+		return nil
+	}
+
+	if this.newConn || (this.hasLast && ts+this.offset < this.lastTs-flvTimestampResetMs) {
+		if this.hasLast {
+			this.offset = this.lastTs + 1 - ts
+		} else {
+			this.offset = -ts
+		}
+		this.newConn = false
+	}
+	out := ts + this.offset
+	if out < 0 {
+		out = 0
+	}
+	isKey := typ == flvTagVideo && len(data) > 0 && data[0]>>4 == 1
+	if isKey || (typ == flvTagAudio && this.hasVideo == false) {
+		if this.file == nil || this.shouldSplit(out) {
+			err = this.openNext(out)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if this.file == nil { // 第一个关键帧之前的数据无法解码
+		return nil
+	}
+	rel := out - this.fileBase
+	if rel < 0 {
+		rel = 0
+	}
+	err = writeFlvTag(this.w, typ, rel, data)
+	if err != nil {
+		return err
+	}
+	this.fileSize += int64(flvTagHeaderSize + len(data) + 4)
+	if out > this.lastTs || this.hasLast == false {
+		this.lastTs = out
+	}
+	this.hasLast = true
+	atomic.StoreInt64(&this.position, this.lastTs-this.fileBase)
+	return nil
+}
+
+func (this *FlvRecorder) shouldSplit(out int64) bool {
+	if this.MaxDuration > 0 && out-this.fileBase >= this.MaxDuration.Milliseconds() {
+		return true
+	}
+	return this.MaxSize > 0 && this.fileSize >= this.MaxSize
+}
+
+func (this *FlvRecorder) openNext(out int64) (err error) {
+	err = this.Close()
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt64(&this.position, 0)
+	name, err := this.NextFile()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	this.file = file
+	this.fileName = name
+	this.w = bufio.NewWriterSize(file, 1024*1024)
+	this.fileBase = out
+
+	h := append([]byte{}, this.header[:5]...)
+	h = append(h, 0, 0, 0, 9, 0, 0, 0, 0)
+	this.w.Write(h)
+	this.fileSize = int64(len(h))
+	for _, one := range []struct {
+		typ  byte
+		data []byte
+	}{
+		{flvTagScript, this.metaData},
+		{flvTagVideo, this.videoSeq},
+		{flvTagAudio, this.audioSeq},
+	} {
+		if one.data == nil {
+			continue
+		}
+		err = writeFlvTag(this.w, one.typ, 0, one.data)
+		if err != nil {
+			return err
+		}
+		this.fileSize += int64(flvTagHeaderSize + len(one.data) + 4)
+	}
+	return nil
+}
+
+// Position 当前文件已经录制到的媒体时间, 断线期间不会增加. 可以在其它goroutine中调用
+func (this *FlvRecorder) Position() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.position)) * time.Millisecond
+}
+
+// FileName 当前正在写入的文件, 还没有收到数据时为空
+func (this *FlvRecorder) FileName() string {
+	return this.fileName
+}
+
+// Close 关闭当前文件, 之后再收到数据时会打开新的文件
+func (this *FlvRecorder) Close() error {
+	if this.file == nil {
+		return nil
+	}
+	err := this.w.Flush()
+	closeErr := this.file.Close()
+	this.file = nil
+	this.w = nil
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,157 @@
+package muxer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestFlvRecorder(t *testing.T) (rec *FlvRecorder, nameList *[]string) {
+	dir := t.TempDir()
+	nameList = &[]string{}
+	rec = &FlvRecorder{
+		NextFile: func() (string, error) {
+			name := filepath.Join(dir, fmt.Sprintf("%d.flv", len(*nameList)))
+			*nameList = append(*nameList, name)
+			return name, nil
+		},
+	}
+	t.Cleanup(func() {
+		rec.Close()
+	})
+	return rec, nameList
+}
+
+// recordTestFlv 模拟一个连接, 流结束时返回 io.EOF
+func recordTestFlv(t *testing.T, rec *FlvRecorder, tagList []testFlvTag) {
+	data := buildTestFlv(newTestFlvMeta(640, 360), tagList)
+	n, err := rec.ReadFrom(bytes.NewReader(data))
+	if err != io.EOF || n != int64(len(data)) {
+		t.Fatal(n, err)
+	}
+}
+
+// checkTestLiveFlv 每个文件都以 onMetaData 和序列头开始, 直播流里的 duration/filesize 被删掉
+func checkTestLiveFlv(t *testing.T, name string, want []testFlvTag) {
+	meta, tagList := readTestFlv(t, name)
+	if _, ok := meta.get("duration"); ok {
+		t.Fatal(name, "duration")
+	}
+	if _, ok := meta.get("filesize"); ok {
+		t.Fatal(name, "filesize")
+	}
+	if getTestAmfNumber(t, meta, "width") != 640 {
+		t.Fatal(name, meta)
+	}
+	if reflect.DeepEqual(tagList, want) == false {
+		t.Fatal(name, tagList)
+	}
+}
+
+func TestFlvRecorderSplitDuration(t *testing.T) {
+	rec, nameList := newTestFlvRecorder(t)
+	rec.MaxDuration = 100 * time.Millisecond
+	// 第一个关键帧之前的数据丢弃; 超过100ms后在下一个关键帧处分割
+	recordTestFlv(t, rec, []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(500, false, 1), testFlvAudio(500, 2),
+		testFlvVideo(540, true, 3), testFlvAudio(540, 4), testFlvVideo(580, false, 5),
+		testFlvVideo(620, true, 6), testFlvVideo(660, false, 7),
+		testFlvVideo(700, true, 8), testFlvAudio(700, 9),
+	})
+	if rec.Position() != 0 {
+		t.Fatal(rec.Position())
+	}
+	rec.Close()
+	if len(*nameList) != 2 || rec.FileName() != (*nameList)[1] {
+		t.Fatal(*nameList)
+	}
+	checkTestLiveFlv(t, (*nameList)[0], []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(0, true, 3), testFlvAudio(0, 4), testFlvVideo(40, false, 5),
+		testFlvVideo(80, true, 6), testFlvVideo(120, false, 7),
+	})
+	checkTestLiveFlv(t, (*nameList)[1], []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(0, true, 8), testFlvAudio(0, 9),
+	})
+}
+
+func TestFlvRecorderSplitSize(t *testing.T) {
+	rec, nameList := newTestFlvRecorder(t)
+	rec.MaxSize = 1
+	// 只有音频时在任意音频帧处分割
+	recordTestFlv(t, rec, []testFlvTag{
+		testFlvAacSeq, testFlvAudio(0, 1), testFlvAudio(23, 2), testFlvAudio(46, 3),
+	})
+	rec.Close()
+	if len(*nameList) != 3 {
+		t.Fatal(*nameList)
+	}
+	for idx, name := range *nameList {
+		checkTestLiveFlv(t, name, []testFlvTag{testFlvAacSeq, testFlvAudio(0, byte(idx+1))})
+	}
+}
+
+func TestFlvRecorderReconnect(t *testing.T) {
+	rec, nameList := newTestFlvRecorder(t)
+	recordTestFlv(t, rec, []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(1000, true, 1), testFlvAudio(1000, 2), testFlvVideo(1040, false, 3),
+	})
+	if rec.Position() != 40*time.Millisecond {
+		t.Fatal(rec.Position())
+	}
+	// 重连后时间戳从一个不相关的值开始, 接在上一个连接之后; 序列头变化时写入新的序列头
+	newSeq := testFlvTag{typ: flvTagVideo, ts: 90000, data: []byte{0x17, 0, 0, 0, 0, 1, 0x4d, 0, 0x1f, 0xff}}
+	recordTestFlv(t, rec, []testFlvTag{
+		newSeq, testFlvAacSeq,
+		testFlvVideo(90000, true, 4), testFlvAudio(90010, 5),
+		// 同一个连接里时间戳往回跳, 认为直播流重置了时间戳
+		testFlvVideo(100, true, 6), testFlvVideo(140, false, 7),
+		// 小的回跳是正常的交错, 不处理
+		testFlvAudio(130, 8),
+	})
+	// 断线的时间不计算在内
+	if rec.Position() != 92*time.Millisecond {
+		t.Fatal(rec.Position())
+	}
+	rec.Close()
+	if len(*nameList) != 1 {
+		t.Fatal(*nameList)
+	}
+	newSeq.ts = 40
+	aacSeq := testFlvAacSeq
+	aacSeq.ts = 40
+	checkTestLiveFlv(t, (*nameList)[0], []testFlvTag{
+		testFlvAvcSeq, testFlvAacSeq,
+		testFlvVideo(0, true, 1), testFlvAudio(0, 2), testFlvVideo(40, false, 3),
+		newSeq, aacSeq,
+		testFlvVideo(41, true, 4), testFlvAudio(51, 5),
+		testFlvVideo(52, true, 6), testFlvVideo(92, false, 7),
+		testFlvAudio(82, 8),
+	})
+}
+
+func TestFlvRecorderInvalid(t *testing.T) {
+	rec, nameList := newTestFlvRecorder(t)
+	_, err := rec.ReadFrom(bytes.NewReader([]byte("not a flv stream")))
+	if err == nil || len(*nameList) != 0 || rec.FileName() != "" {
+		t.Fatal(err, *nameList)
+	}
+	// 连接在tag中间断开
+	data := buildTestFlv(nil, []testFlvTag{testFlvAvcSeq, testFlvVideo(0, true, 1), testFlvVideo(40, false, 2)})
+	_, err = rec.ReadFrom(bytes.NewReader(data[:len(data)-3]))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	rec.Close()
+	_, tagList := readTestFlv(t, rec.FileName())
+	if reflect.DeepEqual(tagList, []testFlvTag{testFlvAvcSeq, testFlvVideo(0, true, 1)}) == false {
+		t.Fatal(tagList)
+	}
+}
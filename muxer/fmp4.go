@@ -0,0 +1,341 @@
+package muxer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type trex struct {
+	defaultDuration uint32
+	defaultSize     uint32
+	defaultFlags    uint32
+}
+
+// readFragmentedMp4 解析 dash 的 m4s(fragmented mp4) 文件, 返回其中的track和所有sample的位置
+func readFragmentedMp4(r io.ReaderAt, fileSize int64) (trackList []*track, err error) {
+	topList, err := readChildren(r, 0, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	moov, ok := findChild(topList, "moov")
+	if ok == false {
+		return nil, errors.New("readFragmentedMp4 moov not found")
+	}
+	trackMap := map[uint32]*track{}
+	trexMap := map[uint32]trex{}
+	var trackIdList []uint32
+
+	moovChildren, err := readChildren(r, moov.bodyOffset(), moov.end())
+	if err != nil {
+		return nil, err
+	}
+	for _, child := range moovChildren {
+		switch child.typ {
+		case "trak":
+			trackId, t, err := readTrak(r, child)
+			if err != nil {
+				return nil, err
+			}
+			t.src = r
+			trackMap[trackId] = t
+			trackIdList = append(trackIdList, trackId)
+		case "mvex":
+			list, err := readChildren(r, child.bodyOffset(), child.end())
+			if err != nil {
+				return nil, err
+			}
+			for _, one := range list {
+				if one.typ != "trex" {
+					continue
+				}
+				body, err := readBoxBody(r, one)
+				if err != nil {
+					return nil, err
+				}
+				if len(body) < 24 {
+					return nil, errors.New("readFragmentedMp4 invalid trex")
+				}
+				trexMap[binary.BigEndian.Uint32(body[4:])] = trex{
+					defaultDuration: binary.BigEndian.Uint32(body[12:]),
+					defaultSize:     binary.BigEndian.Uint32(body[16:]),
+					defaultFlags:    binary.BigEndian.Uint32(body[20:]),
+				}
+			}
+		}
+	}
+	nextDtsMap := map[uint32]int64{}
+	for _, box := range topList {
+		if box.typ != "moof" {
+			continue
+		}
+		err = readMoof(r, box, trackMap, trexMap, nextDtsMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range trackIdList {
+		trackList = append(trackList, trackMap[id])
+	}
+	return trackList, nil
+}
+
+func readTrak(r io.ReaderAt, trak boxHeader) (trackId uint32, t *track, err error) {
+	t = &track{}
+	list, err := readChildren(r, trak.bodyOffset(), trak.end())
+	if err != nil {
+		return 0, nil, err
+	}
+	tkhd, ok := findChild(list, "tkhd")
+	if ok == false {
+		return 0, nil, errors.New("readTrak tkhd not found")
+	}
+	body, err := readBoxBody(r, tkhd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(body) < 84 {
+		return 0, nil, errors.New("readTrak invalid tkhd")
+	}
+	if body[0] == 1 {
+		trackId = binary.BigEndian.Uint32(body[20:])
+	} else {
+		trackId = binary.BigEndian.Uint32(body[12:])
+	}
+	t.width = binary.BigEndian.Uint32(body[len(body)-8:])
+	t.height = binary.BigEndian.Uint32(body[len(body)-4:])
+
+	mdia, ok := findChild(list, "mdia")
+	if ok == false {
+		return 0, nil, errors.New("readTrak mdia not found")
+	}
+	mdiaList, err := readChildren(r, mdia.bodyOffset(), mdia.end())
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, child := range mdiaList {
+		switch child.typ {
+		case "mdhd":
+			body, err = readBoxBody(r, child)
+			if err != nil {
+				return 0, nil, err
+			}
+			if len(body) < 4 {
+				return 0, nil, errors.New("readTrak invalid mdhd")
+			}
+			if body[0] == 1 && len(body) >= 34 {
+				t.timescale = binary.BigEndian.Uint32(body[20:])
+				t.language = binary.BigEndian.Uint16(body[32:])
+			} else if len(body) >= 22 {
+				t.timescale = binary.BigEndian.Uint32(body[12:])
+				t.language = binary.BigEndian.Uint16(body[20:])
+			}
+		case "hdlr":
+			body, err = readBoxBody(r, child)
+			if err != nil {
+				return 0, nil, err
+			}
+			if len(body) >= 12 {
+				t.handler = string(body[8:12])
+			}
+		case "minf":
+			t.stsd, err = readStsd(r, child)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	if t.timescale == 0 || t.stsd == nil {
+		return 0, nil, errors.New("readTrak invalid track")
+	}
+	return trackId, t, nil
+}
+
+func readStsd(r io.ReaderAt, minf boxHeader) ([]byte, error) {
+	list, err := readChildren(r, minf.bodyOffset(), minf.end())
+	if err != nil {
+		return nil, err
+	}
+	stbl, ok := findChild(list, "stbl")
+	if ok == false {
+		return nil, errors.New("readStsd stbl not found")
+	}
+	list, err = readChildren(r, stbl.bodyOffset(), stbl.end())
+	if err != nil {
+		return nil, err
+	}
+	stsd, ok := findChild(list, "stsd")
+	if ok == false {
+		return nil, errors.New("readStsd stsd not found")
+	}
+	return readBoxAll(r, stsd)
+}
+
+func readMoof(r io.ReaderAt, moof boxHeader, trackMap map[uint32]*track, trexMap map[uint32]trex, nextDtsMap map[uint32]int64) error {
+	list, err := readChildren(r, moof.bodyOffset(), moof.end())
+	if err != nil {
+		return err
+	}
+	for _, traf := range list {
+		if traf.typ != "traf" {
+			continue
+		}
+		children, err := readChildren(r, traf.bodyOffset(), traf.end())
+		if err != nil {
+			return err
+		}
+		tfhdBox, ok := findChild(children, "tfhd")
+		if ok == false {
+			return errors.New("readMoof tfhd not found")
+		}
+		body, err := readBoxBody(r, tfhdBox)
+		if err != nil {
+			return err
+		}
+		if len(body) < 8 {
+			return errors.New("readMoof invalid tfhd")
+		}
+		flags := binary.BigEndian.Uint32(body) & 0xffffff
+		trackId := binary.BigEndian.Uint32(body[4:])
+		t := trackMap[trackId]
+		if t == nil {
+			continue
+		}
+		def := trexMap[trackId]
+		baseOffset := moof.offset
+		pos := 8
+		readU32 := func() uint32 {
+			if pos+4 > len(body) {
+				return 0
+			}
+			v := binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+			return v
+		}
+		if flags&0x1 != 0 {
+			if pos+8 > len(body) {
+				return errors.New("readMoof invalid tfhd")
+			}
+			baseOffset = int64(binary.BigEndian.Uint64(body[pos:]))
+			pos += 8
+		}
+		if flags&0x2 != 0 {
+			readU32() // sample_description_index
+		}
+		if flags&0x8 != 0 {
+			def.defaultDuration = readU32()
+		}
+		if flags&0x10 != 0 {
+			def.defaultSize = readU32()
+		}
+		if flags&0x20 != 0 {
+			def.defaultFlags = readU32()
+		}
+
+		dts, hasDts := nextDtsMap[trackId]
+		if tfdt, ok := findChild(children, "tfdt"); ok {
+			body, err = readBoxBody(r, tfdt)
+			if err != nil {
+				return err
+			}
+			if len(body) < 4 {
+				return errors.New("readMoof invalid tfdt")
+			}
+			if body[0] == 1 && len(body) >= 12 {
+				dts = int64(binary.BigEndian.Uint64(body[4:]))
+			} else if len(body) >= 8 {
+				dts = int64(binary.BigEndian.Uint32(body[4:]))
+			}
+		}
+		if hasDts == false && len(t.sampleList) == 0 {
+			t.startDts = dts
+		}
+
+		dataOffset := baseOffset
+		for _, trun := range children {
+			if trun.typ != "trun" {
+				continue
+			}
+			dataOffset, dts, err = readTrun(r, trun, t, def, baseOffset, dataOffset, dts)
+			if err != nil {
+				return err
+			}
+		}
+		nextDtsMap[trackId] = dts
+	}
+	return nil
+}
+
+func readTrun(r io.ReaderAt, trun boxHeader, t *track, def trex, baseOffset int64, dataOffset int64, dts int64) (nextOffset int64, nextDts int64, err error) {
+	body, err := readBoxBody(r, trun)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(body) < 8 {
+		return 0, 0, errors.New("readTrun invalid trun")
+	}
+	flags := binary.BigEndian.Uint32(body) & 0xffffff
+	count := binary.BigEndian.Uint32(body[4:])
+	pos := 8
+	readU32 := func() (uint32, error) {
+		if pos+4 > len(body) {
+			return 0, errors.New("readTrun invalid trun")
+		}
+		v := binary.BigEndian.Uint32(body[pos:])
+		pos += 4
+		return v, nil
+	}
+	if flags&0x1 != 0 {
+		v, err := readU32()
+		if err != nil {
+			return 0, 0, err
+		}
+		dataOffset = baseOffset + int64(int32(v))
+	}
+	firstFlags := def.defaultFlags
+	hasFirstFlags := flags&0x4 != 0
+	if hasFirstFlags {
+		firstFlags, err = readU32()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	for i := uint32(0); i < count; i++ {
+		one := sample{
+			offset:   dataOffset,
+			duration: def.defaultDuration,
+			size:     def.defaultSize,
+		}
+		sampleFlags := def.defaultFlags
+		if i == 0 && hasFirstFlags {
+			sampleFlags = firstFlags
+		}
+		if flags&0x100 != 0 {
+			if one.duration, err = readU32(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if flags&0x200 != 0 {
+			if one.size, err = readU32(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if flags&0x400 != 0 {
+			if sampleFlags, err = readU32(); err != nil {
+				return 0, 0, err
+			}
+		}
+		if flags&0x800 != 0 {
+			v, err := readU32()
+			if err != nil {
+				return 0, 0, err
+			}
+			one.ctsOffset = int32(v) // version 0 按规范是无符号的, 但实际数值不会超过int32
+		}
+		one.isSync = t.handler != handlerVideo || sampleFlags&0x10000 == 0
+		t.sampleList = append(t.sampleList, one)
+		dataOffset += int64(one.size)
+		dts += int64(one.duration)
+	}
+	return dataOffset, dts, nil
+}
@@ -0,0 +1,428 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testSample struct {
+	duration  uint32
+	ctsOffset int32
+	isSync    bool
+	data      []byte
+}
+
+type testTrack struct {
+	trackId   uint32
+	handler   string
+	timescale uint32
+	startDts  uint64
+	stsd      []byte // 完整的stsd box, 为空时写入没有sample entry的stsd
+	fragList  [][]testSample
+}
+
+// buildTestFmp4 在内存中构造一个 dash 风格的 fragmented mp4, 每个 fragment 一个 moof + mdat
+func buildTestFmp4(tt testTrack) []byte {
+	w := &boxWriter{}
+	w.begin("moov")
+	w.begin("trak")
+	w.beginFull("tkhd", 0, 3)
+	w.u32(0)
+	w.u32(0)
+	w.u32(tt.trackId)
+	w.zero(4 + 4 + 8 + 2 + 2 + 2 + 2)
+	for _, v := range unityMatrix {
+		w.u32(v)
+	}
+	w.u32(1280 << 16)
+	w.u32(720 << 16)
+	w.end()
+	w.begin("mdia")
+	w.beginFull("mdhd", 0, 0)
+	w.u32(0)
+	w.u32(0)
+	w.u32(tt.timescale)
+	w.u32(0)
+	w.u16(0x55c4)
+	w.u16(0)
+	w.end()
+	w.beginFull("hdlr", 0, 0)
+	w.u32(0)
+	w.write([]byte(tt.handler))
+	w.zero(12)
+	w.u8(0)
+	w.end()
+	w.begin("minf")
+	w.begin("stbl")
+	if len(tt.stsd) > 0 {
+		w.write(tt.stsd)
+	} else {
+		w.beginFull("stsd", 0, 0)
+		w.u32(0)
+		w.end()
+	}
+	w.end()
+	w.end()
+	w.end() // mdia
+	w.end() // trak
+	w.begin("mvex")
+	w.beginFull("trex", 0, 0)
+	w.u32(tt.trackId)
+	w.u32(1)
+	w.u32(0)
+	w.u32(0)
+	w.u32(0)
+	w.end()
+	w.end()
+	w.end() // moov
+
+	dts := tt.startDts
+	for _, frag := range tt.fragList {
+		var moofSize int
+		var moof *boxWriter
+		for i := 0; i < 2; i++ { // 第一次计算moof大小, 第二次写入正确的 data_offset
+			moof = &boxWriter{}
+			moof.begin("moof")
+			moof.beginFull("mfhd", 0, 0)
+			moof.u32(1)
+			moof.end()
+			moof.begin("traf")
+			moof.beginFull("tfhd", 0, 0)
+			moof.u32(tt.trackId)
+			moof.end()
+			moof.beginFull("tfdt", 1, 0)
+			moof.u64(dts)
+			moof.end()
+			moof.beginFull("trun", 0, 0x1|0x100|0x200|0x400|0x800)
+			moof.u32(uint32(len(frag)))
+			moof.u32(uint32(moofSize + 8))
+			for _, one := range frag {
+				moof.u32(one.duration)
+				moof.u32(uint32(len(one.data)))
+				if one.isSync {
+					moof.u32(0)
+				} else {
+					moof.u32(0x10000)
+				}
+				moof.u32(uint32(one.ctsOffset))
+			}
+			moof.end()
+			moof.end()
+			moof.end()
+			moofSize = len(moof.Bytes())
+		}
+		w.write(moof.Bytes())
+		w.begin("mdat")
+		for _, one := range frag {
+			w.write(one.data)
+			dts += uint64(one.duration)
+		}
+		w.end()
+	}
+	return w.Bytes()
+}
+
+func newTestSampleList(count int, duration uint32, tag byte, fn func(idx int, one *testSample)) (list []testSample) {
+	for i := 0; i < count; i++ {
+		one := testSample{
+			duration: duration,
+			isSync:   true,
+			data:     bytes.Repeat([]byte{tag + byte(i)}, 10+i),
+		}
+		if fn != nil {
+			fn(i, &one)
+		}
+		list = append(list, one)
+	}
+	return list
+}
+
+func readTestTracks(t *testing.T, data []byte) []*track {
+	list, err := readFragmentedMp4(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// findTestBox 按路径查找box, 返回box的内容(不含header)
+func findTestBox(r *bytes.Reader, list []boxHeader, path ...string) (body []byte, ok bool) {
+	for idx, typ := range path {
+		h, ok := findChild(list, typ)
+		if ok == false {
+			return nil, false
+		}
+		var err error
+		if idx == len(path)-1 {
+			body, err = readBoxBody(r, h)
+		} else {
+			list, err = readChildren(r, h.bodyOffset(), h.end())
+		}
+		if err != nil {
+			return nil, false
+		}
+	}
+	return body, true
+}
+
+func u32List(body []byte, pos int, count int, step int) (list []uint32) {
+	for i := 0; i < count; i++ {
+		list = append(list, binary.BigEndian.Uint32(body[pos+i*step:]))
+	}
+	return list
+}
+
+var testVideo = testTrack{
+	trackId:   1,
+	handler:   handlerVideo,
+	timescale: 1000,
+	fragList: [][]testSample{
+		newTestSampleList(5, 100, 0x10, func(idx int, one *testSample) {
+			one.isSync = idx == 0
+			one.ctsOffset = 100
+		}),
+		newTestSampleList(5, 100, 0x20, func(idx int, one *testSample) {
+			one.isSync = idx == 0
+			one.ctsOffset = 100
+		}),
+	},
+}
+
+var testAudio = testTrack{
+	trackId:   1,
+	handler:   handlerAudio,
+	timescale: 1000,
+	startDts:  200,
+	fragList:  [][]testSample{newTestSampleList(8, 100, 0x80, nil)},
+}
+
+func TestReadFragmentedMp4(t *testing.T) {
+	list := readTestTracks(t, buildTestFmp4(testVideo))
+	if len(list) != 1 {
+		t.Fatal(len(list))
+	}
+	v := list[0]
+	if v.handler != handlerVideo || v.timescale != 1000 || v.startDts != 0 || v.width != 1280<<16 || v.height != 720<<16 {
+		t.Fatal(v.handler, v.timescale, v.startDts, v.width, v.height)
+	}
+	if len(v.sampleList) != 10 {
+		t.Fatal(len(v.sampleList))
+	}
+	for idx, one := range v.sampleList {
+		src := testVideo.fragList[idx/5][idx%5]
+		if one.size != uint32(len(src.data)) || one.duration != 100 || one.ctsOffset != 100 || one.isSync != (idx%5 == 0) {
+			t.Fatal(idx, one)
+		}
+		buf := make([]byte, one.size)
+		v.src.ReadAt(buf, one.offset)
+		if bytes.Equal(buf, src.data) == false {
+			t.Fatal(idx, buf)
+		}
+	}
+
+	a := readTestTracks(t, buildTestFmp4(testAudio))[0]
+	if a.startDts != 200 || len(a.sampleList) != 8 {
+		t.Fatal(a.startDts, len(a.sampleList))
+	}
+}
+
+func TestWriteMp4(t *testing.T) {
+	trackList := append(readTestTracks(t, buildTestFmp4(testVideo)), readTestTracks(t, buildTestFmp4(testAudio))...)
+	var out bytes.Buffer
+	err := writeMp4(&out, trackList, defaultMp4Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(out.Bytes())
+	topList, err := readChildren(r, 0, int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var typList []string
+	for _, one := range topList {
+		typList = append(typList, one.typ)
+	}
+	if reflect.DeepEqual(typList, []string{"ftyp", "moov", "mdat"}) == false {
+		t.Fatal(typList)
+	}
+	moov, _ := findChild(topList, "moov")
+	trakList, err := readChildren(r, moov.bodyOffset(), moov.end())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var traks []boxHeader
+	for _, one := range trakList {
+		if one.typ == "trak" {
+			traks = append(traks, one)
+		}
+	}
+	if len(traks) != 2 {
+		t.Fatal(len(traks))
+	}
+	trakBox := func(idx int, path ...string) []byte {
+		list, err := readChildren(r, traks[idx].bodyOffset(), traks[idx].end())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, ok := findTestBox(r, list, path...)
+		if ok == false {
+			t.Fatal(idx, path, "not found")
+		}
+		return body
+	}
+	stbl := func(idx int, typ string) []byte {
+		return trakBox(idx, "mdia", "minf", "stbl", typ)
+	}
+
+	// 视频: 10帧时长相同, 第1和第6帧是关键帧
+	if got := u32List(stbl(0, "stts"), 4, 2, 4); reflect.DeepEqual(got, []uint32{1, 10}) == false {
+		t.Fatal("stts", got)
+	}
+	if got := u32List(stbl(0, "stts"), 8, 2, 4); reflect.DeepEqual(got, []uint32{10, 100}) == false {
+		t.Fatal("stts", got)
+	}
+	if got := u32List(stbl(0, "stss"), 4, 3, 4); reflect.DeepEqual(got, []uint32{2, 1, 6}) == false {
+		t.Fatal("stss", got)
+	}
+	if got := u32List(stbl(0, "ctts"), 4, 3, 4); reflect.DeepEqual(got, []uint32{1, 10, 100}) == false {
+		t.Fatal("ctts", got)
+	}
+	// 音频全部是关键帧, 不写 stss
+	list, _ := readChildren(r, traks[1].bodyOffset(), traks[1].end())
+	if _, ok := findTestBox(r, list, "mdia", "minf", "stbl", "stss"); ok {
+		t.Fatal("audio stss")
+	}
+
+	// 每0.5秒一个chunk: 视频[0,0.5) 5帧, 音频[0.2,0.5) 3帧, 视频[0.5,1.0) 5帧, 音频[0.5,1.0) 5帧
+	if got := u32List(stbl(0, "stsc"), 4, 3, 4); reflect.DeepEqual(got, []uint32{1, 1, 5}) == false {
+		t.Fatal("video stsc", got)
+	}
+	if got := u32List(stbl(1, "stsc"), 4, 7, 4); reflect.DeepEqual(got, []uint32{2, 1, 3, 1, 2, 5, 1}) == false {
+		t.Fatal("audio stsc", got)
+	}
+	videoCo := u32List(stbl(0, "stco"), 8, 2, 4)
+	audioCo := u32List(stbl(1, "stco"), 8, 2, 4)
+	if (videoCo[0] < audioCo[0] && audioCo[0] < videoCo[1] && videoCo[1] < audioCo[1]) == false {
+		t.Fatal("interleave", videoCo, audioCo)
+	}
+	// 根据 stco/stsz 读出的sample和原始数据一致
+	check := func(idx int, co []uint32, chunkSize []int, srcList []testSample) {
+		stsz := stbl(idx, "stsz")
+		pos := 0
+		for chunkIdx, offset := range co {
+			for i := 0; i < chunkSize[chunkIdx]; i++ {
+				size := binary.BigEndian.Uint32(stsz[12+pos*4:])
+				if bytes.Equal(out.Bytes()[offset:offset+size], srcList[pos].data) == false {
+					t.Fatal("sample data", idx, pos)
+				}
+				offset += size
+				pos++
+			}
+		}
+	}
+	check(0, videoCo, []int{5, 5}, append(append([]testSample{}, testVideo.fragList[0]...), testVideo.fragList[1]...))
+	check(1, audioCo, []int{3, 5}, testAudio.fragList[0])
+
+	// 视频: 一个编辑跳过首帧的合成时间偏移
+	body := trakBox(0, "edts", "elst")
+	if binary.BigEndian.Uint32(body[4:]) != 1 || binary.BigEndian.Uint64(body[8:]) != 1000 || binary.BigEndian.Uint64(body[16:]) != 100 {
+		t.Fatal("video elst", body)
+	}
+	// 音频: 空编辑补齐0.2秒, 然后从0开始播放
+	body = trakBox(1, "edts", "elst")
+	if binary.BigEndian.Uint32(body[4:]) != 2 || binary.BigEndian.Uint64(body[8:]) != 200 || binary.BigEndian.Uint64(body[16:]) != 0xffffffffffffffff ||
+		binary.BigEndian.Uint64(body[28:]) != 800 || binary.BigEndian.Uint64(body[36:]) != 0 {
+		t.Fatal("audio elst", body)
+	}
+}
+
+// 空的 mdhd/tfdt 返回错误, 不能panic
+func TestReadFragmentedMp4EmptyBox(t *testing.T) {
+	data := buildTestFmp4(testVideo)
+	for _, typ := range []string{"mdhd", "tfdt"} {
+		pos := bytes.Index(data, []byte(typ)) - 4
+		size := int(binary.BigEndian.Uint32(data[pos:]))
+		broken := append([]byte{}, data[:pos]...)
+		broken = append(broken, 0, 0, 0, 8)
+		broken = append(broken, typ...)
+		broken = append(broken, make([]byte, size-8)...) // 用free填充, 保持外层box大小不变
+		binary.BigEndian.PutUint32(broken[pos+8:], uint32(size-8))
+		copy(broken[pos+12:], "free")
+		broken = append(broken, data[pos+size:]...)
+		_, err := readFragmentedMp4(bytes.NewReader(broken), int64(len(broken)))
+		if err == nil {
+			t.Fatal(typ, "expected error")
+		}
+	}
+}
+
+func TestReadBoxHeader(t *testing.T) {
+	w := &boxWriter{}
+	w.begin("free")
+	w.zero(8)
+	w.end()
+	w.u32(1)
+	w.write([]byte("mdat"))
+	w.u64(24)
+	w.zero(8)
+	data := w.Bytes()
+	h, err := readBoxHeader(bytes.NewReader(data), 16, int64(len(data)))
+	if err != nil || h.typ != "mdat" || h.headerSize != 16 || h.size != 24 || h.end() != 40 {
+		t.Fatal(h, err)
+	}
+	// 超过剩余长度, 以及 offset+size 会溢出的64位size
+	for _, size := range []uint64{25, 0x7fffffffffffffff, 0xffffffffffffffff} {
+		binary.BigEndian.PutUint64(data[24:], size)
+		if _, err = readBoxHeader(bytes.NewReader(data), 16, int64(len(data))); err == nil {
+			t.Fatal(size)
+		}
+	}
+}
+
+// buildTestStsd 只有一个空的sample entry
+func buildTestStsd(typ string) []byte {
+	w := &boxWriter{}
+	w.beginFull("stsd", 0, 0)
+	w.u32(1)
+	w.begin(typ)
+	w.zero(8)
+	w.end()
+	w.end()
+	return w.Bytes()
+}
+
+func TestMp4VideoBrand(t *testing.T) {
+	for _, cas := range []struct {
+		typ   string
+		brand string
+	}{
+		{"avc1", "avc1"},
+		{"avc3", "avc1"},
+		{"hvc1", "hvc1"},
+		{"hev1", "hev1"},
+		{"av01", "av01"},
+	} {
+		video := &track{handler: handlerVideo, timescale: 1000, stsd: buildTestStsd(cas.typ)}
+		audio := &track{handler: handlerAudio, timescale: 1000, stsd: buildTestStsd("mp4a")}
+		var out bytes.Buffer
+		err := writeMp4(&out, []*track{audio, video}, defaultMp4Options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(out.Bytes())
+		topList, err := readChildren(r, 0, int64(out.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ftyp, _ := findTestBox(r, topList, "ftyp")
+		if got := string(ftyp[8:]); got != "isomiso2mp41"+cas.brand {
+			t.Fatal(cas.typ, got)
+		}
+	}
+	// 只有音频时不加视频品牌, 也不修改 defaultMp4Options
+	if getVideoBrand([]*track{{handler: handlerAudio, stsd: buildTestStsd("mp4a")}}) != "" || len(defaultMp4Options.compatible) != 3 {
+		t.Fatal(defaultMp4Options.compatible)
+	}
+}
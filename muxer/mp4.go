@@ -0,0 +1,450 @@
+package muxer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+const (
+	handlerVideo = "vide"
+	handlerAudio = "soun"
+)
+
+const movieTimescale = 1000
+
+type track struct {
+	handler    string
+	timescale  uint32
+	width      uint32 // 16.16 定点数
+	height     uint32 // 16.16 定点数
+	language   uint16
+	stsd       []byte // 完整的 stsd box
+	startDts   int64  // 第一个sample的解码时间
+	sampleList []sample
+	src        io.ReaderAt
+}
+
+type sample struct {
+	offset    int64 // sample 数据在 src 中的位置
+	size      uint32
+	duration  uint32
+	ctsOffset int32
+	isSync    bool
+}
+
+func (this *track) duration() int64 {
+	var total int64
+	for _, one := range this.sampleList {
+		total += int64(one.duration)
+	}
+	return total
+}
+
+type mp4Chunk struct {
+	trackIdx int
+	first    int // 第一个sample在track.sampleList中的下标
+	count    int
+	offset   int64
+	size     int64
+}
+
+const interleaveSeconds = 0.5
+
+// planChunks 按解码时间把各个track的sample交错排列, 每个track每0.5秒一个chunk
+func planChunks(trackList []*track) (list []mp4Chunk, payloadSize int64) {
+	idxList := make([]int, len(trackList))
+	dtsList := make([]int64, len(trackList))
+	for i, t := range trackList {
+		dtsList[i] = t.startDts
+	}
+	for window := interleaveSeconds; ; window += interleaveSeconds {
+		var hasMore bool
+		for i, t := range trackList {
+			c := mp4Chunk{
+				trackIdx: i,
+				first:    idxList[i],
+				offset:   payloadSize,
+			}
+			for idxList[i] < len(t.sampleList) && float64(dtsList[i])/float64(t.timescale) < window {
+				one := t.sampleList[idxList[i]]
+				c.count++
+				c.size += int64(one.size)
+				dtsList[i] += int64(one.duration)
+				idxList[i]++
+			}
+			if c.count > 0 {
+				list = append(list, c)
+				payloadSize += c.size
+			}
+			if idxList[i] < len(t.sampleList) {
+				hasMore = true
+			}
+		}
+		if hasMore == false {
+			return list, payloadSize
+		}
+	}
+}
+
+type mp4Options struct {
+	majorBrand string
+	compatible []string
+	udta       []byte // 可选的 udta box, 用于写入标题/作者/封面等标签
+}
+
+// defaultMp4Options 视频编码对应的品牌由 writeMp4 加在 compatible 后面
+var defaultMp4Options = mp4Options{
+	majorBrand: "isom",
+	compatible: []string{"isom", "iso2", "mp41"},
+}
+
+// getSampleEntryType 返回stsd里第一个sample entry的类型, 例如 avc1 hev1 mp4a fLaC ec-3
+func getSampleEntryType(stsd []byte) string {
+	if len(stsd) < 24 {
+		return ""
+	}
+	return string(stsd[20:24])
+}
+
+// getVideoBrand 视频编码对应的兼容品牌, 没有视频或者不认识的编码时返回空
+func getVideoBrand(trackList []*track) string {
+	for _, t := range trackList {
+		if t.handler != handlerVideo {
+			continue
+		}
+		switch typ := getSampleEntryType(t.stsd); typ {
+		case "avc1", "avc3":
+			return "avc1"
+		case "hvc1", "hev1", "av01":
+			return typ
+		}
+	}
+	return ""
+}
+
+// writeMp4 写出 moov 在 mdat 之前的普通mp4文件
+func writeMp4(w io.Writer, trackList []*track, opt mp4Options) (err error) {
+	if len(trackList) == 0 {
+		return errors.New("writeMp4 no track")
+	}
+	chunkList, payloadSize := planChunks(trackList)
+	useCo64 := payloadSize+64*1024*1024 > 0xffffffff
+
+	ftyp := &boxWriter{}
+	ftyp.begin("ftyp")
+	ftyp.write([]byte(opt.majorBrand))
+	ftyp.u32(0x200)
+	compatible := opt.compatible
+	if brand := getVideoBrand(trackList); brand != "" {
+		compatible = append(compatible[:len(compatible):len(compatible)], brand)
+	}
+	for _, one := range compatible {
+		ftyp.write([]byte(one))
+	}
+	ftyp.end()
+
+	moov := buildMoov(trackList, chunkList, useCo64, opt.udta)
+	base := int64(len(ftyp.Bytes())+len(moov)) + 16
+	for i := range chunkList {
+		chunkList[i].offset += base
+	}
+	moov2 := buildMoov(trackList, chunkList, useCo64, opt.udta)
+	if len(moov2) != len(moov) {
+		return errors.New("writeMp4 moov size changed")
+	}
+
+	bw := bufio.NewWriterSize(w, 1024*1024)
+	bw.Write(ftyp.Bytes())
+	bw.Write(moov2)
+	mdat := &boxWriter{}
+	mdat.u32(1)
+	mdat.write([]byte("mdat"))
+	mdat.u64(uint64(payloadSize + 16))
+	bw.Write(mdat.Bytes())
+
+	var buf []byte
+	for _, c := range chunkList {
+		t := trackList[c.trackIdx]
+		for _, one := range t.sampleList[c.first : c.first+c.count] {
+			if cap(buf) < int(one.size) {
+				buf = make([]byte, one.size)
+			}
+			buf = buf[:one.size]
+			_, err = t.src.ReadAt(buf, one.offset)
+			if err != nil {
+				return err
+			}
+			_, err = bw.Write(buf)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+var unityMatrix = []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func buildMoov(trackList []*track, chunkList []mp4Chunk, useCo64 bool, udta []byte) []byte {
+	w := &boxWriter{}
+	var movieDuration int64
+	for _, t := range trackList {
+		d := (t.startDts + t.duration()) * movieTimescale / int64(t.timescale)
+		if d > movieDuration {
+			movieDuration = d
+		}
+	}
+	w.begin("moov")
+	w.beginFull("mvhd", 1, 0)
+	w.u64(0) // creation_time
+	w.u64(0) // modification_time
+	w.u32(movieTimescale)
+	w.u64(uint64(movieDuration))
+	w.u32(0x00010000) // rate
+	w.u16(0x0100)     // volume
+	w.zero(10)
+	for _, v := range unityMatrix {
+		w.u32(v)
+	}
+	w.zero(24)
+	w.u32(uint32(len(trackList) + 1)) // next_track_ID
+	w.end()
+
+	for idx, t := range trackList {
+		writeTrak(w, t, uint32(idx+1), chunkList, idx, useCo64)
+	}
+	if len(udta) > 0 {
+		w.write(udta)
+	}
+	w.end()
+	return w.Bytes()
+}
+
+func writeTrak(w *boxWriter, t *track, trackId uint32, chunkList []mp4Chunk, trackIdx int, useCo64 bool) {
+	mediaDuration := t.duration()
+	w.begin("trak")
+	w.beginFull("tkhd", 1, 3) // enabled | in_movie
+	w.u64(0)
+	w.u64(0)
+	w.u32(trackId)
+	w.u32(0)
+	w.u64(uint64((t.startDts + mediaDuration) * movieTimescale / int64(t.timescale)))
+	w.zero(8)
+	w.u16(0) // layer
+	w.u16(0) // alternate_group
+	if t.handler == handlerAudio {
+		w.u16(0x0100)
+	} else {
+		w.u16(0)
+	}
+	w.u16(0)
+	for _, v := range unityMatrix {
+		w.u32(v)
+	}
+	w.u32(t.width)
+	w.u32(t.height)
+	w.end()
+
+	var firstCts int32
+	if len(t.sampleList) > 0 {
+		firstCts = t.sampleList[0].ctsOffset
+	}
+	if t.startDts > 0 || firstCts != 0 {
+		// 用编辑列表补齐起始空白, 并跳过首帧的合成时间偏移, 保证音画同步
+		w.begin("edts")
+		var count uint32 = 1
+		if t.startDts > 0 {
+			count++
+		}
+		w.beginFull("elst", 1, 0)
+		w.u32(count)
+		if t.startDts > 0 {
+			w.u64(uint64(t.startDts * movieTimescale / int64(t.timescale)))
+			w.u64(0xffffffffffffffff) // media_time -1: 空编辑
+			w.u32(0x00010000)
+		}
+		w.u64(uint64(mediaDuration * movieTimescale / int64(t.timescale)))
+		w.u64(uint64(firstCts))
+		w.u32(0x00010000)
+		w.end()
+		w.end()
+	}
+
+	w.begin("mdia")
+	w.beginFull("mdhd", 1, 0)
+	w.u64(0)
+	w.u64(0)
+	w.u32(t.timescale)
+	w.u64(uint64(mediaDuration))
+	language := t.language
+	if language == 0 {
+		language = 0x55c4 // und
+	}
+	w.u16(language)
+	w.u16(0)
+	w.end()
+
+	w.beginFull("hdlr", 0, 0)
+	w.u32(0)
+	w.write([]byte(t.handler))
+	w.zero(12)
+	if t.handler == handlerAudio {
+		w.write([]byte("SoundHandler\x00"))
+	} else {
+		w.write([]byte("VideoHandler\x00"))
+	}
+	w.end()
+
+	w.begin("minf")
+	if t.handler == handlerAudio {
+		w.beginFull("smhd", 0, 0)
+		w.u32(0)
+		w.end()
+	} else {
+		w.beginFull("vmhd", 0, 1)
+		w.zero(8)
+		w.end()
+	}
+	w.begin("dinf")
+	w.beginFull("dref", 0, 0)
+	w.u32(1)
+	w.beginFull("url ", 0, 1)
+	w.end()
+	w.end()
+	w.end()
+
+	w.begin("stbl")
+	w.write(t.stsd)
+	writeSampleTable(w, t, chunkList, trackIdx, useCo64)
+	w.end() // stbl
+	w.end() // minf
+	w.end() // mdia
+	w.end() // trak
+}
+
+func writeSampleTable(w *boxWriter, t *track, chunkList []mp4Chunk, trackIdx int, useCo64 bool) {
+	// stts
+	type sttsEntry struct {
+		count    uint32
+		duration uint32
+	}
+	var sttsList []sttsEntry
+	for _, one := range t.sampleList {
+		if len(sttsList) > 0 && sttsList[len(sttsList)-1].duration == one.duration {
+			sttsList[len(sttsList)-1].count++
+			continue
+		}
+		sttsList = append(sttsList, sttsEntry{count: 1, duration: one.duration})
+	}
+	w.beginFull("stts", 0, 0)
+	w.u32(uint32(len(sttsList)))
+	for _, one := range sttsList {
+		w.u32(one.count)
+		w.u32(one.duration)
+	}
+	w.end()
+
+	// ctts
+	var hasCts, hasNegativeCts bool
+	for _, one := range t.sampleList {
+		if one.ctsOffset != 0 {
+			hasCts = true
+		}
+		if one.ctsOffset < 0 {
+			hasNegativeCts = true
+		}
+	}
+	if hasCts {
+		type cttsEntry struct {
+			count  uint32
+			offset int32
+		}
+		var cttsList []cttsEntry
+		for _, one := range t.sampleList {
+			if len(cttsList) > 0 && cttsList[len(cttsList)-1].offset == one.ctsOffset {
+				cttsList[len(cttsList)-1].count++
+				continue
+			}
+			cttsList = append(cttsList, cttsEntry{count: 1, offset: one.ctsOffset})
+		}
+		var version uint8
+		if hasNegativeCts {
+			version = 1
+		}
+		w.beginFull("ctts", version, 0)
+		w.u32(uint32(len(cttsList)))
+		for _, one := range cttsList {
+			w.u32(one.count)
+			w.u32(uint32(one.offset))
+		}
+		w.end()
+	}
+
+	// stss, 全部是关键帧时省略
+	var syncList []uint32
+	for idx, one := range t.sampleList {
+		if one.isSync {
+			syncList = append(syncList, uint32(idx+1))
+		}
+	}
+	if len(syncList) != len(t.sampleList) {
+		w.beginFull("stss", 0, 0)
+		w.u32(uint32(len(syncList)))
+		for _, one := range syncList {
+			w.u32(one)
+		}
+		w.end()
+	}
+
+	// stsc
+	type stscEntry struct {
+		firstChunk uint32
+		count      uint32
+	}
+	var stscList []stscEntry
+	var offsetList []int64
+	for _, c := range chunkList {
+		if c.trackIdx != trackIdx {
+			continue
+		}
+		offsetList = append(offsetList, c.offset)
+		if len(stscList) > 0 && stscList[len(stscList)-1].count == uint32(c.count) {
+			continue
+		}
+		stscList = append(stscList, stscEntry{firstChunk: uint32(len(offsetList)), count: uint32(c.count)})
+	}
+	w.beginFull("stsc", 0, 0)
+	w.u32(uint32(len(stscList)))
+	for _, one := range stscList {
+		w.u32(one.firstChunk)
+		w.u32(one.count)
+		w.u32(1) // sample_description_index
+	}
+	w.end()
+
+	// stsz
+	w.beginFull("stsz", 0, 0)
+	w.u32(0)
+	w.u32(uint32(len(t.sampleList)))
+	for _, one := range t.sampleList {
+		w.u32(one.size)
+	}
+	w.end()
+
+	// stco / co64
+	if useCo64 {
+		w.beginFull("co64", 0, 0)
+		w.u32(uint32(len(offsetList)))
+		for _, one := range offsetList {
+			w.u64(uint64(one))
+		}
+	} else {
+		w.beginFull("stco", 0, 0)
+		w.u32(uint32(len(offsetList)))
+		for _, one := range offsetList {
+			w.u32(uint32(one))
+		}
+	}
+	w.end()
+}
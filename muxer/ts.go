@@ -0,0 +1,428 @@
+package muxer
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	tsPacketSize = 188
+	tsPidPat     = 0
+)
+
+// pmt 里的 stream_type
+const (
+	tsStreamAac  = 0x0f
+	tsStreamAvc  = 0x1b
+	tsStreamHevc = 0x24
+)
+
+const tsTimescale = 90000
+
+// pts/dts 是33位的, 大约26.5小时回绕一次
+const tsTimestampWrap = 1 << 33
+
+type tsPes struct {
+	pts  int64
+	dts  int64
+	data []byte
+}
+
+// tsStream 一个音视频pid, pes拼接完成后立即转换为sample写入 tsSampleWriter
+type tsStream struct {
+	typ      byte
+	buf      []byte // 正在拼接的pes
+	t        *track
+	firstDts int64
+	lastPes  *tsPes
+	err      error
+
+	sps, pps []byte
+	dtsList  []int64
+	pending  []byte // 跨越pes的adts帧
+}
+
+// readTs 解析ts文件, 返回每个音视频pid转换后的track, 只处理第一个节目
+func readTs(r io.Reader, w *tsSampleWriter) (streamList []*tsStream, err error) {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	var pmtPid = -1
+	streamMap := map[int]*tsStream{}
+	packet := make([]byte, tsPacketSize)
+	for {
+		_, err = io.ReadFull(br, packet)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if packet[0] != 0x47 {
+			return nil, errors.New("readTs invalid sync byte")
+		}
+		pusi := packet[1]&0x40 != 0
+		pid := int(packet[1]&0x1f)<<8 | int(packet[2])
+		afc := packet[3] >> 4 & 0x03
+		payload := packet[4:]
+		if afc&0x02 != 0 {
+			if int(packet[4])+1 > len(payload) {
+				continue
+			}
+			payload = payload[1+int(packet[4]):]
+		}
+		if afc&0x01 == 0 {
+			continue
+		}
+		switch {
+		case pid == tsPidPat && pusi && pmtPid < 0:
+			pmtPid = parseTsPat(payload)
+		case pid == pmtPid && pusi && len(streamMap) == 0:
+			for _, one := range parseTsPmt(payload) {
+				if one.typ == tsStreamHevc {
+					return nil, errors.New("readTs unsupported codec")
+				}
+				streamMap[one.pid] = &tsStream{typ: one.typ}
+				streamList = append(streamList, streamMap[one.pid])
+			}
+		default:
+			s := streamMap[pid]
+			if s == nil {
+				continue
+			}
+			if pusi {
+				s.flush(w)
+			}
+			if pusi || s.buf != nil {
+				s.buf = append(s.buf, payload...)
+			}
+		}
+	}
+	for _, s := range streamList {
+		s.flush(w)
+		if s.err != nil {
+			return nil, s.err
+		}
+	}
+	return streamList, nil
+}
+
+func tsSection(payload []byte) []byte {
+	if len(payload) < 1 || int(payload[0])+1 > len(payload) {
+		return nil
+	}
+	section := payload[1+int(payload[0]):]
+	if len(section) < 3 {
+		return nil
+	}
+	size := int(section[1]&0x0f)<<8 | int(section[2])
+	if 3+size > len(section) || size < 4 {
+		return nil
+	}
+	return section[:3+size-4] // 去掉 crc32
+}
+
+func parseTsPat(payload []byte) int {
+	section := tsSection(payload)
+	for pos := 8; pos+4 <= len(section); pos += 4 {
+		program := int(section[pos])<<8 | int(section[pos+1])
+		if program != 0 {
+			return int(section[pos+2]&0x1f)<<8 | int(section[pos+3])
+		}
+	}
+	return -1
+}
+
+type tsPmtStream struct {
+	pid int
+	typ byte
+}
+
+func parseTsPmt(payload []byte) (list []tsPmtStream) {
+	section := tsSection(payload)
+	if len(section) < 12 {
+		return nil
+	}
+	pos := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for pos+5 <= len(section) {
+		typ := section[pos]
+		esPid := int(section[pos+1]&0x1f)<<8 | int(section[pos+2])
+		pos += 5 + (int(section[pos+3]&0x0f)<<8 | int(section[pos+4]))
+		switch typ {
+		case tsStreamAac, tsStreamAvc, tsStreamHevc:
+			list = append(list, tsPmtStream{pid: esPid, typ: typ})
+		}
+	}
+	return list
+}
+
+// flush 解析拼接好的pes头, 取出时间戳和数据后转换为sample
+func (this *tsStream) flush(w *tsSampleWriter) {
+	data := this.buf
+	this.buf = nil
+	if this.err != nil || len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return
+	}
+	headerSize := 9 + int(data[8])
+	if headerSize > len(data) {
+		return
+	}
+	flags := data[7] >> 6
+	var pes tsPes
+	if flags&0x02 != 0 && len(data) >= 14 {
+		pes.pts = readTsTimestamp(data[9:])
+		pes.dts = pes.pts
+	}
+	if flags == 0x03 && len(data) >= 19 {
+		pes.dts = readTsTimestamp(data[14:])
+	}
+	if flags&0x02 != 0 {
+		if this.lastPes != nil {
+			pes.dts = unwrapTsTimestamp(pes.dts, this.lastPes.dts)
+		}
+		pes.pts = unwrapTsTimestamp(pes.pts, pes.dts)
+	}
+	if flags&0x02 == 0 && this.lastPes != nil { // 没有时间戳时沿用上一个
+		pes.pts = this.lastPes.pts
+		pes.dts = this.lastPes.dts
+	}
+	pes.data = data[headerSize:]
+	if this.lastPes == nil {
+		this.firstDts = pes.dts
+	}
+	this.lastPes = &pes
+	if this.typ == tsStreamAvc {
+		this.err = this.addAvcPes(pes, w)
+	} else {
+		this.err = this.addAacPes(pes, w)
+	}
+}
+
+func readTsTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// unwrapTsTimestamp 回绕后的时间戳加减 2^33, 使它和 ref 的差距不超过半个周期
+func unwrapTsTimestamp(ts int64, ref int64) int64 {
+	for ts < ref-tsTimestampWrap/2 {
+		ts += tsTimestampWrap
+	}
+	for ts > ref+tsTimestampWrap/2 {
+		ts -= tsTimestampWrap
+	}
+	return ts
+}
+
+// splitAnnexB 按起始码 00 00 01 / 00 00 00 01 分割nalu
+func splitAnnexB(data []byte) (list [][]byte) {
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				end := i
+				if end > start && data[end-1] == 0 {
+					end--
+				}
+				list = append(list, data[start:end])
+			}
+			i += 3
+			start = i
+			continue
+		}
+		i++
+	}
+	if start >= 0 && start < len(data) {
+		list = append(list, data[start:])
+	}
+	return list
+}
+
+func buildAvcConfig(sps []byte, pps []byte) []byte {
+	config := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1, byte(len(sps) >> 8), byte(len(sps))}
+	config = append(config, sps...)
+	config = append(config, 1, byte(len(pps)>>8), byte(len(pps)))
+	return append(config, pps...)
+}
+
+// TsToMp4 把 h264 + aac 的ts文件转换为mp4, 不重新编码. 转换后的sample先写入临时文件
+func TsToMp4(inFile string, outFile string) (err error) {
+	sampleName := outFile + ".samples"
+	sampleFile, err := os.Create(sampleName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		sampleFile.Close()
+		os.Remove(sampleName)
+	}()
+	w := &tsSampleWriter{w: bufio.NewWriterSize(sampleFile, 1024*1024)}
+
+	in, err := os.Open(inFile)
+	if err != nil {
+		return err
+	}
+	streamList, err := readTs(in, w)
+	in.Close()
+	if err != nil {
+		return err
+	}
+	err = w.w.Flush()
+	if err != nil {
+		return err
+	}
+	var trackList []*track
+	var startList []int64 // 每个track第一个sample的时间, 90kHz
+	for _, s := range streamList {
+		t, err := s.finish()
+		if err != nil {
+			return err
+		}
+		if t == nil || len(t.sampleList) == 0 {
+			continue
+		}
+		t.src = sampleFile
+		trackList = append(trackList, t)
+		startList = append(startList, s.firstDts)
+	}
+	if len(trackList) == 0 {
+		return errors.New("TsToMp4 no track")
+	}
+	// 最早的track从0开始, 其它track保留相对的延迟
+	for idx := range startList {
+		startList[idx] = unwrapTsTimestamp(startList[idx], startList[0])
+	}
+	base := startList[0]
+	for _, one := range startList {
+		if one < base {
+			base = one
+		}
+	}
+	for idx, t := range trackList {
+		t.startDts = (startList[idx] - base) * int64(t.timescale) / tsTimescale
+	}
+	return writeMp4File(outFile, trackList, defaultMp4Options)
+}
+
+type tsSampleWriter struct {
+	w      *bufio.Writer
+	offset int64
+}
+
+func (this *tsSampleWriter) write(data []byte) (offset int64) {
+	offset = this.offset
+	this.w.Write(data)
+	this.offset += int64(len(data))
+	return offset
+}
+
+// addAvcPes 每个pes是一帧, nalu改为4字节长度前缀, 去掉 AUD
+func (this *tsStream) addAvcPes(pes tsPes, w *tsSampleWriter) error {
+	if this.t == nil {
+		this.t = &track{handler: handlerVideo, timescale: tsTimescale}
+	}
+	var buf []byte
+	var isSync bool
+	for _, nalu := range splitAnnexB(pes.data) {
+		if len(nalu) == 0 {
+			continue
+		}
+		switch nalu[0] & 0x1f {
+		case 9:
+			continue
+		case 7:
+			if this.sps == nil {
+				this.sps = append([]byte{}, nalu...)
+			}
+		case 8:
+			if this.pps == nil {
+				this.pps = append([]byte{}, nalu...)
+			}
+		case 5:
+			isSync = true
+		}
+		buf = append(buf, byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu)))
+		buf = append(buf, nalu...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	this.t.sampleList = append(this.t.sampleList, sample{
+		offset:    w.write(buf),
+		size:      uint32(len(buf)),
+		ctsOffset: int32(pes.pts - pes.dts),
+		isSync:    isSync,
+	})
+	this.dtsList = append(this.dtsList, pes.dts-this.firstDts)
+	return nil
+}
+
+// addAacPes 一个pes里可能有多个adts帧, 帧也可能跨越pes, 每帧1024个采样
+func (this *tsStream) addAacPes(pes tsPes, w *tsSampleWriter) error {
+	if this.t == nil {
+		this.t = &track{handler: handlerAudio}
+	}
+	t := this.t
+	this.pending = append(this.pending, pes.data...)
+	for len(this.pending) >= 7 {
+		p := this.pending
+		if p[0] != 0xff || p[1]&0xf0 != 0xf0 {
+			return errors.New("TsToMp4 invalid adts header")
+		}
+		frameSize := int(p[3]&0x03)<<11 | int(p[4])<<3 | int(p[5])>>5
+		headerSize := 7
+		if p[1]&0x01 == 0 { // 有crc
+			headerSize = 9
+		}
+		if frameSize < headerSize {
+			return errors.New("TsToMp4 invalid adts frame")
+		}
+		if frameSize > len(p) {
+			break
+		}
+		if t.stsd == nil {
+			objectType := p[2]>>6 + 1
+			rateIdx := p[2] >> 2 & 0x0f
+			channel := p[2]&0x01<<2 | p[3]>>6
+			asc := []byte{objectType<<3 | rateIdx>>1, rateIdx&0x01<<7 | channel<<3}
+			stsd, cfg, err := buildAacStsd(asc)
+			if err != nil {
+				return err
+			}
+			t.stsd = stsd
+			t.timescale = cfg.sampleRate
+		}
+		frame := p[headerSize:frameSize]
+		t.sampleList = append(t.sampleList, sample{
+			offset:   w.write(frame),
+			size:     uint32(len(frame)),
+			duration: 1024,
+			isSync:   true,
+		})
+		this.pending = p[frameSize:]
+	}
+	return nil
+}
+
+// finish 视频根据解码时间计算每帧时长, 并从sps里取出宽高
+func (this *tsStream) finish() (*track, error) {
+	t := this.t
+	if t == nil || t.stsd == nil && this.typ == tsStreamAac {
+		return nil, nil
+	}
+	if this.typ == tsStreamAvc {
+		if len(t.sampleList) == 0 {
+			return nil, nil
+		}
+		if len(this.sps) < 4 || this.pps == nil {
+			return nil, errors.New("TsToMp4 no sps/pps")
+		}
+		width, height, err := parseAvcSpsSize(this.sps)
+		if err != nil {
+			return nil, err
+		}
+		t.stsd = buildVideoStsd("avc1", "avcC", buildAvcConfig(this.sps, this.pps), width, height)
+		t.width, t.height = uint32(width)<<16, uint32(height)<<16
+		setDurationByDts(t, this.dtsList, tsTimescale/25)
+	}
+	return t, nil
+}
@@ -0,0 +1,294 @@
+package muxer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testTsPmtPid   = 0x1000
+	testTsVideoPid = 0x100
+	testTsAudioPid = 0x101
+)
+
+// tsWriter 在内存中构造ts文件, 不写 crc32 和 pcr
+type tsWriter struct {
+	buf bytes.Buffer
+	cc  map[int]byte
+}
+
+// packet 写一个ts包, payload 不足184字节时用 adaptation field 填充, 返回剩余的payload
+func (this *tsWriter) packet(pid int, pusi bool, payload []byte) []byte {
+	p := make([]byte, tsPacketSize)
+	p[0] = 0x47
+	p[1] = byte(pid >> 8 & 0x1f)
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	if this.cc == nil {
+		this.cc = map[int]byte{}
+	}
+	cc := this.cc[pid] & 0x0f
+	this.cc[pid]++
+	if len(payload) >= 184 {
+		p[3] = 0x10 | cc
+		copy(p[4:], payload[:184])
+		this.buf.Write(p)
+		return payload[184:]
+	}
+	p[3] = 0x30 | cc
+	afLen := 184 - len(payload) - 1
+	p[4] = byte(afLen)
+	if afLen > 0 {
+		p[5] = 0
+		for i := 6; i < 5+afLen; i++ {
+			p[i] = 0xff
+		}
+	}
+	copy(p[5+afLen:], payload)
+	this.buf.Write(p)
+	return nil
+}
+
+func (this *tsWriter) section(pid int, body []byte) {
+	sec := append([]byte{0}, body...) // pointer_field
+	sec = append(sec, 0, 0, 0, 0)     // crc32
+	this.packet(pid, true, sec)
+}
+
+// psi 节目1: 视频 videoType, 音频 aac
+func (this *tsWriter) psi(videoType byte) {
+	this.section(tsPidPat, []byte{0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xe0 | testTsPmtPid>>8, testTsPmtPid & 0xff})
+	this.section(testTsPmtPid, []byte{0x02, 0xb0, 23, 0, 1, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00,
+		videoType, 0xe0 | testTsVideoPid>>8, testTsVideoPid & 0xff, 0xf0, 0x00,
+		tsStreamAac, 0xe0 | testTsAudioPid>>8, testTsAudioPid & 0xff, 0xf0, 0x00})
+}
+
+func encodeTsTimestamp(flag byte, v int64) []byte {
+	v &= tsTimestampWrap - 1
+	return []byte{flag<<4 | byte(v>>29&0x0e) | 1, byte(v >> 22), byte(v>>14) | 1, byte(v >> 7), byte(v<<1) | 1}
+}
+
+func (this *tsWriter) pes(pid int, streamId byte, pts int64, dts int64, data []byte) {
+	var h []byte
+	if pts != dts {
+		h = append([]byte{0, 0, 1, streamId, 0, 0, 0x80, 0xc0, 10}, encodeTsTimestamp(3, pts)...)
+		h = append(h, encodeTsTimestamp(1, dts)...)
+	} else {
+		h = append([]byte{0, 0, 1, streamId, 0, 0, 0x80, 0x80, 5}, encodeTsTimestamp(2, pts)...)
+	}
+	payload := append(h, data...)
+	for pusi := true; len(payload) > 0; pusi = false {
+		payload = this.packet(pid, pusi, payload)
+	}
+}
+
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (this *bitWriter) bits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if this.n%8 == 0 {
+			this.b = append(this.b, 0)
+		}
+		if v>>uint(i)&1 != 0 {
+			this.b[len(this.b)-1] |= 1 << (7 - uint(this.n%8))
+		}
+		this.n++
+	}
+}
+
+func (this *bitWriter) ue(v uint32) {
+	v++
+	n := 0
+	for t := v; t > 1; t >>= 1 {
+		n++
+	}
+	this.bits(0, n)
+	this.bits(v, n+1)
+}
+
+// newTestSps baseline profile 1920x1088, 裁剪下面8行为1080
+func newTestSps() []byte {
+	w := &bitWriter{}
+	w.bits(0x67, 8)
+	w.bits(66, 8) // profile_idc
+	w.bits(0, 8)
+	w.bits(40, 8) // level_idc
+	w.ue(0)       // seq_parameter_set_id
+	w.ue(0)       // log2_max_frame_num_minus4
+	w.ue(0)       // pic_order_cnt_type
+	w.ue(4)       // log2_max_pic_order_cnt_lsb_minus4
+	w.ue(1)       // max_num_ref_frames
+	w.bits(0, 1)
+	w.ue(119) // pic_width_in_mbs_minus1
+	w.ue(67)  // pic_height_in_map_units_minus1
+	w.bits(1, 1)
+	w.bits(1, 1)
+	w.bits(1, 1) // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.bits(0, 1)
+	w.bits(1, 1)
+	return w.b
+}
+
+// newTestTs 25fps 的视频, pts比dts晚一帧, 每个关键帧前面有 sps/pps; 每帧视频后面一个pes两个adts帧, 比视频晚1800
+func newTestTs(videoType byte, baseDts int64, frameCount int) []byte {
+	w := &tsWriter{}
+	w.psi(videoType)
+	for i := 0; i < frameCount; i++ {
+		dts := baseDts + int64(i)*3600
+		es := []byte{0, 0, 0, 1, 0x09, 0xf0} // AUD
+		if i%25 == 0 {
+			es = append(es, 0, 0, 0, 1)
+			es = append(es, newTestSps()...)
+			es = append(es, 0, 0, 0, 1, 0x68, 0xce, 0x38, 0x80)
+			es = append(es, 0, 0, 1, 0x65)
+		} else {
+			es = append(es, 0, 0, 1, 0x41)
+		}
+		es = append(es, bytes.Repeat([]byte{byte(i), 0x55}, 150)...)
+		w.pes(testTsVideoPid, 0xe0, dts+3600, dts, es)
+
+		var audio []byte
+		for k := 0; k < 2; k++ {
+			body := bytes.Repeat([]byte{byte(i), byte(k)}, 20)
+			size := 7 + len(body)
+			// LC, 44100, 双声道, 没有crc
+			audio = append(audio, 0xff, 0xf1, 1<<6|4<<2, 0x80|byte(size>>11&3), byte(size>>3), byte(size<<5)|0x1f, 0xfc)
+			audio = append(audio, body...)
+		}
+		w.pes(testTsAudioPid, 0xc0, dts+1800, dts+1800, audio)
+	}
+	return w.buf.Bytes()
+}
+
+func convertTestTs(t *testing.T, data []byte) (out []byte, err error) {
+	dir := t.TempDir()
+	inName := filepath.Join(dir, "in.ts")
+	outName := filepath.Join(dir, "out.mp4")
+	err = os.WriteFile(inName, data, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = TsToMp4(inName, outName)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(outName)
+}
+
+func checkTestTsMp4(t *testing.T, data []byte, frameCount int) {
+	trakList := readTestMp4Traks(t, data)
+	if len(trakList) != 2 {
+		t.Fatal(len(trakList))
+	}
+	video, audio := trakList[0], trakList[1]
+
+	// 视频: 宽高来自sps, 每帧3600, 关键帧是第1和第26帧, 首帧的合成时间偏移用编辑列表跳过
+	tkhd := video.box(t, "tkhd")
+	if binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) != 1920<<16 || binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) != 1080<<16 {
+		t.Fatal("video size", tkhd[len(tkhd)-8:])
+	}
+	if strings.Contains(string(video.stbl(t, "stsd")), "avcC") == false {
+		t.Fatal("no avcC")
+	}
+	durationList := video.sampleDurationList(t)
+	if len(durationList) != frameCount {
+		t.Fatal("video sample count", len(durationList))
+	}
+	for idx, one := range durationList {
+		if one != 3600 {
+			t.Fatal("video duration", idx, one)
+		}
+	}
+	stss := video.stbl(t, "stss")
+	if binary.BigEndian.Uint32(stss[4:]) != 2 || binary.BigEndian.Uint32(stss[8:]) != 1 || binary.BigEndian.Uint32(stss[12:]) != 26 {
+		t.Fatal("stss", stss)
+	}
+	ctts := video.stbl(t, "ctts")
+	if binary.BigEndian.Uint32(ctts[4:]) != 1 || binary.BigEndian.Uint32(ctts[12:]) != 3600 {
+		t.Fatal("ctts", ctts)
+	}
+	elst := video.box(t, "edts", "elst")
+	if binary.BigEndian.Uint32(elst[4:]) != 1 || binary.BigEndian.Uint64(elst[16:]) != 3600 {
+		t.Fatal("video elst", elst)
+	}
+
+	// 音频: 采样率来自adts头, 每帧1024, 比视频晚20ms
+	mdhd := audio.box(t, "mdia", "mdhd")
+	if binary.BigEndian.Uint32(mdhd[20:]) != 44100 {
+		t.Fatal("audio timescale", binary.BigEndian.Uint32(mdhd[20:]))
+	}
+	durationList = audio.sampleDurationList(t)
+	if len(durationList) != frameCount*2 || durationList[0] != 1024 {
+		t.Fatal("audio samples", len(durationList))
+	}
+	elst = audio.box(t, "edts", "elst")
+	if binary.BigEndian.Uint32(elst[4:]) != 2 || binary.BigEndian.Uint64(elst[8:]) != 20 || binary.BigEndian.Uint64(elst[16:]) != 0xffffffffffffffff {
+		t.Fatal("audio elst", elst)
+	}
+	// 第一个音频帧的数据
+	stco := audio.stbl(t, "stco")
+	offset := binary.BigEndian.Uint32(stco[8:])
+	if bytes.Equal(data[offset:offset+40], bytes.Repeat([]byte{0, 0}, 20)) == false {
+		t.Fatal("audio data", data[offset:offset+40])
+	}
+}
+
+func TestTsToMp4(t *testing.T) {
+	data, err := convertTestTs(t, newTestTs(tsStreamAvc, 126000, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTsMp4(t, data, 50)
+}
+
+// 时间戳在第10帧左右回绕, 音频比视频先回绕
+func TestTsToMp4TimestampWrap(t *testing.T) {
+	data, err := convertTestTs(t, newTestTs(tsStreamAvc, tsTimestampWrap-3600*10, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTsMp4(t, data, 50)
+
+	// 视频的第一帧在回绕之前, 音频的第一帧在回绕之后
+	data, err = convertTestTs(t, newTestTs(tsStreamAvc, tsTimestampWrap-1000, 50))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTsMp4(t, data, 50)
+}
+
+func TestTsToMp4Hevc(t *testing.T) {
+	_, err := convertTestTs(t, newTestTs(tsStreamHevc, 0, 1))
+	if err == nil || strings.Contains(err.Error(), "unsupported codec") == false {
+		t.Fatal(err)
+	}
+}
+
+func TestUnwrapTsTimestamp(t *testing.T) {
+	tests := []struct {
+		ts, ref, want int64
+	}{
+		{100, 200, 100},
+		{100, tsTimestampWrap - 100, tsTimestampWrap + 100},
+		{tsTimestampWrap - 100, 100, -100},
+		{100, tsTimestampWrap*2 - 100, tsTimestampWrap*2 + 100},
+	}
+	for _, tt := range tests {
+		if got := unwrapTsTimestamp(tt.ts, tt.ref); got != tt.want {
+			t.Errorf("unwrapTsTimestamp(%d, %d) = %d, want %d", tt.ts, tt.ref, got, tt.want)
+		}
+	}
+}
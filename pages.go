@@ -0,0 +1,79 @@
+package bilibili
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pageSelector 选择要下载的分P, 序号从1开始
+type pageSelector struct {
+	all       bool
+	latest    bool
+	rangeList [][2]int
+}
+
+// parsePageSelector 支持 "5", "1-10,15", "3-"(第3个到最后), "all", "latest"(最后一个)
+func parsePageSelector(selector string) (s pageSelector, err error) {
+	selector = strings.TrimSpace(selector)
+	switch selector {
+	case "", "all":
+		s.all = true
+		return s, nil
+	case "latest", "last":
+		s.latest = true
+		return s, nil
+	}
+	for _, one := range strings.Split(selector, ",") {
+		one = strings.TrimSpace(one)
+		begin, end := one, one
+		if idx := strings.Index(one, "-"); idx >= 0 {
+			begin, end = one[:idx], one[idx+1:]
+		}
+		var r [2]int
+		r[0], err = strconv.Atoi(strings.TrimSpace(begin))
+		if err != nil || r[0] <= 0 {
+			return s, newError("parsePageSelector", nil, errors.New("无效的分P: "+one))
+		}
+		if strings.TrimSpace(end) == "" {
+			r[1] = -1
+		} else {
+			r[1], err = strconv.Atoi(strings.TrimSpace(end))
+			if err != nil || r[1] < r[0] {
+				return s, newError("parsePageSelector", nil, errors.New("无效的分P: "+one))
+			}
+		}
+		s.rangeList = append(s.rangeList, r)
+	}
+	return s, nil
+}
+
+func (this pageSelector) match(page int, pageCount int) bool {
+	if this.all {
+		return true
+	}
+	if this.latest {
+		return page == pageCount
+	}
+	for _, r := range this.rangeList {
+		if page >= r[0] && (r[1] < 0 || page <= r[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPageSelector 优先使用 BeginDownload_Req.Pages, 没有设置时使用网址里的 p 参数, 都没有时下载全部
+func (this *BilibiliDownloader) getPageSelector(urlInput string) (pageSelector, error) {
+	if this.req.Pages != "" {
+		return parsePageSelector(this.req.Pages)
+	}
+	if idx := strings.Index(urlInput, "?"); idx >= 0 {
+		query, _ := url.ParseQuery(urlInput[idx+1:])
+		if p := query.Get("p"); p != "" {
+			return parsePageSelector(p)
+		}
+	}
+	return parsePageSelector("all")
+}
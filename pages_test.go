@@ -0,0 +1,86 @@
+package bilibili
+
+import (
+	"reflect"
+	"testing"
+)
+
+// getTestSelectedPages 返回 pageCount 个分P中被选中的序号
+func getTestSelectedPages(s pageSelector, pageCount int) (list []int) {
+	for page := 1; page <= pageCount; page++ {
+		if s.match(page, pageCount) {
+			list = append(list, page)
+		}
+	}
+	return list
+}
+
+func TestParsePageSelector(t *testing.T) {
+	tests := []struct {
+		selector  string
+		pageCount int
+		want      []int
+	}{
+		{"", 3, []int{1, 2, 3}},
+		{"all", 3, []int{1, 2, 3}},
+		{"2", 3, []int{2}},
+		{" 1 - 2 , 5 ", 6, []int{1, 2, 5}},
+		{"3-", 5, []int{3, 4, 5}},
+		{"2-2", 3, []int{2}},
+		{"latest", 4, []int{4}},
+		{"last", 1, []int{1}},
+		// 重复的分P只选中一次
+		{"1,1-2,2", 3, []int{1, 2}},
+		// 超出范围的部分忽略, 全部超出时什么也不选
+		{"2-10", 3, []int{2, 3}},
+		{"5", 3, nil},
+		{"4-", 3, nil},
+	}
+	for _, tt := range tests {
+		s, err := parsePageSelector(tt.selector)
+		if err != nil {
+			t.Errorf("%q: %v", tt.selector, err)
+			continue
+		}
+		if got := getTestSelectedPages(s, tt.pageCount); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q: got %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+
+	for _, selector := range []string{"0", "-1", "-", "3-1", "a", "1-b", "1,,2", "1-2-3", "first"} {
+		if _, err := parsePageSelector(selector); err == nil {
+			t.Errorf("%q: no error", selector)
+		}
+	}
+}
+
+func TestGetPageSelector(t *testing.T) {
+	tests := []struct {
+		pages string
+		url   string
+		want  []int
+	}{
+		{"", "https://www.bilibili.com/video/BV1xx411c7mD", []int{1, 2, 3}},
+		{"", "https://www.bilibili.com/video/BV1xx411c7mD/?p=2&spm_id_from=333", []int{2}},
+		{"", "https://www.bilibili.com/video/BV1xx411c7mD?spm_id_from=333", []int{1, 2, 3}},
+		// BeginDownload_Req.Pages 优先于网址里的 p 参数
+		{"1,3", "https://www.bilibili.com/video/BV1xx411c7mD?p=2", []int{1, 3}},
+		{"latest", "BV1xx411c7mD", []int{3}},
+	}
+	for _, tt := range tests {
+		d := newBilibiliDownloader(BeginDownload_Req{Url: tt.url, Pages: tt.pages})
+		s, err := d.getPageSelector(tt.url)
+		if err != nil {
+			t.Errorf("%q %q: %v", tt.pages, tt.url, err)
+			continue
+		}
+		if got := getTestSelectedPages(s, 3); reflect.DeepEqual(got, tt.want) == false {
+			t.Errorf("%q %q: got %v, want %v", tt.pages, tt.url, got, tt.want)
+		}
+	}
+
+	d := newBilibiliDownloader(BeginDownload_Req{})
+	if _, err := d.getPageSelector("https://www.bilibili.com/video/BV1xx411c7mD?p=x"); err == nil {
+		t.Fatal("invalid p")
+	}
+}
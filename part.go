@@ -1,6 +1,7 @@
 package bilibili
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"os"
@@ -21,6 +22,22 @@ func GetFormatForExt(format string) string {
 	return format
 }
 
+// downloadVideoPartWithBackup 主地址下载失败时依次使用备用地址, 已经下载的部分不会重新下载
+func (this *BilibiliDownloader) downloadVideoPartWithBackup(part VideoPart, outputNameFullPath string, curLength int64, totalLength int64) (err error) {
+	urlList := append([]string{part.DownloadUrl}, part.BackupUrlList...)
+	for idx, urlStr := range urlList {
+		if idx > 0 {
+			this.fnMessage(fmt.Sprintf("下载失败: %v, 使用备用地址 %d/%d", err, idx, len(urlList)-1))
+		}
+		part.DownloadUrl = urlStr
+		err = this.DownloadVideoPart(part, outputNameFullPath, curLength, totalLength)
+		if err == nil || this.isCancel() {
+			return err
+		}
+	}
+	return err
+}
+
 func (this *BilibiliDownloader) DownloadVideoPart(part VideoPart, outputNameFullPath string, curLength int64, totalLength int64) (err error) {
 	info, err := os.Stat(outputNameFullPath)
 	if err == nil && info.Size() == part.SizeValue { // 此文件已经下载了
@@ -52,7 +69,7 @@ func (this *BilibiliDownloader) DownloadVideoPart(part VideoPart, outputNameFull
 	}
 
 	referer := part.Header.Get("Referer")
-	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+	client := &http.Client{Jar: this.jar, CheckRedirect: func(req *http.Request, via []*http.Request) error {
 		req.Header.Set("Referer", referer)
 		return nil
 	}}
@@ -63,7 +80,7 @@ func (this *BilibiliDownloader) DownloadVideoPart(part VideoPart, outputNameFull
 		return err
 	}
 	request = request.WithContext(this.ctx)
-	request.Header = part.Header
+	request.Header = part.Header.Clone() // 重试备用地址时不能带上这次设置的 Range
 
 	var resp *http.Response
 	var isSingleThread bool
@@ -74,6 +91,10 @@ func (this *BilibiliDownloader) DownloadVideoPart(part VideoPart, outputNameFull
 		request.Header.Set("Range", "bytes="+strconv.FormatInt(beginSize, 10)+"-")
 		resp, err = client.Do(request)
 		isSingleThread = true
+		if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+			resp.Body.Close()
+			err = httpStatusError("DownloadVideoPart", resp.StatusCode)
+		}
 	}
 	if err != nil {
 		return err
@@ -82,6 +103,7 @@ func (this *BilibiliDownloader) DownloadVideoPart(part VideoPart, outputNameFull
 
 	pr := &progressReader{
 		r:              resp.Body,
+		partName:       part.Name,
 		curLength:      curLength + beginSize,
 		totalLength:    totalLength,
 		downloader:     this,
@@ -112,6 +134,7 @@ type progressReader struct {
 	n       int64
 	nLocker sync.Mutex
 
+	partName       string
 	curLength      int64
 	totalLength    int64
 	downloader     *BilibiliDownloader
@@ -130,18 +153,18 @@ func (this *progressReader) Read(buf []byte) (n int, err error) {
 	value := this.curLength + this.n
 	this.nLocker.Unlock()
 
-	FnUpdateProgress(float64(value) / float64(this.totalLength))
+	this.downloader.emit(Event{
+		Type:     EventPartProgress,
+		PartName: this.partName,
+		Progress: float64(value) / float64(this.totalLength),
+	})
 	this.downloader.speedAddBytes(n)
 
 	select {
 	case <-this.ticker.C:
 		speed := this.downloader.speedRecent5sGetAndUpdate()
 		if speed != "" {
-			vt := "(1)"
-			if this.isSingleThread == false {
-				vt = "(n)"
-			}
-			FnMessage("下载速度" + vt + ": " + speed)
+			this.downloader.emit(Event{Type: EventSpeed, Speed: speed, IsMultiThread: this.isSingleThread == false})
 		}
 	default:
 	}
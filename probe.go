@@ -0,0 +1,153 @@
+package bilibili
+
+import (
+	"context"
+)
+
+// ProbeResult 是 Probe 的结果, 可以直接序列化为json
+type ProbeResult struct {
+	Url      string     `json:"url"`
+	Title    string     `json:"title"`
+	Name     string     `json:"name"` // 下载时使用的文件名/目录名
+	PageList []PageInfo `json:"page_list"`
+	// 列表类型的网址(UP主投稿等)只列出视频, 不解析每个视频的清晰度
+	EntryList []VideoEntry `json:"entry_list,omitempty"`
+	Live      *LiveInfo    `json:"live,omitempty"` // 直播间
+}
+
+// PageInfo 一个分P或者一集番剧
+type PageInfo struct {
+	Index      int          `json:"index"` // 从1开始
+	Group      string       `json:"group"` // 对应 VideoPart.Group
+	Title      string       `json:"title"`
+	Duration   float64      `json:"duration"` // 秒
+	FormatList []FormatInfo `json:"format_list"`
+}
+
+// FormatInfo 一种可选的清晰度/音质
+type FormatInfo struct {
+	StreamType  string `json:"stream_type"` // StreamTypeVideo/StreamTypeAudio, flv/mp4分段格式为空
+	Quality     int    `json:"quality"`     // 视频为清晰度qn, 音频为音质id, 分别对应 BeginDownload_Req.Quality/AudioQuality
+	Description string `json:"description"`
+	Codec       string `json:"codec,omitempty"` // CodecAvc/CodecHevc/CodecAv1
+	Codecs      string `json:"codecs,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	FrameRate   string `json:"frame_rate,omitempty"`
+	Bandwidth   int64  `json:"bandwidth,omitempty"`
+	Size        int64  `json:"size"`      // 估算的大小, 字节
+	Available   bool   `json:"available"` // false 表示没有返回下载地址, 一般是需要登录或者大会员
+}
+
+var gAudioDescMap = map[int]string{
+	30216: "64K",
+	30232: "132K",
+	30280: "192K",
+	30250: "杜比全景声",
+	30251: "Hi-Res无损",
+}
+
+// Probe 只解析视频信息, 列出每个分P所有可选的清晰度和估算大小, 不下载.
+// 选择好清晰度后设置 BeginDownload_Req.Quality/Codec/AudioQuality 再调用 Download
+func Probe(ctx context.Context, url string) (result ProbeResult, err error) {
+	return ProbeWithReq(ctx, BeginDownload_Req{Url: url})
+}
+
+// ProbeWithReq 同 Probe, 使用 req 里的cookie和分P选择
+func ProbeWithReq(ctx context.Context, req BeginDownload_Req) (result ProbeResult, err error) {
+	info, err := ResolveVideoInfo(ctx, req)
+	if err != nil {
+		return result, err
+	}
+	result.Url = req.Url
+	result.Title = info.Title
+	result.Name = info.Name
+	result.PageList = info.PageList
+	result.EntryList = info.EntryList
+	result.Live = info.Live
+	if result.PageList == nil { // 没有清晰度信息的网站, 每个Group作为一页
+		for idx, group := range info.getGroupList() {
+			result.PageList = append(result.PageList, PageInfo{
+				Index: idx + 1,
+				Group: group,
+				Title: info.Title,
+			})
+		}
+	}
+	return result, nil
+}
+
+// newPageInfo 根据playurl接口的返回列出所有清晰度
+func newPageInfo(index int, group string, title string, data cidL1) PageInfo {
+	page := PageInfo{
+		Index:    index,
+		Group:    group,
+		Title:    title,
+		Duration: float64(data.Timelength) / 1000,
+	}
+	if data.Dash != nil && data.Dash.Duration > 0 {
+		page.Duration = float64(data.Dash.Duration)
+	}
+	for idx, qn := range data.AcceptQuality {
+		var desc string
+		if idx < len(data.AcceptDescription) {
+			desc = data.AcceptDescription[idx]
+		}
+		if data.Dash == nil {
+			format := FormatInfo{
+				Quality:     qn,
+				Description: desc,
+				Available:   qn == data.Quality,
+			}
+			if format.Available {
+				for _, one := range data.Durl {
+					format.Size += one.Size
+				}
+			}
+			page.FormatList = append(page.FormatList, format)
+			continue
+		}
+		var found bool
+		for _, one := range data.Dash.Video {
+			if one.Id != qn {
+				continue
+			}
+			found = true
+			page.FormatList = append(page.FormatList, newDashFormat(StreamTypeVideo, desc, one, page.Duration))
+		}
+		if found == false {
+			page.FormatList = append(page.FormatList, FormatInfo{
+				StreamType:  StreamTypeVideo,
+				Quality:     qn,
+				Description: desc,
+			})
+		}
+	}
+	if data.Dash != nil {
+		for _, one := range data.Dash.getAllAudio() {
+			page.FormatList = append(page.FormatList, newDashFormat(StreamTypeAudio, gAudioDescMap[one.Id], one, page.Duration))
+		}
+	}
+	return page
+}
+
+func newDashFormat(streamType string, desc string, stream dashStream, duration float64) FormatInfo {
+	format := FormatInfo{
+		StreamType:  streamType,
+		Quality:     stream.Id,
+		Description: desc,
+		Codecs:      stream.Codecs,
+		Width:       stream.Width,
+		Height:      stream.Height,
+		FrameRate:   stream.FrameRate,
+		Bandwidth:   stream.Bandwidth,
+		Size:        int64(float64(stream.Bandwidth) * duration / 8),
+		Available:   stream.BaseUrl != "",
+	}
+	for name, id := range gCodecIdMap {
+		if streamType == StreamTypeVideo && id == stream.Codecid {
+			format.Codec = name
+		}
+	}
+	return format
+}
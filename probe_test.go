@@ -0,0 +1,96 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNewPageInfo(t *testing.T) {
+	var data cidL1
+	err := json.Unmarshal([]byte(`{
+		"quality": 80, "timelength": 100500,
+		"accept_quality": [116, 80, 64],
+		"accept_description": ["1080P 60帧", "1080P 高清", "720P 高清"],
+		"dash": {
+			"duration": 100,
+			"video": [
+				{"id": 80, "base_url": "https://example.com/80_avc.m4s", "bandwidth": 800000, "codecs": "avc1.640032", "width": 1920, "height": 1080, "frame_rate": "30", "codecid": 7},
+				{"id": 80, "base_url": "https://example.com/80_hevc.m4s", "bandwidth": 400000, "codecs": "hev1.1.6.L150.90", "width": 1920, "height": 1080, "frame_rate": "30", "codecid": 12},
+				{"id": 64, "base_url": "https://example.com/64_avc.m4s", "bandwidth": 160000, "codecs": "avc1.640028", "width": 1280, "height": 720, "frame_rate": "30", "codecid": 7}
+			],
+			"audio": [{"id": 30280, "base_url": "https://example.com/30280.m4s", "bandwidth": 192000, "codecs": "mp4a.40.2"}],
+			"dolby": {"audio": [{"id": 30250, "base_url": "", "bandwidth": 448000, "codecs": "ec-3"}]},
+			"flac": {"audio": {"id": 30251, "base_url": "https://example.com/flac.m4s", "bandwidth": 1000000, "codecs": "fLaC"}}
+		}
+	}`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := newPageInfo(2, "2", "第二P", data)
+	if page.Index != 2 || page.Group != "2" || page.Title != "第二P" || page.Duration != 100 {
+		t.Fatal(page)
+	}
+	want := []FormatInfo{
+		// 116 没有返回下载地址, 一般是需要大会员
+		{StreamType: StreamTypeVideo, Quality: 116, Description: "1080P 60帧"},
+		{StreamType: StreamTypeVideo, Quality: 80, Description: "1080P 高清", Codec: CodecAvc, Codecs: "avc1.640032", Width: 1920, Height: 1080, FrameRate: "30", Bandwidth: 800000, Size: 10000000, Available: true},
+		{StreamType: StreamTypeVideo, Quality: 80, Description: "1080P 高清", Codec: CodecHevc, Codecs: "hev1.1.6.L150.90", Width: 1920, Height: 1080, FrameRate: "30", Bandwidth: 400000, Size: 5000000, Available: true},
+		{StreamType: StreamTypeVideo, Quality: 64, Description: "720P 高清", Codec: CodecAvc, Codecs: "avc1.640028", Width: 1280, Height: 720, FrameRate: "30", Bandwidth: 160000, Size: 2000000, Available: true},
+		{StreamType: StreamTypeAudio, Quality: 30280, Description: "192K", Codecs: "mp4a.40.2", Bandwidth: 192000, Size: 2400000, Available: true},
+		{StreamType: StreamTypeAudio, Quality: 30250, Description: "杜比全景声", Codecs: "ec-3", Bandwidth: 448000, Size: 5600000},
+		{StreamType: StreamTypeAudio, Quality: 30251, Description: "Hi-Res无损", Codecs: "fLaC", Bandwidth: 1000000, Size: 12500000, Available: true},
+	}
+	if reflect.DeepEqual(page.FormatList, want) == false {
+		for _, one := range page.FormatList {
+			t.Logf("%+v", one)
+		}
+		t.Fatal("format list")
+	}
+
+	// flv分段格式: 只有当前清晰度可用, 大小是所有分段的和
+	data = cidL1{
+		Quality:           64,
+		Timelength:        60500,
+		AcceptQuality:     []int{80, 64},
+		AcceptDescription: []string{"1080P 高清"},
+	}
+	json.Unmarshal([]byte(`[{"order":1,"size":100},{"order":2,"size":50}]`), &data.Durl)
+	page = newPageInfo(1, "1", "", data)
+	want = []FormatInfo{
+		{Quality: 80, Description: "1080P 高清"},
+		{Quality: 64, Size: 150, Available: true},
+	}
+	if page.Duration != 60.5 || reflect.DeepEqual(page.FormatList, want) == false {
+		t.Fatal(page)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	newTestPgcServer(t)
+	result, err := ProbeWithReq(context.Background(), BeginDownload_Req{Url: "https://www.bilibili.com/bangumi/play/ss100", Pages: "2-3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "ss100_测试番剧" || result.Title != "测试番剧" || len(result.PageList) != 2 {
+		t.Fatal(result)
+	}
+	page := result.PageList[1]
+	if page.Index != 3 || page.Title != "3 第3话" || page.Duration != 1.5 || len(page.FormatList) != 2 {
+		t.Fatal(page)
+	}
+	if format := page.FormatList[0]; format.Quality != 80 || format.Description != "1080P 高清" || format.Available == false || format.Size != 100 || page.FormatList[1].Available {
+		t.Fatal(page)
+	}
+	// 结果可以直接序列化为json
+	data, err := json.Marshal(result)
+	if err != nil || json.Valid(data) == false {
+		t.Fatal(err)
+	}
+
+	_, err = Probe(context.Background(), "https://www.bilibili.com/")
+	if err != ErrUnsupportedURL {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,70 @@
+package bilibili
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// 分享按钮生成的短链接, 需要跟随跳转才能得到视频网址
+var gShortLinkHostList = []string{"b23.tv", "bili2233.cn", "v.douyin.com"}
+
+const _maxRedirectHops = 5
+
+var gUrlInTextRegexp = regexp.MustCompile(`https?://[A-Za-z0-9\-._~:/?#\[\]@!$&'()*+,;=%]+`)
+
+// resolveUrl 从粘贴的分享文本中取出第一个网址, 短链接跟随跳转后返回真实网址.
+// 文本中没有网址时原样返回, 例如 BV号
+func (this *BilibiliDownloader) resolveUrl(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	urlStr := gUrlInTextRegexp.FindString(text)
+	if urlStr == "" {
+		return text, nil
+	}
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	defer client.CloseIdleConnections()
+
+	for hop := 0; isShortLink(urlStr); hop++ {
+		if hop >= _maxRedirectHops {
+			return "", newError("resolveUrl", nil, errors.New("跳转次数太多: "+urlStr))
+		}
+		request, err := http.NewRequest(http.MethodGet, urlStr, nil)
+		if err != nil {
+			return "", wrapError("resolveUrl", err)
+		}
+		request = request.WithContext(this.ctx)
+		request.Header.Set("User-Agent", userAgent)
+		resp, err := client.Do(request)
+		if err != nil {
+			return "", wrapError("resolveUrl", err)
+		}
+		resp.Body.Close()
+		location, err := resp.Location()
+		if err != nil {
+			return "", newError("resolveUrl", ErrUnsupportedURL, errors.New("短链接没有跳转: "+urlStr))
+		}
+		urlStr = location.String()
+	}
+	if urlStr != text {
+		this.fnMessage("网址: " + urlStr)
+	}
+	return urlStr, nil
+}
+
+func isShortLink(urlStr string) bool {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, one := range gShortLinkHostList {
+		if host == one {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package bilibili
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestShortLinkServer 把本地服务当作短链接域名.
+// /s/N 跳转到 /s/N-1, /s/0 跳转到视频网址; /loop 一直跳转到自己; /other 跳转到其他网站; /none 不跳转
+func newTestShortLinkServer(t *testing.T) (srv *httptest.Server, count *int32) {
+	count = new(int32)
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(count, 1)
+		switch {
+		case r.URL.Path == "/s/0":
+			http.Redirect(w, r, "https://www.bilibili.com/video/BV1xx411c7mD?share_source=copy", http.StatusFound)
+		case strings.HasPrefix(r.URL.Path, "/s/"):
+			n := r.URL.Path[len("/s/"):]
+			http.Redirect(w, r, "/s/"+string(n[0]-1), http.StatusMovedPermanently)
+		case r.URL.Path == "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case r.URL.Path == "/other":
+			http.Redirect(w, r, "https://example.com/watch?v=1", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	oldList := gShortLinkHostList
+	gShortLinkHostList = []string{"127.0.0.1"}
+	t.Cleanup(func() {
+		srv.Close()
+		gShortLinkHostList = oldList
+	})
+	return srv, count
+}
+
+func TestResolveUrl(t *testing.T) {
+	srv, count := newTestShortLinkServer(t)
+	tests := []struct {
+		text  string
+		want  string
+		count int32 // 请求短链接的次数
+	}{
+		{"BV1xx411c7mD", "BV1xx411c7mD", 0},
+		{" 【测试视频】 BV1xx411c7mD ", "【测试视频】 BV1xx411c7mD", 0},
+		{"https://www.bilibili.com/video/BV1xx411c7mD", "https://www.bilibili.com/video/BV1xx411c7mD", 0},
+		{"【测试视频-哔哩哔哩】 https://www.bilibili.com/video/BV1xx411c7mD?p=2 复制链接", "https://www.bilibili.com/video/BV1xx411c7mD?p=2", 0},
+		{srv.URL + "/s/0", "https://www.bilibili.com/video/BV1xx411c7mD?share_source=copy", 1},
+		{"【测试视频-哔哩哔哩】 " + srv.URL + "/s/4?share_medium=android", "https://www.bilibili.com/video/BV1xx411c7mD?share_source=copy", 5},
+		// 跳转到其他网站时停止跳转, 由 findExtractor 决定是否支持
+		{srv.URL + "/other", "https://example.com/watch?v=1", 1},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(count, 0)
+		d := newBilibiliDownloader(BeginDownload_Req{})
+		d.sink = gDiscardEventSink
+		got, err := d.resolveUrl(tt.text)
+		if err != nil || got != tt.want || atomic.LoadInt32(count) != tt.count {
+			t.Errorf("%q: got %q %v, count %d", tt.text, got, err, atomic.LoadInt32(count))
+		}
+	}
+
+	// 超过 _maxRedirectHops 次跳转
+	for _, path := range []string{"/loop", "/s/5"} {
+		atomic.StoreInt32(count, 0)
+		d := newBilibiliDownloader(BeginDownload_Req{})
+		d.sink = gDiscardEventSink
+		_, err := d.resolveUrl(srv.URL + path)
+		if err == nil || strings.Contains(err.Error(), "跳转次数太多") == false || atomic.LoadInt32(count) != _maxRedirectHops {
+			t.Errorf("%s: %v, count %d", path, err, atomic.LoadInt32(count))
+		}
+	}
+
+	d := newBilibiliDownloader(BeginDownload_Req{})
+	d.sink = gDiscardEventSink
+	_, err := d.resolveUrl(srv.URL + "/none")
+	checkErrKind(t, "none", err, ErrUnsupportedURL)
+}
@@ -0,0 +1,122 @@
+package bilibili
+
+import (
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	SidecarSubtitle   = "subtitle"
+	SidecarRaw        = "raw" // 原样保存
+	SidecarDanmakuAss = "danmaku_ass"
+)
+
+// SidecarFile 和视频一起保存的附加文件(字幕/封面等), 保存在对应Group的视频文件旁边.
+// Group 为空表示属于整个视频, 有多个分P时保存在视频目录下
+type SidecarFile struct {
+	Group    string
+	Suffix   string // 加在视频文件名后面, 例如 ".zh-CN.srt"
+	FileName string // Group 为空且有多个分P时使用的文件名, 例如 "tvshow.nfo", 空表示目录名加 Suffix
+	Kind     string // SidecarSubtitle 等, 决定下载后如何转换
+	Url      string
+	Header   http.Header
+	Data     []byte // 不为nil时直接保存, 不需要下载
+}
+
+// downloadSidecars 视频下载完成后下载附加文件, 已经存在的文件跳过. 附加文件失败不影响视频, 只提示
+func (this *BilibiliDownloader) downloadSidecars(info VideoInfo) {
+	// 多个附加文件可能来自同一个网址(例如弹幕xml和ass), 只请求一次
+	cache := map[string][]byte{}
+	for _, one := range info.SidecarList {
+		if this.isCancel() {
+			return
+		}
+		outName := this.getSidecarOutName(info, one)
+		if _, err := os.Stat(outName); err == nil {
+			continue
+		}
+		err := this.downloadSidecar(one, outName, cache)
+		if err != nil {
+			this.fnMessage("下载" + filepath.Base(outName) + "失败: " + err.Error())
+		}
+	}
+}
+
+func (this *BilibiliDownloader) getSidecarOutName(info VideoInfo, sidecar SidecarFile) string {
+	if len(info.getGroupList()) <= 1 {
+		return filepath.Join(this.req.SaveDir, info.Name+sidecar.Suffix)
+	}
+	if sidecar.Group != "" {
+		return filepath.Join(this.req.SaveDir, info.Name, sidecar.Group+sidecar.Suffix)
+	}
+	if sidecar.FileName != "" {
+		return filepath.Join(this.req.SaveDir, info.Name, sidecar.FileName)
+	}
+	return filepath.Join(this.req.SaveDir, info.Name, info.Name+sidecar.Suffix)
+}
+
+func (this *BilibiliDownloader) downloadSidecar(sidecar SidecarFile, outName string, cache map[string][]byte) (err error) {
+	data, ok := cache[sidecar.Url]
+	if sidecar.Data != nil {
+		data = sidecar.Data
+	} else if ok == false {
+		data, err = this.fetchWithHeader(sidecar.Url, sidecar.Header)
+		if err != nil {
+			return err
+		}
+		cache[sidecar.Url] = data
+	}
+	switch sidecar.Kind {
+	case SidecarSubtitle:
+		data, err = convertSubtitle(data, filepath.Ext(outName))
+	case SidecarDanmakuAss:
+		data, err = convertDanmakuToAss(data, this.req.Danmaku)
+	}
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outName, data)
+}
+
+func (this *BilibiliDownloader) fetchWithHeader(urlStr string, header http.Header) (content []byte, err error) {
+	if header == nil {
+		return this.defaultFetcher(urlStr)
+	}
+	request, err := http.NewRequest(http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(this.ctx)
+	for k, vList := range header {
+		request.Header[k] = vList
+	}
+	resp, err := this.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("错误码： %d", resp.StatusCode)
+	}
+	var body io.Reader = resp.Body
+	// 弹幕接口返回没有zlib头的deflate数据, http.Client 不会自动解压
+	if resp.Header.Get("Content-Encoding") == "deflate" {
+		body = flate.NewReader(resp.Body)
+	}
+	return ioutil.ReadAll(body)
+}
+
+// writeFileAtomic 先写临时文件再改名, 避免留下不完整的文件
+func writeFileAtomic(name string, data []byte) error {
+	tmpName := name + ".downloading"
+	err := os.WriteFile(tmpName, data, 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, name)
+}
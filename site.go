@@ -4,7 +4,17 @@ import "net/http"
 
 type VideoInfo struct {
 	Name     string
+	Title    string
+	Author   string // UP主, 用于音频文件的标签
+	CoverUrl string
 	PartList []VideoPart
+	PageList []PageInfo // 每个分P可选的清晰度, 只有b站的视频有
+	// 列表类型(UP主投稿等)的网址解析为多个视频, 此时 PartList 为空, 下载时逐个解析
+	EntryList []VideoEntry
+	// 字幕等附加文件, 视频下载完成后下载
+	SidecarList []SidecarFile
+	// 直播间, 不为nil时录制直播而不是下载 PartList
+	Live *LiveInfo
 }
 
 func (i VideoInfo) GetTotalLength() int64 {
@@ -15,11 +25,56 @@ func (i VideoInfo) GetTotalLength() int64 {
 	return total
 }
 
+func (i VideoInfo) hasDash() bool {
+	for _, one := range i.PartList {
+		if one.StreamType != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLive 录制直播间或者直播的m3u8, 被停止时是正常结束
+func (i VideoInfo) isLive() bool {
+	if i.Live != nil {
+		return true
+	}
+	for _, one := range i.PartList {
+		if one.Hls != nil && one.Hls.IsLive {
+			return true
+		}
+	}
+	return false
+}
+
+// getGroupList 按出现顺序返回所有的Group
+func (i VideoInfo) getGroupList() (list []string) {
+	m := map[string]bool{}
+	for _, one := range i.PartList {
+		if m[one.Group] {
+			continue
+		}
+		m[one.Group] = true
+		list = append(list, one.Group)
+	}
+	return list
+}
+
 type VideoPart struct {
 	Name           string
+	Group          string // 同一分P/剧集的分段共享一个Group
+	StreamType     string // dash格式下为 StreamTypeVideo 或 StreamTypeAudio, 其它情况为空
+	Codecs         string // dash格式下的编码, 例如 avc1.640032 mp4a.40.2 fLaC ec-3
 	FileExtWithDot string
 	DownloadUrl    string
+	BackupUrlList  []string // DownloadUrl 下载失败时依次尝试的备用地址
 	Header         http.Header
 	HasSize        bool
 	SizeValue      int64
+	Hls            *HlsMedia // 不为nil时 DownloadUrl 是m3u8地址, 按分片下载
 }
+
+const (
+	StreamTypeVideo = "video"
+	StreamTypeAudio = "audio"
+)
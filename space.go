@@ -0,0 +1,113 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const _spaceArcUrlTemp = "%s/x/space/wbi/arc/search?%s"
+const _spacePageSize = 50
+
+var gSpaceRegexp = regexp.MustCompile(`space\.bilibili\.com/(\d+)(?:/video)?/?(?:[?#]|$)`)
+
+type spaceExtractor struct{}
+
+func (spaceExtractor) Match(url string) bool {
+	return gSpaceRegexp.MatchString(url)
+}
+
+func (spaceExtractor) Extract(ctx context.Context, url string) (VideoInfo, error) {
+	params := gSpaceRegexp.FindStringSubmatch(url)
+	if params == nil {
+		return VideoInfo{}, ErrUnsupportedURL
+	}
+	mid, _ := strconv.ParseInt(params[1], 10, 64)
+	return getDownloaderFromCtx(ctx).getVideoInfoList_ByUpId(mid)
+}
+
+// getVideoInfoList_ByUpId 分页获取UP主的所有投稿, 按发布时间从新到旧排列
+func (this *BilibiliDownloader) getVideoInfoList_ByUpId(mid int64) (info VideoInfo, err error) {
+	filter := this.req.Filter
+	var author string
+	for pn := 1; ; pn++ {
+		query := url.Values{}
+		query.Set("mid", strconv.FormatInt(mid, 10))
+		query.Set("ps", strconv.Itoa(_spacePageSize))
+		query.Set("pn", strconv.Itoa(pn))
+		query.Set("order", "pubdate")
+		signed, err := this.signWbi(query)
+		if err != nil {
+			return info, err
+		}
+		contents, err := this.defaultFetcher(fmt.Sprintf(_spaceArcUrlTemp, gBilibiliApiHost, signed))
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByUpId", err)
+		}
+		var tmp struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    struct {
+				List struct {
+					Vlist []struct {
+						Aid     int64  `json:"aid"`
+						Bvid    string `json:"bvid"`
+						Title   string `json:"title"`
+						Author  string `json:"author"`
+						Created int64  `json:"created"`
+					} `json:"vlist"`
+				} `json:"list"`
+				Page struct {
+					Count int `json:"count"`
+				} `json:"page"`
+			} `json:"data"`
+		}
+		err = json.Unmarshal(contents, &tmp)
+		if err != nil {
+			return info, wrapError("getVideoInfoList_ByUpId_2", err)
+		}
+		if tmp.Code != 0 {
+			return info, apiCodeError("获取UP主投稿失败", tmp.Code, tmp.Message)
+		}
+		var isEnd bool
+		for _, one := range tmp.Data.List.Vlist {
+			author = one.Author
+			entry := VideoEntry{
+				Id:      one.Bvid,
+				Url:     "https://www.bilibili.com/video/" + one.Bvid,
+				Title:   one.Title,
+				PubDate: time.Unix(one.Created, 0),
+			}
+			if filter.After.IsZero() == false && entry.PubDate.Before(filter.After) {
+				isEnd = true // 后面的视频更早, 不需要再获取了
+				break
+			}
+			if filter.match(entry) {
+				info.EntryList = append(info.EntryList, entry)
+			}
+			if filter.isFull(len(info.EntryList)) {
+				isEnd = true
+				break
+			}
+		}
+		fetched := pn * _spacePageSize
+		if fetched > tmp.Data.Page.Count {
+			fetched = tmp.Data.Page.Count
+		}
+		this.fnMessage(fmt.Sprintf("获取UP主投稿: %d/%d", fetched, tmp.Data.Page.Count))
+		if isEnd || len(tmp.Data.List.Vlist) == 0 || fetched >= tmp.Data.Page.Count {
+			break
+		}
+		this.sleepDur(time.Millisecond * 500) // 太快会触发风控
+	}
+	if len(info.EntryList) == 0 {
+		return info, newError("获取UP主投稿失败", ErrVideoNotFound, nil)
+	}
+	info.Title = author
+	info.Name = fmt.Sprintf("space%d_%s", mid, TitleEdit(author))
+	return info, nil
+}
@@ -0,0 +1,204 @@
+package bilibili
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const _playerUrlTemp = "%s/x/player/wbi/v2?%s"
+
+const (
+	SubtitleFormatSrt = "srt"
+	SubtitleFormatVtt = "vtt"
+	SubtitleFormatAss = "ass"
+)
+
+// getSubtitleSidecarList 获取一个分P的CC字幕, 失败时只提示, 不影响视频下载
+func (this *BilibiliDownloader) getSubtitleSidecarList(aid int64, cid int64, group string) (list []SidecarFile) {
+	if this.req.SubtitleFormat == "" {
+		return nil
+	}
+	query := url.Values{}
+	query.Set("aid", strconv.FormatInt(aid, 10))
+	query.Set("cid", strconv.FormatInt(cid, 10))
+	signed, err := this.signWbi(query)
+	if err != nil {
+		this.fnMessage("获取字幕失败: " + err.Error())
+		return nil
+	}
+	contents, err := this.defaultFetcher(fmt.Sprintf(_playerUrlTemp, gBilibiliApiHost, signed))
+	if err != nil {
+		this.fnMessage("获取字幕失败: " + err.Error())
+		return nil
+	}
+	var tmp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Subtitle struct {
+				Subtitles []struct {
+					Lan         string `json:"lan"`
+					LanDoc      string `json:"lan_doc"`
+					SubtitleUrl string `json:"subtitle_url"`
+				} `json:"subtitles"`
+			} `json:"subtitle"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err == nil && tmp.Code != 0 {
+		err = apiCodeError("getSubtitleSidecarList", tmp.Code, tmp.Message)
+	}
+	if err != nil {
+		this.fnMessage("获取字幕失败: " + err.Error())
+		return nil
+	}
+	for _, one := range tmp.Data.Subtitle.Subtitles {
+		if one.SubtitleUrl == "" || this.isSubtitleLanSelected(one.Lan) == false {
+			continue
+		}
+		subtitleUrl := one.SubtitleUrl
+		if strings.HasPrefix(subtitleUrl, "//") {
+			subtitleUrl = "https:" + subtitleUrl
+		}
+		list = append(list, SidecarFile{
+			Group:  group,
+			Suffix: "." + one.Lan + "." + this.req.SubtitleFormat,
+			Kind:   SidecarSubtitle,
+			Url:    subtitleUrl,
+			Header: http.Header{"User-Agent": {userAgent}},
+		})
+	}
+	return list
+}
+
+func (this *BilibiliDownloader) isSubtitleLanSelected(lan string) bool {
+	if len(this.req.SubtitleLanList) == 0 {
+		return true
+	}
+	for _, one := range this.req.SubtitleLanList {
+		if strings.EqualFold(one, lan) {
+			return true
+		}
+	}
+	return false
+}
+
+type subtitleLine struct {
+	From    float64 `json:"from"`
+	To      float64 `json:"to"`
+	Content string  `json:"content"`
+}
+
+// convertSubtitle 把b站的json字幕转换为 extWithDot 对应的格式
+func convertSubtitle(data []byte, extWithDot string) ([]byte, error) {
+	var tmp struct {
+		Body []subtitleLine `json:"body"`
+	}
+	err := json.Unmarshal(data, &tmp)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.TrimPrefix(extWithDot, ".") {
+	case SubtitleFormatSrt:
+		return toSrt(tmp.Body), nil
+	case SubtitleFormatVtt:
+		return toVtt(tmp.Body), nil
+	case SubtitleFormatAss:
+		return toAss(tmp.Body), nil
+	}
+	return nil, errors.New("不支持的字幕格式: " + extWithDot)
+}
+
+// formatSubtitleTime sep 为秒和毫秒之间的分隔符, srt 为 ',' vtt 为 '.'
+func formatSubtitleTime(sec float64, sep string) string {
+	ms := int64(sec*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// cleanSubtitleText 去掉空行, 空行在srt/vtt里表示一条字幕结束
+func cleanSubtitleText(text string) string {
+	var lineList []string
+	for _, line := range strings.Split(strings.Replace(text, "\r", "", -1), "\n") {
+		if strings.TrimSpace(line) != "" {
+			lineList = append(lineList, line)
+		}
+	}
+	return strings.Join(lineList, "\n")
+}
+
+func toSrt(list []subtitleLine) []byte {
+	var buf bytes.Buffer
+	var index int
+	for _, one := range list {
+		text := cleanSubtitleText(one.Content)
+		if text == "" {
+			continue
+		}
+		index++
+		fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", index, formatSubtitleTime(one.From, ","), formatSubtitleTime(one.To, ","), text)
+	}
+	return buf.Bytes()
+}
+
+// gVttEscaper vtt 的文本里 & < 有特殊含义, > 转义后也不会出现 -->
+var gVttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func toVtt(list []subtitleLine) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n\n")
+	for _, one := range list {
+		text := cleanSubtitleText(one.Content)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", formatSubtitleTime(one.From, "."), formatSubtitleTime(one.To, "."), gVttEscaper.Replace(text))
+	}
+	return buf.Bytes()
+}
+
+// formatAssTime ass 的时间精确到百分之一秒, 小时只有一位
+func formatAssTime(sec float64) string {
+	cs := int64(sec*100 + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+const _subtitleAssHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Microsoft YaHei,54,&H00FFFFFF,&H00FFFFFF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,2,0,2,20,20,40,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+func toAss(list []subtitleLine) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(_subtitleAssHeader)
+	for _, one := range list {
+		text := cleanSubtitleText(one.Content)
+		if text == "" {
+			continue
+		}
+		fmt.Fprintf(&buf, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n", formatAssTime(one.From), formatAssTime(one.To), escapeAssText(text))
+	}
+	return buf.Bytes()
+}
+
+// escapeAssText 换行转换为 \N, 花括号会被当作样式标签, 替换为全角
+func escapeAssText(text string) string {
+	text = strings.Replace(text, "\r", "", -1)
+	text = strings.Replace(text, "\n", `\N`, -1)
+	text = strings.Replace(text, "{", "｛", -1)
+	text = strings.Replace(text, "}", "｝", -1)
+	return text
+}
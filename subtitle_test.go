@@ -0,0 +1,72 @@
+package bilibili
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatSubtitleTime(t *testing.T) {
+	tests := []struct {
+		sec float64
+		srt string
+		vtt string
+		ass string
+	}{
+		{0, "00:00:00,000", "00:00:00.000", "0:00:00.00"},
+		{1.5, "00:00:01,500", "00:00:01.500", "0:00:01.50"},
+		// 浮点误差按四舍五入处理
+		{2.0049, "00:00:02,005", "00:00:02.005", "0:00:02.00"},
+		{59.9996, "00:01:00,000", "00:01:00.000", "0:01:00.00"},
+		{3661.234, "01:01:01,234", "01:01:01.234", "1:01:01.23"},
+		{36000.999, "10:00:00,999", "10:00:00.999", "10:00:01.00"},
+	}
+	for _, tt := range tests {
+		if got := formatSubtitleTime(tt.sec, ","); got != tt.srt {
+			t.Errorf("%v srt: %s", tt.sec, got)
+		}
+		if got := formatSubtitleTime(tt.sec, "."); got != tt.vtt {
+			t.Errorf("%v vtt: %s", tt.sec, got)
+		}
+		if got := formatAssTime(tt.sec); got != tt.ass {
+			t.Errorf("%v ass: %s", tt.sec, got)
+		}
+	}
+}
+
+const _testSubtitleJson = `{"body":[
+	{"from":0.5,"to":2.25,"content":"第一行\n第二行"},
+	{"from":3,"to":4,"content":"  \r\n"},
+	{"from":61.5,"to":63,"content":"a --> b & <i>c</i>\r\n\r\n{\\an8}d"}
+]}`
+
+func TestConvertSubtitle(t *testing.T) {
+	tests := []struct {
+		ext  string
+		want string
+	}{
+		// 空的字幕去掉, srt 的序号连续; 空行在srt/vtt里会提前结束一条字幕, 去掉
+		{".srt", "1\n00:00:00,500 --> 00:00:02,250\n第一行\n第二行\n\n" +
+			"2\n00:01:01,500 --> 00:01:03,000\na --> b & <i>c</i>\n{\\an8}d\n\n"},
+		{".vtt", "WEBVTT\n\n00:00:00.500 --> 00:00:02.250\n第一行\n第二行\n\n" +
+			"00:01:01.500 --> 00:01:03.000\na --&gt; b &amp; &lt;i&gt;c&lt;/i&gt;\n{\\an8}d\n\n"},
+		{".ass", _subtitleAssHeader +
+			"Dialogue: 0,0:00:00.50,0:00:02.25,Default,,0,0,0,,第一行\\N第二行\n" +
+			"Dialogue: 0,0:01:01.50,0:01:03.00,Default,,0,0,0,,a --> b & <i>c</i>\\N｛\\an8｝d\n"},
+	}
+	for _, tt := range tests {
+		got, err := convertSubtitle([]byte(_testSubtitleJson), tt.ext)
+		if err != nil {
+			t.Fatal(tt.ext, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n%s", tt.ext, got)
+		}
+	}
+
+	if _, err := convertSubtitle([]byte(_testSubtitleJson), ".txt"); err == nil || strings.Contains(err.Error(), "不支持的字幕格式") == false {
+		t.Fatal(err)
+	}
+	if _, err := convertSubtitle([]byte("not json"), ".srt"); err == nil {
+		t.Fatal("invalid json")
+	}
+}
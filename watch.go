@@ -0,0 +1,213 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const _liveWatchDefaultInterval = time.Minute
+
+const _liveRoomUrlTemp = "https://live.bilibili.com/%d"
+
+// LiveWatchOption 直播间监控的参数
+type LiveWatchOption struct {
+	RoomIdList []int64           // 为空时使用状态文件里保存的房间
+	Interval   time.Duration     // 检查间隔, 默认1分钟
+	StateFile  string            // 保存每个房间的状态, 重启后根据它判断开播/下播, 为空时不保存
+	Req        BeginDownload_Req // 录制任务的参数, Url 由房间号生成
+}
+
+// LiveRoomState 一个直播间的监控状态, 保存到状态文件
+type LiveRoomState struct {
+	RoomId    int64     `json:"room_id"`
+	Title     string    `json:"title"`
+	IsLive    bool      `json:"is_live"`
+	LiveSince time.Time `json:"live_since"` // 最近一次检测到开播的时间
+	LastCheck time.Time `json:"last_check"` // 最近一次成功检查的时间
+
+	taskId int64 // 正在录制的任务, 不保存, 重启后重新开始录制
+}
+
+// LiveWatcher 定时检查直播间, 开播时通过 Manager 添加录制任务. 下播时录制任务会自己结束,
+// 检查到下播但录制还没结束时主动停止. 录制任务意外结束而直播间仍在直播时重新开始录制
+type LiveWatcher struct {
+	locker   sync.Mutex
+	opt      LiveWatchOption
+	manager  *Manager
+	sink     EventSink
+	roomList []*LiveRoomState
+}
+
+// NewLiveWatcher 读取 opt.StateFile 恢复上次的状态, 录制任务添加到 manager, 房间状态变化的事件发送到sink
+func NewLiveWatcher(manager *Manager, opt LiveWatchOption, sink EventSink) (*LiveWatcher, error) {
+	if opt.Interval <= 0 {
+		opt.Interval = _liveWatchDefaultInterval
+	}
+	this := &LiveWatcher{
+		opt:     opt,
+		manager: manager,
+		sink:    sink,
+	}
+	saved := map[int64]*LiveRoomState{}
+	if opt.StateFile != "" {
+		data, err := os.ReadFile(opt.StateFile)
+		if err != nil && os.IsNotExist(err) == false {
+			return nil, wrapError("NewLiveWatcher", err)
+		}
+		if err == nil {
+			var list []*LiveRoomState
+			err = json.Unmarshal(data, &list)
+			if err != nil {
+				return nil, wrapError("NewLiveWatcher_2", err)
+			}
+			for _, one := range list {
+				saved[one.RoomId] = one
+			}
+		}
+	}
+	roomIdList := opt.RoomIdList
+	if len(roomIdList) == 0 {
+		for roomId := range saved {
+			roomIdList = append(roomIdList, roomId)
+		}
+		sort.Slice(roomIdList, func(i, j int) bool {
+			return roomIdList[i] < roomIdList[j]
+		})
+	}
+	for _, roomId := range roomIdList {
+		room := saved[roomId]
+		if room == nil {
+			room = &LiveRoomState{RoomId: roomId}
+		}
+		this.roomList = append(this.roomList, room)
+	}
+	if len(this.roomList) == 0 {
+		return nil, newError("NewLiveWatcher", nil, errors.New("没有要监控的直播间"))
+	}
+	return this, nil
+}
+
+// Run 立即检查一次, 之后每隔 Interval 检查, 直到ctx取消. 返回时不会停止正在录制的任务
+func (this *LiveWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(this.opt.Interval)
+	defer ticker.Stop()
+	for {
+		this.checkAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetRoomList 返回所有房间当前的状态
+func (this *LiveWatcher) GetRoomList() (list []LiveRoomState) {
+	this.locker.Lock()
+	defer this.locker.Unlock()
+
+	for _, room := range this.roomList {
+		list = append(list, *room)
+	}
+	return list
+}
+
+func (this *LiveWatcher) checkAll(ctx context.Context) {
+	downloader := newBilibiliDownloaderWithCtx(ctx, this.opt.Req)
+	downloader.sink = gDiscardEventSink
+	defer downloader.closeFn()
+
+	for _, room := range this.roomList {
+		if ctx.Err() != nil {
+			return
+		}
+		live, err := downloader.getLiveInfo(room.RoomId)
+		if err != nil {
+			if ctx.Err() == nil {
+				this.emit(room.RoomId, Event{Type: EventMessage, Message: fmt.Sprintf("检查直播间 %d 失败: %s", room.RoomId, err)})
+			}
+			continue
+		}
+		// 标题只在开播时获取
+		if live.IsLive && (room.IsLive == false || room.Title == "") {
+			live.Title = downloader.getLiveRoomTitle(live.RoomId)
+		}
+		this.updateRoom(room, live)
+	}
+	this.saveState()
+}
+
+func (this *LiveWatcher) updateRoom(room *LiveRoomState, live LiveInfo) {
+	this.locker.Lock()
+	wasLive := room.IsLive
+	room.IsLive = live.IsLive
+	room.LastCheck = time.Now()
+	if live.Title != "" {
+		room.Title = live.Title
+	}
+	if live.IsLive && wasLive == false {
+		room.LiveSince = room.LastCheck
+	}
+	taskId := room.taskId
+	this.locker.Unlock()
+
+	recording := taskId != 0 && this.isTaskRunning(taskId)
+	switch {
+	case live.IsLive && wasLive == false:
+		taskId = this.startRecord(room)
+		this.emit(room.RoomId, Event{Type: EventLiveOnline, Message: "开播: " + room.Title, TaskId: taskId})
+	case live.IsLive && recording == false:
+		// 重启后或者录制任务意外结束
+		taskId = this.startRecord(room)
+		this.emit(room.RoomId, Event{Type: EventMessage, Message: "继续录制: " + room.Title, TaskId: taskId})
+	case live.IsLive == false && wasLive:
+		if recording {
+			this.manager.StopDownload(taskId)
+		}
+		this.emit(room.RoomId, Event{Type: EventLiveOffline, Message: "下播: " + room.Title, TaskId: taskId})
+	}
+}
+
+func (this *LiveWatcher) startRecord(room *LiveRoomState) int64 {
+	req := this.opt.Req
+	req.Url = fmt.Sprintf(_liveRoomUrlTemp, room.RoomId)
+	taskId := this.manager.AddTask(req)
+
+	this.locker.Lock()
+	room.taskId = taskId
+	this.locker.Unlock()
+	return taskId
+}
+
+func (this *LiveWatcher) isTaskRunning(taskId int64) bool {
+	info, ok := this.manager.GetTaskInfo(taskId)
+	return ok && (info.State == TaskStatePending || info.State == TaskStateRunning)
+}
+
+func (this *LiveWatcher) saveState() {
+	if this.opt.StateFile == "" {
+		return
+	}
+	this.locker.Lock()
+	data, _ := json.MarshalIndent(this.roomList, "", "  ")
+	this.locker.Unlock()
+
+	err := writeFileAtomic(this.opt.StateFile, data)
+	if err != nil {
+		this.emit(0, Event{Type: EventMessage, Message: "保存监控状态失败: " + err.Error()})
+	}
+}
+
+func (this *LiveWatcher) emit(roomId int64, ev Event) {
+	if this.sink == nil {
+		return
+	}
+	ev.RoomId = roomId
+	this.sink.OnEvent(ev)
+}
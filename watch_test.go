@@ -0,0 +1,126 @@
+package bilibili
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func readTestLiveState(t *testing.T, name string) (list []LiveRoomState) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(data, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// popTestWatchEvents 返回上次调用之后监控发出的事件类型和内容
+func popTestWatchEvents(r *testEventRecorder, last *int) (list []string) {
+	evList := r.getEventList()
+	for _, ev := range evList[*last:] {
+		list = append(list, ev.Type+" "+ev.Message)
+	}
+	*last = len(evList)
+	return list
+}
+
+// TestLiveWatcher 未开播 -> 开播 -> 下播, 重启后从状态文件恢复
+func TestLiveWatcher(t *testing.T) {
+	s := newTestLiveServer(t, func(n int32, w http.ResponseWriter, r *http.Request) {
+		w.Write(newTestLiveFlv(0))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	atomic.StoreInt32(&s.offline, 1)
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+	taskRecorder := newTestEventRecorder(t)
+	m := NewManager(0, taskRecorder)
+	defer m.Wait()
+	watchRecorder := newTestEventRecorder(t)
+	opt := LiveWatchOption{RoomIdList: []int64{1000}, StateFile: stateFile, Req: BeginDownload_Req{SaveDir: dir}}
+	w, err := NewLiveWatcher(m, opt, watchRecorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	var last int
+
+	w.checkAll(ctx)
+	if got := popTestWatchEvents(watchRecorder, &last); len(got) != 0 {
+		t.Fatal(got)
+	}
+	if got := readTestLiveState(t, stateFile); len(got) != 1 || got[0].RoomId != 1000 || got[0].IsLive || got[0].LastCheck.IsZero() {
+		t.Fatal(got)
+	}
+
+	// 开播: 获取一次标题, 开始录制
+	atomic.StoreInt32(&s.offline, 0)
+	w.checkAll(ctx)
+	if got := popTestWatchEvents(watchRecorder, &last); reflect.DeepEqual(got, []string{EventLiveOnline + " 开播: 测试直播"}) == false {
+		t.Fatal(got)
+	}
+	taskId := watchRecorder.getEventList()[last-1].TaskId
+	taskRecorder.waitMessage(taskId, "录制到文件")
+	state := readTestLiveState(t, stateFile)
+	if len(state) != 1 || state[0].IsLive == false || state[0].Title != "测试直播" || state[0].LiveSince.IsZero() {
+		t.Fatal(state)
+	}
+	// 录制任务自己也获取了一次标题
+	if n := atomic.LoadInt32(&s.titleCount); n != 2 {
+		t.Fatal(n)
+	}
+
+	// 直播中再次检查不重复获取标题, 也不重复录制
+	w.checkAll(ctx)
+	if got := popTestWatchEvents(watchRecorder, &last); len(got) != 0 {
+		t.Fatal(got)
+	}
+	if n := atomic.LoadInt32(&s.titleCount); n != 2 || len(m.GetTaskList()) != 1 {
+		t.Fatal(n, len(m.GetTaskList()))
+	}
+
+	// 下播: 停止录制, 录好的文件保留
+	atomic.StoreInt32(&s.offline, 1)
+	w.checkAll(ctx)
+	if got := popTestWatchEvents(watchRecorder, &last); reflect.DeepEqual(got, []string{EventLiveOffline + " 下播: 测试直播"}) == false {
+		t.Fatal(got)
+	}
+	if ev := watchRecorder.getEventList()[last-1]; ev.TaskId != taskId || ev.RoomId != 1000 {
+		t.Fatal(ev)
+	}
+	taskRecorder.waitEvent(taskId, EventFinished)
+	if got := readTestLiveState(t, stateFile); len(got) != 1 || got[0].IsLive || got[0].Title != "测试直播" || got[0].LiveSince != state[0].LiveSince {
+		t.Fatal(got)
+	}
+
+	// 重启后从状态文件读取房间列表
+	w, err = NewLiveWatcher(m, LiveWatchOption{StateFile: stateFile, Req: opt.Req}, watchRecorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.GetRoomList(); len(got) != 1 || got[0].RoomId != 1000 || got[0].Title != "测试直播" || got[0].IsLive {
+		t.Fatal(got)
+	}
+	atomic.StoreInt32(&s.offline, 0)
+	w.checkAll(ctx)
+	if got := popTestWatchEvents(watchRecorder, &last); reflect.DeepEqual(got, []string{EventLiveOnline + " 开播: 测试直播"}) == false {
+		t.Fatal(got)
+	}
+	taskId2 := watchRecorder.getEventList()[last-1].TaskId
+	if taskId2 == taskId || w.GetRoomList()[0].LiveSince.After(state[0].LiveSince) == false {
+		t.Fatal(taskId2, w.GetRoomList())
+	}
+	taskRecorder.waitMessage(taskId2, "录制到文件")
+	m.StopDownload(taskId2)
+	taskRecorder.waitEvent(taskId2, EventFinished)
+}
@@ -0,0 +1,92 @@
+package bilibili
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// b站部分接口(例如UP主投稿列表)需要 wbi 签名, 参考 https://github.com/SocialSisterYi/bilibili-API-collect/blob/master/docs/misc/sign/wbi.md
+var gWbiMixinKeyTable = []int{
+	46, 47, 18, 2, 53, 8, 23, 32, 15, 50, 10, 31, 58, 3, 45, 35, 27, 43, 5, 49,
+	33, 9, 42, 19, 29, 28, 14, 39, 12, 38, 41, 13, 37, 48, 7, 16, 24, 55, 40,
+	61, 26, 17, 0, 1, 60, 51, 30, 4, 22, 25, 54, 21, 56, 59, 6, 63, 57, 62, 11,
+	36, 20, 34, 44, 52,
+}
+
+const _navUrlTemp = "%s/x/web-interface/nav"
+
+var gWbiMixinKey string
+var gWbiMixinKeyTime time.Time
+var gWbiMixinKeyLocker sync.Mutex
+
+// getWbiMixinKey 密钥每天更新, 缓存一小时
+func (this *BilibiliDownloader) getWbiMixinKey() (string, error) {
+	gWbiMixinKeyLocker.Lock()
+	defer gWbiMixinKeyLocker.Unlock()
+
+	if gWbiMixinKey != "" && time.Since(gWbiMixinKeyTime) < time.Hour {
+		return gWbiMixinKey, nil
+	}
+	contents, err := this.defaultFetcher(fmt.Sprintf(_navUrlTemp, gBilibiliApiHost))
+	if err != nil {
+		return "", wrapError("getWbiMixinKey", err)
+	}
+	var tmp struct {
+		Data struct {
+			WbiImg struct {
+				ImgUrl string `json:"img_url"`
+				SubUrl string `json:"sub_url"`
+			} `json:"wbi_img"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(contents, &tmp)
+	if err != nil {
+		return "", wrapError("getWbiMixinKey_2", err)
+	}
+	imgKey := strings.TrimSuffix(path.Base(tmp.Data.WbiImg.ImgUrl), path.Ext(tmp.Data.WbiImg.ImgUrl))
+	subKey := strings.TrimSuffix(path.Base(tmp.Data.WbiImg.SubUrl), path.Ext(tmp.Data.WbiImg.SubUrl))
+	raw := imgKey + subKey
+	var key []byte
+	for _, idx := range gWbiMixinKeyTable {
+		if idx < len(raw) {
+			key = append(key, raw[idx])
+		}
+	}
+	if len(key) < 32 {
+		return "", newError("getWbiMixinKey", nil, fmt.Errorf("invalid wbi key %q", raw))
+	}
+	gWbiMixinKey = string(key[:32])
+	gWbiMixinKeyTime = time.Now()
+	return gWbiMixinKey, nil
+}
+
+// signWbi 给query加上 wts 和 w_rid 参数
+func (this *BilibiliDownloader) signWbi(query url.Values) (string, error) {
+	mixinKey, err := this.getWbiMixinKey()
+	if err != nil {
+		return "", err
+	}
+	signed := url.Values{}
+	for k, vList := range query {
+		for _, v := range vList {
+			signed.Add(k, strings.Map(func(r rune) rune {
+				if strings.ContainsRune("!'()*", r) {
+					return -1
+				}
+				return r
+			}, v))
+		}
+	}
+	signed.Set("wts", strconv.FormatInt(time.Now().Unix(), 10))
+	encoded := strings.Replace(signed.Encode(), "+", "%20", -1)
+	sum := md5.Sum([]byte(encoded + mixinKey))
+	return encoded + "&w_rid=" + hex.EncodeToString(sum[:]), nil
+}